@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// accountConfig is an element of the accounts file. Each account has its own
+// token, and optionally its own service filters. Filter fields which aren't
+// set take the value of the corresponding flag.
+type accountConfig struct {
+	Name             string   `json:"name"`
+	Token            string   `json:"token"`
+	TokenEnv         string   `json:"token_env"`
+	Services         []string `json:"services"`
+	ServiceAllowlist []string `json:"service_allowlist"`
+	ServiceBlocklist []string `json:"service_blocklist"`
+	ServiceShard     string   `json:"service_shard"`
+}
+
+// accountsFile is the format of the file passed via -accounts-file.
+type accountsFile struct {
+	Accounts []accountConfig `json:"accounts"`
+}
+
+var accountNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// loadAccounts reads and validates the accounts file at path. Tokens given via
+// token_env are resolved, so every returned account has a token.
+func loadAccounts(path string) ([]accountConfig, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f accountsFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(f.Accounts) == 0 {
+		return nil, fmt.Errorf("%s: no accounts", path)
+	}
+
+	var (
+		names  = map[string]bool{}
+		tokens = map[string]bool{}
+	)
+	for i := range f.Accounts {
+		a := &f.Accounts[i]
+		switch {
+		case a.Name == "":
+			return nil, fmt.Errorf("%s: account %d: name is required", path, i+1)
+		case !accountNameRegex.MatchString(a.Name):
+			return nil, fmt.Errorf("%s: account %q: name may only contain letters, digits, '_', '.', and '-'", path, a.Name)
+		case names[a.Name]:
+			return nil, fmt.Errorf("%s: account %q: duplicate name", path, a.Name)
+		}
+		names[a.Name] = true
+
+		if a.Token == "" && a.TokenEnv != "" {
+			a.Token = os.Getenv(a.TokenEnv)
+		}
+		switch {
+		case a.Token == "" && a.TokenEnv != "":
+			return nil, fmt.Errorf("%s: account %q: %s is empty", path, a.Name, a.TokenEnv)
+		case a.Token == "":
+			return nil, fmt.Errorf("%s: account %q: token or token_env is required", path, a.Name)
+		case tokens[a.Token]:
+			return nil, fmt.Errorf("%s: account %q: token is used by another account", path, a.Name)
+		}
+		tokens[a.Token] = true
+	}
+
+	return f.Accounts, nil
+}
+
+// filterConfig returns the filter config for the account, where fields which
+// aren't set by the account take their value from the global config. Metric
+// filters always apply to all accounts.
+func (a accountConfig) filterConfig(global filterConfig) filterConfig {
+	fc := global
+	if len(a.Services) > 0 {
+		fc.serviceIDs = a.Services
+	}
+	if len(a.ServiceAllowlist) > 0 {
+		fc.serviceAllowlist = a.ServiceAllowlist
+	}
+	if len(a.ServiceBlocklist) > 0 {
+		fc.serviceBlocklist = a.ServiceBlocklist
+	}
+	if a.ServiceShard != "" {
+		fc.serviceShard = a.ServiceShard
+	}
+	return fc
+}
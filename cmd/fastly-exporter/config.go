@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileParser returns a parser for the config file at path, which is read
+// when the parser is invoked, i.e. after the flags and environment variables
+// have been parsed. Files with a .yaml, .yml, or .json extension are parsed as
+// structured config files, with any service overrides stored in sc. All other
+// files are parsed with ff.PlainParser.
+func configFileParser(path *string, sc *structuredConfig) ff.ConfigFileParser {
+	return func(r io.Reader, set func(name, value string) error) error {
+		switch strings.ToLower(filepath.Ext(*path)) {
+		case ".yaml", ".yml", ".json":
+			return sc.parse(*path, r, set)
+		default:
+			return ff.PlainParser(r, set)
+		}
+	}
+}
+
+// structuredConfig is the part of a structured config file which doesn't
+// correspond to flags.
+//
+// A structured config file is a YAML (or JSON) mapping. Its keys are flag
+// names, and their values are either a single value, or a list of values for
+// repeatable flags. The only other key is "services", whose value is a list of
+// service overrides.
+type structuredConfig struct {
+	services []serviceOverride
+}
+
+// serviceOverride is an element of the services list of a structured config
+// file. It applies to the services matching either its ID or its name regex,
+// optionally only in a specific account. Fields which aren't set keep their
+// default values.
+type serviceOverride struct {
+	pos           string // file:line, for error messages
+	id            string
+	name          *regexp.Regexp
+	account       string
+	aggregateOnly *bool
+	products      []string
+	labels        map[string]string
+}
+
+func (sc *structuredConfig) parse(path string, r io.Reader, set func(name, value string) error) error {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil // empty file
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	errorf := func(n *yaml.Node, key, format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s: %s", path, n.Line, key, fmt.Sprintf(format, args...))
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of flag names to values", path, root.Line)
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+
+		if k.Value == "services" {
+			services, err := parseServiceOverrides(path, v)
+			if err != nil {
+				return err
+			}
+			sc.services = services
+			continue
+		}
+
+		var values []*yaml.Node
+		switch v.Kind {
+		case yaml.ScalarNode:
+			values = []*yaml.Node{v}
+		case yaml.SequenceNode:
+			values = v.Content
+		default:
+			return errorf(v, k.Value, "expected a value, or a list of values")
+		}
+
+		for _, value := range values {
+			if value.Kind != yaml.ScalarNode {
+				return errorf(value, k.Value, "expected a value")
+			}
+			if value.Tag == "!!null" {
+				continue
+			}
+			if err := set(k.Value, value.Value); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, value.Line, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//go:generate sh -c "go run . -config-file-schema > config.schema.json"
+
+// configSchema returns a JSON Schema of structured config files, with a key for
+// every flag of the flag set, except those which only make sense on the
+// command line.
+func configSchema(fs *flag.FlagSet) map[string]interface{} {
+	properties := map[string]interface{}{}
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "version", "config-file", "config-file-example", "config-file-schema":
+			return
+		}
+		properties[f.Name] = flagSchema(f)
+	})
+
+	labelNames := map[string]interface{}{
+		"pattern": labelNameRegex.String(),
+		"not": map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"enum": reservedLabels},
+				map[string]interface{}{"pattern": "^__"},
+			},
+		},
+	}
+	properties["services"] = map[string]interface{}{
+		"description": "overrides of settings for individual services; every override that matches a service applies, in order",
+		"type":        "array",
+		"items": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": false,
+			"oneOf": []interface{}{
+				map[string]interface{}{"required": []string{"id"}},
+				map[string]interface{}{"required": []string{"name"}},
+			},
+			"properties": map[string]interface{}{
+				"id":             map[string]interface{}{"description": "the ID of the service", "type": "string", "minLength": 1},
+				"name":           map[string]interface{}{"description": "a regex matching the names of the services", "type": "string", "format": "regex"},
+				"account":        map[string]interface{}{"description": "if set, only match the services of this account", "type": "string", "minLength": 1},
+				"aggregate_only": map[string]interface{}{"description": "takes precedence over -aggregate-only", "type": "boolean"},
+				"products":       map[string]interface{}{"description": "if set, only poll these products", "type": "array", "items": map[string]interface{}{"enum": product.Names()}},
+				"labels":         map[string]interface{}{"description": "labels added to every metric of the services", "type": "object", "propertyNames": labelNames, "additionalProperties": map[string]interface{}{"type": "string"}},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "fastly-exporter config file",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           properties,
+	}
+}
+
+// durationPattern matches the durations accepted by time.ParseDuration, other
+// than negative ones.
+const durationPattern = `^(0|([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+$`
+
+func flagSchema(f *flag.Flag) map[string]interface{} {
+	schema := map[string]interface{}{"description": f.Usage}
+	if _, ok := f.Value.(*stringslice); ok {
+		schema["oneOf"] = []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		}
+		return schema
+	}
+
+	var value interface{}
+	if g, ok := f.Value.(flag.Getter); ok {
+		value = g.Get()
+	}
+	switch value.(type) {
+	case bool:
+		schema["type"] = "boolean"
+	case int, int64, uint, uint64:
+		schema["type"] = "integer"
+	case float64:
+		schema["type"] = "number"
+	case time.Duration:
+		schema["type"] = "string"
+		schema["pattern"] = durationPattern
+	default:
+		schema["type"] = "string"
+	}
+	return schema
+}
+
+func parseServiceOverrides(path string, n *yaml.Node) ([]serviceOverride, error) {
+	errorf := func(n *yaml.Node, key, format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s: %s", path, n.Line, key, fmt.Sprintf(format, args...))
+	}
+
+	if n.Kind != yaml.SequenceNode {
+		return nil, errorf(n, "services", "expected a list of service overrides")
+	}
+
+	overrides := make([]serviceOverride, 0, len(n.Content))
+	for i, item := range n.Content {
+		where := fmt.Sprintf("services[%d]", i)
+		if item.Kind != yaml.MappingNode {
+			return nil, errorf(item, where, "expected a service override")
+		}
+
+		o := serviceOverride{pos: fmt.Sprintf("%s:%d", path, item.Line)}
+		for j := 0; j < len(item.Content); j += 2 {
+			var (
+				k, v = item.Content[j], item.Content[j+1]
+				key  = where + "." + k.Value
+			)
+			switch k.Value {
+			case "id":
+				if v.Kind != yaml.ScalarNode || v.Value == "" {
+					return nil, errorf(v, key, "expected a service ID")
+				}
+				o.id = v.Value
+
+			case "name":
+				if v.Kind != yaml.ScalarNode {
+					return nil, errorf(v, key, "expected a regex")
+				}
+				re, err := regexp.Compile(v.Value)
+				if err != nil {
+					return nil, errorf(v, key, "%v", err)
+				}
+				o.name = re
+
+			case "account":
+				if v.Kind != yaml.ScalarNode || v.Value == "" {
+					return nil, errorf(v, key, "expected an account name")
+				}
+				o.account = v.Value
+
+			case "aggregate_only":
+				var aggregateOnly bool
+				if v.Kind != yaml.ScalarNode || v.Decode(&aggregateOnly) != nil {
+					return nil, errorf(v, key, "expected true or false")
+				}
+				o.aggregateOnly = &aggregateOnly
+
+			case "products":
+				var products []string
+				if v.Kind != yaml.SequenceNode || v.Decode(&products) != nil {
+					return nil, errorf(v, key, "expected a list of products")
+				}
+				for _, name := range products {
+					if _, ok := product.Lookup(name); !ok {
+						return nil, errorf(v, key, "unknown product %q (valid products are %s)", name, strings.Join(product.Names(), ", "))
+					}
+				}
+				o.products = products
+
+			case "labels":
+				var labels map[string]string
+				if v.Kind != yaml.MappingNode || v.Decode(&labels) != nil {
+					return nil, errorf(v, key, "expected a mapping of label names to values")
+				}
+				for name := range labels {
+					if err := validateServiceLabel(name); err != nil {
+						return nil, errorf(v, key, "%v", err)
+					}
+				}
+				o.labels = labels
+
+			default:
+				return nil, errorf(k, key, "unknown key (valid keys are id, name, account, aggregate_only, products, labels)")
+			}
+		}
+
+		if (o.id == "") == (o.name == nil) {
+			return nil, errorf(item, where, "exactly one of id or name is required")
+		}
+
+		overrides = append(overrides, o)
+	}
+
+	return overrides, nil
+}
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are the names of labels which are set by the exporter on at
+// least one per-service metric, and so can't be used by service overrides.
+var reservedLabels = prom.LabelNames()
+
+func validateServiceLabel(name string) error {
+	switch {
+	case !labelNameRegex.MatchString(name) || strings.HasPrefix(name, "__"):
+		return fmt.Errorf("invalid label name %q", name)
+	case contains(reservedLabels, name):
+		return fmt.Errorf("label name %q is reserved", name)
+	default:
+		return nil
+	}
+}
+
+// serviceConfigs resolves the service overrides which apply to the services of
+// a single account. Every override that matches a service applies, in order,
+// so later overrides take precedence over earlier ones.
+type serviceConfigs struct {
+	account   string
+	overrides []serviceOverride
+	metadata  rt.MetadataProvider
+}
+
+func (c *serviceConfigs) matching(serviceID string) []serviceOverride {
+	var (
+		name, _, found = c.metadata.Metadata(serviceID)
+		matching       []serviceOverride
+	)
+	for _, o := range c.overrides {
+		switch {
+		case o.account != "" && o.account != c.account:
+			continue
+		case o.id != "" && o.id != serviceID:
+			continue
+		case o.name != nil && (!found || !o.name.MatchString(name)):
+			continue
+		}
+		matching = append(matching, o)
+	}
+	return matching
+}
+
+// ServiceConfig implements rt.ServiceConfigProvider.
+func (c *serviceConfigs) ServiceConfig(serviceID string) rt.ServiceConfig {
+	var config rt.ServiceConfig
+	for _, o := range c.matching(serviceID) {
+		if o.aggregateOnly != nil {
+			config.AggregateOnly = o.aggregateOnly
+		}
+		if o.products != nil {
+			config.Products = o.products
+		}
+	}
+	return config
+}
+
+// Labels returns the additional labels of the metrics of the service.
+func (c *serviceConfigs) Labels(serviceID string) prometheus.Labels {
+	var labels prometheus.Labels
+	for _, o := range c.matching(serviceID) {
+		for name, value := range o.labels {
+			if labels == nil {
+				labels = prometheus.Labels{}
+			}
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+func contains(list []string, s string) bool {
+	for _, candidate := range list {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
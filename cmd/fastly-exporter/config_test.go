@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestMain runs the exporter instead of the tests if RUN_FASTLY_EXPORTER is
+// set, so that tests can run it with arguments.
+func TestMain(m *testing.M) {
+	if os.Getenv("RUN_FASTLY_EXPORTER") != "" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestConfigSchema(t *testing.T) {
+	checkedIn, err := os.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-config-file-schema")
+	cmd.Env = append(os.Environ(), "RUN_FASTLY_EXPORTER=1")
+	generated, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(checkedIn, generated) {
+		t.Fatal("config.schema.json is outdated, run go generate")
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(checkedIn, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	readme, err := os.ReadFile("../../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	example := regexp.MustCompile("(?s)## Config file\n.*?```yaml\n(.*?)```").FindSubmatch(readme)
+	if example == nil {
+		t.Fatal("README.md: no example config file")
+	}
+
+	for _, testcase := range []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"README example", string(example[1]), ""},
+		{"plain values", "token: ABC123\nrt-max-rate: 2.5\nrt-max-in-flight: 10\naggregate-only: true\nseries-ttl: 1h30m\n", ""},
+		{"unknown key", "nope: 1\n", "nope: unknown key"},
+		{"command line only", "config-file: other.yaml\n", "config-file: unknown key"},
+		{"invalid duration", "service-refresh: 30\n", "service-refresh: expected string"},
+		{"invalid list", "service-allowlist: [[Prod]]\n", "service-allowlist: matches 0 of oneOf"},
+		{"no id or name", "services:\n  - aggregate_only: true\n", "services[0]: matches 0 of oneOf"},
+		{"id and name", "services:\n  - id: AAA\n    name: Prod\n", "services[0]: matches 2 of oneOf"},
+		{"unknown product", "services:\n  - id: AAA\n    products: [nope]\n", "services[0].products[0]: not in enum"},
+		{"reserved label", "services:\n  - id: AAA\n    labels: {product: x}\n", "services[0].labels.product: matches not"},
+		{"invalid label", "services:\n  - id: AAA\n    labels: {a-b: x}\n", "services[0].labels.a-b: doesn't match"},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var doc interface{}
+			if err := yaml.Unmarshal([]byte(testcase.contents), &doc); err != nil {
+				t.Fatal(err)
+			}
+			buf, err := json.Marshal(doc) // as in a JSON config file
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(buf, &doc); err != nil {
+				t.Fatal(err)
+			}
+
+			var have string
+			if errs := validateSchema(schema, doc, ""); len(errs) > 0 {
+				have = errs[0]
+			}
+			if (testcase.want == "") != (have == "") || !strings.HasPrefix(have, testcase.want) {
+				t.Errorf("want %q, have %q", testcase.want, have)
+			}
+		})
+	}
+}
+
+// validateSchema validates the value against the subset of JSON Schema used by
+// configSchema, and returns the violations.
+func validateSchema(schema map[string]interface{}, v interface{}, path string) []string {
+	errorf := func(format string, args ...interface{}) []string {
+		return []string{strings.TrimPrefix(path, ".") + ": " + fmt.Sprintf(format, args...)}
+	}
+	sub := func(s interface{}) map[string]interface{} { return s.(map[string]interface{}) }
+
+	if typ, ok := schema["type"]; ok {
+		var match bool
+		switch v := v.(type) {
+		case map[string]interface{}:
+			match = typ == "object"
+		case []interface{}:
+			match = typ == "array"
+		case string:
+			match = typ == "string"
+		case bool:
+			match = typ == "boolean"
+		case float64:
+			match = typ == "number" || (typ == "integer" && v == math.Trunc(v))
+		}
+		if !match {
+			return errorf("expected %s, have %v", typ, v)
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		var found bool
+		for _, e := range enum.([]interface{}) {
+			found = found || e == v
+		}
+		if !found {
+			return errorf("not in enum: %v", v)
+		}
+	}
+	if s, ok := v.(string); ok {
+		if pattern, ok := schema["pattern"]; ok && !regexp.MustCompile(pattern.(string)).MatchString(s) {
+			return errorf("doesn't match %s", pattern)
+		}
+		if n, ok := schema["minLength"]; ok && float64(len(s)) < n.(float64) {
+			return errorf("shorter than %v", n)
+		}
+	}
+	if not, ok := schema["not"]; ok && len(validateSchema(sub(not), v, path)) == 0 {
+		return errorf("matches not")
+	}
+	if anyOf, ok := schema["anyOf"]; ok {
+		var matches int
+		for _, s := range anyOf.([]interface{}) {
+			if len(validateSchema(sub(s), v, path)) == 0 {
+				matches++
+			}
+		}
+		if matches == 0 {
+			return errorf("matches none of anyOf")
+		}
+	}
+	if oneOf, ok := schema["oneOf"]; ok {
+		var matches int
+		for _, s := range oneOf.([]interface{}) {
+			if len(validateSchema(sub(s), v, path)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return errorf("matches %d of oneOf", matches)
+		}
+	}
+
+	var errs []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"]; ok {
+			for _, name := range required.([]interface{}) {
+				if _, ok := v[name.(string)]; !ok {
+					errs = append(errs, errorf("missing %s", name)...)
+				}
+			}
+		}
+		for name, value := range v {
+			if names, ok := schema["propertyNames"]; ok {
+				errs = append(errs, validateSchema(sub(names), name, path+"."+name)...)
+			}
+			properties, _ := schema["properties"].(map[string]interface{})
+			if s, ok := properties[name]; ok {
+				errs = append(errs, validateSchema(sub(s), value, path+"."+name)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, strings.TrimPrefix(path+"."+name, ".")+": unknown key")
+				}
+			case map[string]interface{}:
+				errs = append(errs, validateSchema(additional, value, path+"."+name)...)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(sub(items), item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fakefastly"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/run"
+)
+
+// runFakeFastly runs the fake-fastly subcommand, which serves a stand-in for
+// the Fastly APIs with synthetic services and traffic. Point an exporter at
+// it with -api-url and -rt-url.
+func runFakeFastly(args []string) int {
+	var (
+		listen   string
+		services int
+		config   fakefastly.Config
+		shape    = fakefastly.DefaultShape
+	)
+
+	fs := flag.NewFlagSet("fake-fastly", flag.ContinueOnError)
+	{
+		fs.StringVar(&listen, "listen", "127.0.0.1:8081", "listen address for the fake Fastly APIs")
+		fs.IntVar(&services, "services", 3, "number of fake services")
+		fs.Uint64Var(&config.Seed, "seed", 0, "seed for the pseudo-random variation of the traffic")
+		fs.Float64Var(&shape.RequestsPerSecond, "requests-per-second", shape.RequestsPerSecond, "peak requests per second of the busiest service")
+		fs.Float64Var(&shape.DiurnalAmplitude, "diurnal-amplitude", shape.DiurnalAmplitude, "fraction (0–1) by which traffic drops from its peak to its trough")
+		fs.DurationVar(&shape.DiurnalPeriod, "diurnal-period", shape.DiurnalPeriod, "period of the traffic cycle")
+		fs.Float64Var(&shape.HitRatio, "hit-ratio", shape.HitRatio, "fraction (0–1) of requests served from cache")
+		fs.Float64Var(&shape.ErrorRatio, "error-ratio", shape.ErrorRatio, "fraction (0–1) of requests which fail outside of error bursts")
+		fs.DurationVar(&shape.ErrorBurstEvery, "error-burst-every", shape.ErrorBurstEvery, "interval between error bursts; a value of 0 disables them")
+		fs.DurationVar(&shape.ErrorBurstDuration, "error-burst-duration", shape.ErrorBurstDuration, "duration of each error burst")
+		fs.Float64Var(&shape.ErrorBurstRatio, "error-burst-ratio", shape.ErrorBurstRatio, "fraction (0–1) of requests which fail during error bursts")
+		fs.DurationVar(&shape.FailoverEvery, "failover-every", shape.FailoverEvery, "interval between datacenter failovers; a value of 0 disables them")
+		fs.DurationVar(&shape.FailoverDuration, "failover-duration", shape.FailoverDuration, "duration of each datacenter failover")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "USAGE\n")
+			fmt.Fprintf(os.Stderr, "  fastly-exporter fake-fastly [flags]\n")
+			fmt.Fprintf(os.Stderr, "\n")
+			fmt.Fprintf(os.Stderr, "FLAGS\n")
+			tw := tabwriter.NewWriter(os.Stderr, 0, 2, 2, ' ', 0)
+			fs.VisitAll(func(f *flag.Flag) {
+				fmt.Fprintf(tw, "  -%s %s\t%s\n", f.Name, f.DefValue, f.Usage)
+			})
+			tw.Flush()
+			fmt.Fprintf(os.Stderr, "\n")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	logger := level.NewFilter(log.NewLogfmtLogger(os.Stderr), level.AllowInfo())
+
+	if services < 1 {
+		level.Error(logger).Log("err", "-services must be at least 1")
+		return 1
+	}
+	config.Services = fakefastly.DefaultServices(services)
+	config.Shape = shape
+
+	var g run.Group
+	{
+		server := &http.Server{
+			Addr:    listen,
+			Handler: fakefastly.NewServer(config),
+		}
+		g.Add(func() error {
+			level.Info(logger).Log("listen", listen, "services", services, "seed", config.Seed)
+			return server.ListenAndServe()
+		}, func(error) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		})
+	}
+	{
+		sigs := make(chan os.Signal, 1)
+		done := make(chan struct{})
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		g.Add(func() error {
+			select {
+			case sig := <-sigs:
+				return fmt.Errorf("received signal %s", sig)
+			case <-done:
+				return nil
+			}
+		}, func(error) {
+			signal.Stop(sigs)
+			close(done)
+		})
+	}
+
+	level.Info(logger).Log("exit", g.Run())
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// filterConfig collects the flags which restrict the exported services and
+// metrics. They can be changed without restarting the exporter.
+type filterConfig struct {
+	serviceShard     string
+	serviceIDs       stringslice
+	serviceAllowlist stringslice
+	serviceBlocklist stringslice
+	metricAllowlist  stringslice
+	metricBlocklist  stringslice
+}
+
+func (fc *filterConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&fc.serviceShard, "service-shard", "", "if set, only include services whose hashed IDs modulo m equal n-1 (format 'n/m')")
+	fs.Var(&fc.serviceIDs, "service", "if set, only include this service ID (repeatable)")
+	fs.Var(&fc.serviceAllowlist, "service-allowlist", "if set, only include services whose names match this regex (repeatable)")
+	fs.Var(&fc.serviceBlocklist, "service-blocklist", "if set, don't include services whose names match this regex (repeatable)")
+	fs.Var(&fc.metricAllowlist, "metric-allowlist", "if set, only export metrics whose names match this regex (repeatable)")
+	fs.Var(&fc.metricBlocklist, "metric-blocklist", "if set, don't export metrics whose names match this regex (repeatable)")
+}
+
+// serviceCacheOptions validates the service filters, and returns the
+// corresponding service cache options. Each filter is logged as it's built.
+func (fc *filterConfig) serviceCacheOptions(logger log.Logger) ([]api.ServiceCacheOption, error) {
+	var serviceNameFilter filter.Filter
+	{
+		for _, expr := range fc.serviceAllowlist {
+			if err := serviceNameFilter.Allow(expr); err != nil {
+				return nil, fmt.Errorf("invalid -service-allowlist: %w", err)
+			}
+			level.Info(logger).Log("filter", "services", "type", "name allowlist", "expr", expr)
+		}
+		for _, expr := range fc.serviceBlocklist {
+			if err := serviceNameFilter.Block(expr); err != nil {
+				return nil, fmt.Errorf("invalid -service-blocklist: %w", err)
+			}
+			level.Info(logger).Log("filter", "services", "type", "name blocklist", "expr", expr)
+		}
+	}
+
+	var shardN, shardM uint64
+	if fc.serviceShard != "" {
+		var err error
+		if shardN, shardM, err = parseShard(fc.serviceShard); err != nil {
+			return nil, err
+		}
+		level.Info(logger).Log("filter", "services", "type", "by shard", "n", shardN, "m", shardM)
+	}
+
+	serviceCacheOptions := []api.ServiceCacheOption{
+		api.WithNameFilter(serviceNameFilter),
+	}
+
+	if len(fc.serviceIDs) > 0 {
+		level.Info(logger).Log("filter", "services", "type", "explicit service IDs", "count", len(fc.serviceIDs))
+		serviceCacheOptions = append(serviceCacheOptions, api.WithExplicitServiceIDs(fc.serviceIDs...))
+	}
+
+	if shardM > 0 {
+		serviceCacheOptions = append(serviceCacheOptions, api.WithShard(shardN, shardM))
+	}
+
+	return serviceCacheOptions, nil
+}
+
+// metricNameFilter validates the metric filters, and returns the corresponding
+// filter. Each filter is logged as it's built.
+func (fc *filterConfig) metricNameFilter(logger log.Logger) (filter.Filter, error) {
+	var metricNameFilter filter.Filter
+	for _, expr := range fc.metricAllowlist {
+		if err := metricNameFilter.Allow(expr); err != nil {
+			return filter.Filter{}, fmt.Errorf("invalid -metric-allowlist: %w", err)
+		}
+		level.Info(logger).Log("filter", "metrics", "type", "name allowlist", "expr", expr)
+	}
+	for _, expr := range fc.metricBlocklist {
+		if err := metricNameFilter.Block(expr); err != nil {
+			return filter.Filter{}, fmt.Errorf("invalid -metric-blocklist: %w", err)
+		}
+		level.Info(logger).Log("filter", "metrics", "type", "name blocklist", "expr", expr)
+	}
+	return metricNameFilter, nil
+}
+
+func parseShard(serviceShard string) (n, m uint64, err error) {
+	toks := strings.SplitN(serviceShard, "/", 2)
+	if len(toks) != 2 {
+		return 0, 0, fmt.Errorf("-service-shard must be of the format 'n/m'")
+	}
+	n, err = strconv.ParseUint(toks[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("-service-shard must be of the format 'n/m'")
+	}
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("first part of -service-shard flag should be greater than zero")
+	}
+	m, err = strconv.ParseUint(toks[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("-service-shard must be of the format 'n/m'")
+	}
+	if n > m {
+		return 0, 0, fmt.Errorf("-service-shard with n=%d m=%d is invalid: n must be less than or equal to m", n, m)
+	}
+	return n, m, nil
+}
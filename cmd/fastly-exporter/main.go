@@ -3,88 +3,131 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
 	"github.com/fastly/fastly-exporter/pkg/api"
-	"github.com/fastly/fastly-exporter/pkg/filter"
-	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/exporter"
+	"github.com/fastly/fastly-exporter/pkg/health"
+	"github.com/fastly/fastly-exporter/pkg/outbound"
+	"github.com/fastly/fastly-exporter/pkg/recording"
 	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/fastly/fastly-exporter/pkg/web"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/prometheus/client_golang/prometheus"
-	"golang.org/x/sync/errgroup"
 )
 
 var programVersion = "dev"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fake-fastly" {
+		os.Exit(runFakeFastly(os.Args[2:]))
+	}
+
 	var (
 		token               string
+		accountsFile        string
 		listen              string
+		adminListen         string
+		webConfigFile       string
 		namespace           string
 		deprecatedSubsystem string
-		serviceShard        string
-		serviceIDs          stringslice
-		serviceAllowlist    stringslice
-		serviceBlocklist    stringslice
-		metricAllowlist     stringslice
-		metricBlocklist     stringslice
+		filterConfig        filterConfig
+		outboundConfig      outboundConfig
+		recordDir           string
+		replayDir           string
 		certificateRefresh  time.Duration
 		datacenterRefresh   time.Duration
 		productRefresh      time.Duration
 		serviceRefresh      time.Duration
 		dictionaryRefresh   time.Duration
+		tokenRefresh        time.Duration
+		serviceExpiry       time.Duration
+		seriesTTL           time.Duration
 		apiTimeout          time.Duration
+		apiThrottleBelow    int
 		rtTimeout           time.Duration
+		rtMaxInFlight       int
+		rtMaxRate           float64
+		rtStallWindow       time.Duration
 		aggregateOnly       bool
+		rtBackfill          bool
+		stateFile           string
+		stateInterval       time.Duration
+		readinessCaches     string
+		readinessStaleness  time.Duration
+		readinessFailing    float64
 		debug               bool
 		versionFlag         bool
+		configFile          string
+		configFileWatch     time.Duration
 		configFileExample   bool
+		configFileSchema    bool
 	)
 
 	fs := flag.NewFlagSet("fastly-exporter", flag.ContinueOnError)
 	{
-		fs.StringVar(&token, "token", "", "Fastly API token (required)")
+		fs.StringVar(&token, "token", "", "Fastly API token (required, unless -accounts-file is set)")
+		fs.StringVar(&accountsFile, "accounts-file", "", "if set, export the services of every account in this JSON file, each with its own token and service filters")
 		fs.StringVar(&listen, "listen", "127.0.0.1:8080", "listen address for Prometheus metrics")
+		fs.StringVar(&adminListen, "admin-listen", "", "if set, serve the admin API, e.g. to refresh caches, or to pause services, on this address; it isn't authenticated, so keep it private")
+		fs.StringVar(&webConfigFile, "web-config-file", "", "if set, serve with the TLS and authentication settings in this YAML file")
 		fs.StringVar(&namespace, "namespace", "fastly", "Prometheus namespace")
 		fs.StringVar(&deprecatedSubsystem, "subsystem", "rt", "DEPRECATED -- will be fixed to 'rt' in a future version")
-		fs.StringVar(&serviceShard, "service-shard", "", "if set, only include services whose hashed IDs modulo m equal n-1 (format 'n/m')")
-		fs.Var(&serviceIDs, "service", "if set, only include this service ID (repeatable)")
-		fs.Var(&serviceAllowlist, "service-allowlist", "if set, only include services whose names match this regex (repeatable)")
-		fs.Var(&serviceBlocklist, "service-blocklist", "if set, don't include services whose names match this regex (repeatable)")
-		fs.Var(&metricAllowlist, "metric-allowlist", "if set, only export metrics whose names match this regex (repeatable)")
-		fs.Var(&metricBlocklist, "metric-blocklist", "if set, don't export metrics whose names match this regex (repeatable)")
+		filterConfig.register(fs)
 		fs.DurationVar(&certificateRefresh, "certificate-refresh", 6*time.Hour, "how often to poll api.fastly.com for updated custom TLS certificate metadata (10m–24h); a value of 0 will disable certificate refresh")
 		fs.DurationVar(&datacenterRefresh, "datacenter-refresh", 10*time.Minute, "how often to poll api.fastly.com for updated datacenter metadata (10m–1h)")
 		fs.DurationVar(&productRefresh, "product-refresh", 10*time.Minute, "how often to poll api.fastly.com for updated product metadata (10m–24h)")
 		fs.DurationVar(&serviceRefresh, "service-refresh", 1*time.Minute, "how often to poll api.fastly.com for updated service metadata (15s–10m)")
 		fs.DurationVar(&dictionaryRefresh, "dictionary-refresh", 5*time.Minute, "how often to poll api.fastly.com for dictionary metadata (1m–24h)")
+		fs.DurationVar(&tokenRefresh, "token-refresh", time.Hour, "how often to poll api.fastly.com for the expiration of the token (10m–24h)")
+		fs.DurationVar(&seriesTTL, "series-ttl", 0, "if set, delete series for datacenters, origins, domains, and service versions that haven't been updated for this long (at least 5m); a value of 0 keeps them forever")
+		fs.DurationVar(&serviceExpiry, "service-expiry", 0, "how long to keep exporting metrics for a service after it's no longer found; a value of 0 removes them on the next service refresh")
 
 		fs.DurationVar(&serviceRefresh, "api-refresh", 1*time.Minute, "DEPRECATED -- use service-refresh instead")
 		fs.DurationVar(&apiTimeout, "api-timeout", 15*time.Second, "HTTP client timeout for api.fastly.com requests (5–60s)")
+		fs.IntVar(&apiThrottleBelow, "api-throttle-below", outbound.DefaultThrottleBelow, "when fewer api.fastly.com requests than this remain in the rate limit window of a token, spread the remaining ones until the window resets; a value of 0 disables throttling")
 		fs.DurationVar(&rtTimeout, "rt-timeout", 45*time.Second, "HTTP client timeout for rt.fastly.com requests (45–120s)")
+		fs.IntVar(&rtMaxInFlight, "rt-max-in-flight", 0, "if set, cap the rt.fastly.com requests in flight across all services and products, queueing the rest fairly by service")
+		fs.Float64Var(&rtMaxRate, "rt-max-rate", 0, "if set, cap the rt.fastly.com requests started per second across all services and products, queueing the rest fairly by service")
+		fs.DurationVar(&rtStallWindow, "rt-stall-window", 0, "if set, restart real-time subscribers which haven't advanced for this long (at least twice -rt-timeout); a value of 0 disables the watchdog")
+		outboundConfig.register(fs)
+		fs.StringVar(&recordDir, "record-dir", "", "if set, record all requests to the Fastly APIs and their responses in this directory, with tokens redacted")
+		fs.StringVar(&replayDir, "replay-dir", "", "if set, don't send requests to the Fastly APIs, but replay the responses recorded in this directory via -record-dir")
 		fs.BoolVar(&aggregateOnly, "aggregate-only", false, "Use aggregated data rather than per-datacenter")
+		fs.StringVar(&stateFile, "state-file", "", "if set, persist counters, histograms, and the progress of real-time subscribers to this file, and restore them on startup")
+		fs.DurationVar(&stateInterval, "state-interval", time.Minute, "how often to write the -state-file, in addition to on shutdown")
+		fs.BoolVar(&rtBackfill, "rt-backfill", true, "on startup and after failed requests, backfill the last 120s of real-time stats, skipping seconds already exported")
+		fs.StringVar(&readinessCaches, "readiness-caches", "services", "comma-separated caches which must have been refreshed successfully for /readyz to succeed (services, products, certificates, datacenters, dictionaries, token)")
+		fs.DurationVar(&readinessStaleness, "readiness-max-staleness", 0, "if set, /readyz fails if any of the -readiness-caches wasn't refreshed successfully within this duration")
+		fs.Float64Var(&readinessFailing, "readiness-max-failing", 1, "/readyz fails if more than this fraction (0–1) of subscribers are failing or terminated; a value of 1 disables the check")
 		fs.BoolVar(&debug, "debug", false, "log debug information")
 		fs.BoolVar(&versionFlag, "version", false, "print version information and exit")
-		fs.String("config-file", "", "config file (optional)")
+		fs.StringVar(&configFile, "config-file", "", "config file (optional)")
+		fs.DurationVar(&configFileWatch, "config-file-watch", 0, "if set, check the config file for changes at this interval, and reload filters when it changes")
 		fs.BoolVar(&configFileExample, "config-file-example", false, "print example config file to stdout and exit")
+		fs.BoolVar(&configFileSchema, "config-file-schema", false, "print the JSON Schema of structured config files to stdout and exit")
 		fs.Usage = usageFor(fs)
 	}
-	if err := ff.Parse(fs, os.Args[1:],
-		ff.WithEnvVarPrefix("FASTLY_EXPORTER"),
+	var structured structuredConfig
+	ffOptions := []ff.Option{
 		ff.WithConfigFileFlag("config-file"),
-		ff.WithConfigFileParser(ff.PlainParser),
-	); err != nil {
+		ff.WithConfigFileParser(configFileParser(&configFile, &structured)),
+	}
+	if err := parseFlags(fs, os.Args[1:], ffOptions...); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
@@ -99,15 +142,45 @@ func main() {
 		os.Exit(0)
 	}
 
+	if configFileSchema {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(configSchema(fs))
+		os.Exit(0)
+	}
+
 	var logger log.Logger
 	{
 		logger = log.NewLogfmtLogger(os.Stderr)
 		logger = level.NewFilter(logger, getLogLevel(debug))
 	}
 
-	if token == "" {
-		if token = os.Getenv("FASTLY_API_TOKEN"); token == "" {
-			level.Error(logger).Log("err", "-token or FASTLY_API_TOKEN is required")
+	var accountConfigs []accountConfig
+	if accountsFile != "" {
+		if token != "" {
+			level.Error(logger).Log("err", "-token and -accounts-file are mutually exclusive")
+			os.Exit(1)
+		}
+		var err error
+		if accountConfigs, err = loadAccounts(accountsFile); err != nil {
+			level.Error(logger).Log("during", "load accounts", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		if token == "" {
+			if token = os.Getenv("FASTLY_API_TOKEN"); token == "" {
+				level.Error(logger).Log("err", "-token, FASTLY_API_TOKEN, or -accounts-file is required")
+				os.Exit(1)
+			}
+		}
+		accountConfigs = []accountConfig{{Token: token}}
+	}
+
+	var webConfig web.Config
+	if webConfigFile != "" {
+		var err error
+		if webConfig, err = web.LoadConfig(webConfigFile); err != nil {
+			level.Error(logger).Log("during", "load web config", "err", err)
 			os.Exit(1)
 		}
 	}
@@ -169,6 +242,26 @@ func main() {
 			level.Warn(logger).Log("msg", "-dictionary-refresh cannot be longer than 24h; setting it to 24h")
 			dictionaryRefresh = 24 * time.Hour
 		}
+		if tokenRefresh < 10*time.Minute {
+			level.Warn(logger).Log("msg", "-token-refresh cannot be shorter than 10m; setting it to 10m")
+			tokenRefresh = 10 * time.Minute
+		}
+		if tokenRefresh > 24*time.Hour {
+			level.Warn(logger).Log("msg", "-token-refresh cannot be longer than 24h; setting it to 24h")
+			tokenRefresh = 24 * time.Hour
+		}
+		if seriesTTL != 0 && seriesTTL < 5*time.Minute {
+			level.Warn(logger).Log("msg", "-series-ttl cannot be shorter than 5m; setting it to 5m")
+			seriesTTL = 5 * time.Minute
+		}
+		if serviceExpiry < 0 {
+			level.Warn(logger).Log("msg", "-service-expiry cannot be negative; setting it to 0")
+			serviceExpiry = 0
+		}
+		if readinessFailing < 0 {
+			level.Warn(logger).Log("msg", "-readiness-max-failing cannot be negative; setting it to 0")
+			readinessFailing = 0
+		}
 		if apiTimeout < 5*time.Second {
 			level.Warn(logger).Log("msg", "-api-timeout cannot be shorter than 5s; setting it to 5s")
 			apiTimeout = 5 * time.Second
@@ -177,6 +270,10 @@ func main() {
 			level.Warn(logger).Log("msg", "-api-timeout cannot be longer than 60s; setting it to 60s")
 			apiTimeout = 60 * time.Second
 		}
+		if apiThrottleBelow < 0 {
+			level.Warn(logger).Log("msg", "-api-throttle-below cannot be negative; disabling it")
+			apiThrottleBelow = 0
+		}
 		if rtTimeout < 45*time.Second {
 			level.Warn(logger).Log("msg", "-rt-timeout cannot be shorter than 45s; setting it to 45s")
 			rtTimeout = 45 * time.Second
@@ -185,80 +282,58 @@ func main() {
 			level.Warn(logger).Log("msg", "-rt-timeout cannot be longer than 120s; setting it to 120s")
 			rtTimeout = 120 * time.Second
 		}
-	}
-
-	var serviceNameFilter filter.Filter
-	{
-		for _, expr := range serviceAllowlist {
-			if err := serviceNameFilter.Allow(expr); err != nil {
-				level.Error(logger).Log("err", "invalid -service-allowlist", "msg", err)
-				os.Exit(1)
-			}
-			level.Info(logger).Log("filter", "services", "type", "name allowlist", "expr", expr)
+		if rtMaxInFlight < 0 {
+			level.Warn(logger).Log("msg", "-rt-max-in-flight cannot be negative; disabling it")
+			rtMaxInFlight = 0
 		}
-		for _, expr := range serviceBlocklist {
-			if err := serviceNameFilter.Block(expr); err != nil {
-				level.Error(logger).Log("err", "invalid -service-blocklist", "msg", err)
-				os.Exit(1)
-			}
-			level.Info(logger).Log("filter", "services", "type", "name blocklist", "expr", expr)
+		if rtMaxRate < 0 {
+			level.Warn(logger).Log("msg", "-rt-max-rate cannot be negative; disabling it")
+			rtMaxRate = 0
+		}
+		if rtStallWindow < 0 {
+			level.Warn(logger).Log("msg", "-rt-stall-window cannot be negative; disabling it")
+			rtStallWindow = 0
+		}
+		if rtStallWindow != 0 && rtStallWindow < 2*rtTimeout {
+			level.Warn(logger).Log("msg", "-rt-stall-window cannot be shorter than twice -rt-timeout; setting it to "+(2*rtTimeout).String())
+			rtStallWindow = 2 * rtTimeout
 		}
 	}
 
-	var metricNameFilter filter.Filter
 	{
-		for _, expr := range metricAllowlist {
-			if err := metricNameFilter.Allow(expr); err != nil {
-				level.Error(logger).Log("err", "invalid -metric-allowlist", "msg", err)
-				os.Exit(1)
-			}
-			level.Info(logger).Log("filter", "metrics", "type", "name allowlist", "expr", expr)
-
+		names := make(map[string]bool, len(accountConfigs))
+		for _, ac := range accountConfigs {
+			names[ac.Name] = true
 		}
-		for _, expr := range metricBlocklist {
-			if err := metricNameFilter.Block(expr); err != nil {
-				level.Error(logger).Log("err", "invalid -metric-blocklist", "msg", err)
+		for _, o := range structured.services {
+			if o.account != "" && !names[o.account] {
+				level.Error(logger).Log("err", fmt.Sprintf("%s: service override for unknown account %q", o.pos, o.account))
 				os.Exit(1)
 			}
-			level.Info(logger).Log("filter", "metrics", "type", "name blocklist", "expr", expr)
 		}
 	}
 
-	var shardN, shardM uint64
+	var readinessCriteria health.Criteria
 	{
-		if serviceShard != "" {
-			toks := strings.SplitN(serviceShard, "/", 2)
-			if len(toks) != 2 {
-				level.Error(logger).Log("err", "-service-shard must be of the format 'n/m'")
-				os.Exit(1)
-			}
-			var err error
-			shardN, err = strconv.ParseUint(toks[0], 10, 64)
-			if err != nil {
-				level.Error(logger).Log("err", "-service-shard must be of the format 'n/m'")
-				os.Exit(1)
-			}
-			if shardN <= 0 {
-				level.Error(logger).Log("err", "first part of -service-shard flag should be greater than zero")
+		for _, name := range strings.Split(readinessCaches, ",") {
+			switch name = strings.TrimSpace(name); name {
+			case "":
+				continue
+			case "services", "products", "certificates", "datacenters", "dictionaries", "token":
+				readinessCriteria.RequiredCaches = append(readinessCriteria.RequiredCaches, name)
+			default:
+				level.Error(logger).Log("err", fmt.Sprintf("-readiness-caches: unknown cache %q", name))
 				os.Exit(1)
 			}
-			shardM, err = strconv.ParseUint(toks[1], 10, 64)
-			if err != nil {
-				level.Error(logger).Log("err", "-service-shard must be of the format 'n/m'")
-				os.Exit(1)
-			}
-			if shardN > shardM {
-				level.Error(logger).Log("err", fmt.Sprintf("-service-shard with n=%d m=%d is invalid: n must be less than or equal to m", shardN, shardM))
-				os.Exit(1)
-			}
-			level.Info(logger).Log("filter", "services", "type", "by shard", "n", shardN, "m", shardM)
-
 		}
+		readinessCriteria.MaxStaleness = readinessStaleness
+		readinessCriteria.MaxFailingRatio = readinessFailing
 	}
 
-	var apiLogger log.Logger
-	{
-		apiLogger = log.With(logger, "component", "api.fastly.com")
+	metricNameFilter, err := filterConfig.metricNameFilter(logger)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		os.Exit(1)
 	}
 
 	var userAgent string
@@ -266,328 +341,243 @@ func main() {
 		userAgent = `Fastly-Exporter (` + programVersion + `)`
 	}
 
-	var apiClient *http.Client
-	{
-		apiClient = &http.Client{
-			Timeout:   apiTimeout,
-			Transport: userAgentTransport(http.DefaultTransport, userAgent),
-		}
-	}
-
-	var serviceCache *api.ServiceCache
+	var (
+		transport    http.RoundTripper
+		instrumented *outbound.Transport
+	)
 	{
-		serviceCacheOptions := []api.ServiceCacheOption{
-			api.WithLogger(apiLogger),
-			api.WithNameFilter(serviceNameFilter),
+		t, err := outboundConfig.transport()
+		if err != nil {
+			level.Error(logger).Log("during", "configure outbound requests", "err", err)
+			os.Exit(1)
 		}
-
-		if len(serviceIDs) > 0 {
-			level.Info(logger).Log("filter", "services", "type", "explicit service IDs", "count", len(serviceIDs))
-			serviceCacheOptions = append(serviceCacheOptions, api.WithExplicitServiceIDs(serviceIDs...))
+		accountNames := make(map[string]string, len(accountConfigs))
+		for _, ac := range accountConfigs {
+			accountNames[ac.Token] = ac.Name
 		}
-
-		if shardM > 0 {
-			level.Info(logger).Log("filter", "services", "type", "shard", "shard", fmt.Sprintf("%d/%d", shardN, shardM))
-			serviceCacheOptions = append(serviceCacheOptions, api.WithShard(shardN, shardM))
+		instrumented = outbound.NewTransport(userAgentTransport(t, userAgent), outbound.WithAccountNames(accountNames), outbound.WithThrottleBelow(apiThrottleBelow))
+		transport = instrumented
+		if outboundConfig.apiURL != api.DefaultBaseURL || outboundConfig.rtURL != rt.DefaultBaseURL {
+			level.Info(logger).Log("api_url", outboundConfig.apiURL, "rt_url", outboundConfig.rtURL)
 		}
-
-		serviceCache = api.NewServiceCache(apiClient, token, serviceCacheOptions...)
-	}
-
-	var certificateCache *api.CertificateCache
-	{
-		enabled := certificateRefresh != 0 && !metricNameFilter.Blocked(prometheus.BuildFQName(namespace, deprecatedSubsystem, "cert_expiry_timestamp_seconds"))
-		certificateCache = api.NewCertificateCache(apiClient, token, enabled, logger)
-	}
-	var datacenterCache *api.DatacenterCache
-	{
-		enabled := !metricNameFilter.Blocked(prometheus.BuildFQName(namespace, deprecatedSubsystem, "datacenter_info"))
-		datacenterCache = api.NewDatacenterCache(apiClient, token, enabled)
-	}
-
-	var productCache *api.ProductCache
-	{
-		productCache = api.NewProductCache(apiClient, token, apiLogger)
 	}
 
-	// Dictionary info cache (digest, item_count, last_updated) -> Prom metrics
-	var dictionaryCache *api.DictionaryInfoCache
+	// wrapClient records or replays the requests of an HTTP client, if enabled.
+	var wrapClient func(*http.Client) recording.HTTPClient
 	{
-		enabled := !metricNameFilter.Blocked(prometheus.BuildFQName(namespace, deprecatedSubsystem, "dictionary_item_count"))
-		dictionaryCache = api.NewDictionaryInfoCache(apiClient, token, apiLogger, serviceCache, enabled)
-	}
+		switch {
+		case recordDir != "" && replayDir != "":
+			level.Error(logger).Log("err", "-record-dir and -replay-dir are mutually exclusive")
+			os.Exit(1)
 
-	{
-		var g errgroup.Group
-		g.Go(func() error {
-			if err := serviceCache.Refresh(context.Background()); err != nil {
-				level.Warn(logger).Log("during", "initial fetch of service IDs", "err", err, "msg", "service metrics unavailable, will retry")
-			}
-			return nil
-		})
-		if certificateCache.Enabled() {
-			g.Go(func() error {
-				if err := certificateCache.Refresh(context.Background()); err != nil {
-					if certificateCache.Enabled() {
-						level.Warn(logger).Log("during", "initial fetch of certificates", "err", err, "msg", "certificate metrics unavailable, will retry")
-					} else {
-						level.Warn(logger).Log("during", "initial fetch of certificates", "err", err, "msg", "Disabling TLS certificate refresh. FASTLY_API_TOKEN must have the TLS management scope")
-					}
-				}
-				return nil
-			})
-		}
-		if datacenterCache.Enabled() {
-			g.Go(func() error {
-				if err := datacenterCache.Refresh(context.Background()); err != nil {
-					level.Warn(logger).Log("during", "initial fetch of datacenters", "err", err, "msg", "datacenter labels unavailable, will retry")
-				}
-				return nil
-			})
-		}
-		if dictionaryCache.Enabled() {
-			g.Go(func() error {
-				if err := dictionaryCache.Refresh(context.Background()); err != nil {
-					level.Warn(logger).Log("during", "initial fetch of dictionary info", "err", err, "msg", "dictionary info metrics unavailable, will retry")
-				}
-				return nil
-			})
-		}
-		g.Go(func() error {
-			if err := productCache.Refresh(context.Background()); err != nil {
-				level.Warn(logger).Log("during", "initial fetch of products", "err", err, "msg", "products API unavailable, will retry")
+		case recordDir != "":
+			recorder, err := recording.NewRecorder(recordDir, log.With(logger, "component", "recorder"))
+			if err != nil {
+				level.Error(logger).Log("during", "create recorder", "err", err)
+				os.Exit(1)
 			}
-			return nil
-		})
+			level.Info(logger).Log("msg", "recording requests to the Fastly APIs", "dir", recordDir)
+			wrapClient = func(c *http.Client) recording.HTTPClient { return recorder.Client(c) }
 
-		g.Wait()
-	}
+		case replayDir != "":
+			replayer, err := recording.NewReplayer(replayDir)
+			if err != nil {
+				level.Error(logger).Log("during", "load recording", "err", err)
+				os.Exit(1)
+			}
+			level.Info(logger).Log("msg", "replaying recorded responses instead of sending requests to the Fastly APIs", "dir", replayDir)
+			wrapClient = func(*http.Client) recording.HTTPClient { return replayer }
 
-	var defaultGatherers prometheus.Gatherers
-	if certificateCache.Enabled() {
-		certs, err := certificateCache.Gatherer(namespace, deprecatedSubsystem)
-		if err != nil {
-			level.Error(apiLogger).Log("during", "create certificate gatherer", "err", err)
-			os.Exit(1)
+		default:
+			wrapClient = func(c *http.Client) recording.HTTPClient { return c }
 		}
-		defaultGatherers = append(defaultGatherers, certs)
 	}
 
-	if datacenterCache.Enabled() {
-		dcs, err := datacenterCache.Gatherer(namespace, deprecatedSubsystem)
+	var outboundGatherer prometheus.Gatherer
+	{
+		g, err := instrumented.Gatherer(namespace, deprecatedSubsystem)
 		if err != nil {
-			level.Error(apiLogger).Log("during", "create datacenter gatherer", "err", err)
+			level.Error(logger).Log("during", "create outbound gatherer", "err", err)
 			os.Exit(1)
 		}
-		defaultGatherers = append(defaultGatherers, dcs)
+		outboundGatherer = g
 	}
 
-	if dictionaryCache.Enabled() {
-		di, err := dictionaryCache.Gatherer(namespace, deprecatedSubsystem)
-		if err != nil {
-			level.Error(apiLogger).Log("during", "create dictionary info gatherer", "err", err)
-			os.Exit(1)
+	var e *exporter.Exporter
+	{
+		config := exporter.Config{
+			Namespace:           namespace,
+			Subsystem:           deprecatedSubsystem,
+			MetricNameFilter:    metricNameFilter,
+			ServiceRefresh:      serviceRefresh,
+			ProductRefresh:      productRefresh,
+			DatacenterRefresh:   datacenterRefresh,
+			CertificateRefresh:  certificateRefresh,
+			DictionaryRefresh:   dictionaryRefresh,
+			TokenRefresh:        tokenRefresh,
+			DisableCertificates: certificateRefresh == 0,
+			ServiceExpiry:       serviceExpiry,
+			SeriesTTL:           seriesTTL,
+			AggregateOnly:       aggregateOnly,
+			DisableBackfill:     !rtBackfill,
+			StateFile:           stateFile,
+			StateInterval:       stateInterval,
+			APIClient:           wrapClient(&http.Client{Timeout: apiTimeout, Transport: transport}),
+			RTClient:            wrapClient(&http.Client{Timeout: rtTimeout, Transport: transport}),
+			APIThrottle:         instrumented,
+			Gatherers:           []prometheus.Gatherer{outboundGatherer},
+			APIBaseURL:          outboundConfig.apiURL,
+			RTBaseURL:           outboundConfig.rtURL,
+			RTMaxInFlight:       rtMaxInFlight,
+			RTMaxRate:           rtMaxRate,
+			RTStallWindow:       rtStallWindow,
+			Readiness:           readinessCriteria,
+			Logger:              logger,
+			Version:             programVersion,
 		}
-		defaultGatherers = append(defaultGatherers, di)
-	}
-
-	if !metricNameFilter.Blocked(prometheus.BuildFQName(namespace, deprecatedSubsystem, "token_expiration")) {
-		tokenRecorder := api.NewTokenRecorder(apiClient, token)
-		tg, err := tokenRecorder.Gatherer(namespace, deprecatedSubsystem)
-		if err != nil {
-			level.Error(apiLogger).Log("during", "create token gatherer", "err", err)
-		} else {
-			err = tokenRecorder.Set(context.Background())
+		for _, ac := range accountConfigs {
+			accountLogger := logger
+			if ac.Name != "" {
+				accountLogger = log.With(logger, "account", ac.Name)
+			}
+			fc := ac.filterConfig(filterConfig)
+			serviceCacheOptions, err := fc.serviceCacheOptions(accountLogger)
 			if err != nil {
-				level.Error(apiLogger).Log("during", "set token gauge metric", "err", err)
+				level.Error(accountLogger).Log("err", err)
+				os.Exit(1)
 			}
-			defaultGatherers = append(defaultGatherers, tg)
+			config.Accounts = append(config.Accounts, exporter.Account{
+				Name:                ac.Name,
+				Token:               ac.Token,
+				ServiceCacheOptions: serviceCacheOptions,
+				ServiceOverrides: func(metadata rt.MetadataProvider) exporter.ServiceOverrides {
+					return &serviceConfigs{account: ac.Name, overrides: structured.services, metadata: metadata}
+				},
+			})
 		}
-	}
 
-	bg, err := prom.BuildInfoGatherer(namespace, deprecatedSubsystem)
-	if err != nil {
-		level.Error(apiLogger).Log("during", "create build info gatherer", "err", err)
-	} else {
-		defaultGatherers = append(defaultGatherers, bg)
-	}
-
-	var registry *prom.Registry
-	{
-		registry = prom.NewRegistry(programVersion, namespace, deprecatedSubsystem, metricNameFilter, defaultGatherers)
-	}
+		var err error
+		if e, err = exporter.New(config); err != nil {
+			level.Error(logger).Log("during", "create exporter", "err", err)
+			os.Exit(1)
+		}
 
-	var manager *rt.Manager
-	{
-		var (
-			rtLogger          = log.With(logger, "component", "rt.fastly.com")
-			rtClient          = &http.Client{Timeout: rtTimeout, Transport: userAgentTransport(http.DefaultTransport, userAgent)}
-			subscriberOptions = []rt.SubscriberOption{
-				rt.WithLogger(rtLogger),
-				rt.WithMetadataProvider(serviceCache),
-				rt.WithAggregateOnly(aggregateOnly),
+		if len(webConfig.Scopes) > 0 {
+			metadata := make(map[string]rt.MetadataProvider, len(accountConfigs))
+			for _, ac := range accountConfigs {
+				metadata[ac.Name], _ = e.ServiceMetadata(ac.Name)
 			}
-		)
-		manager = rt.NewManager(serviceCache, rtClient, token, registry, subscriberOptions, productCache, rtLogger)
-		manager.Refresh() // populate initial subscribers, based on the initial cache refresh
+			e.SetAuthorizer(&scopeAuthorizer{config: webConfig, metadata: metadata})
+		}
 	}
 
 	var g run.Group
-	// only setup the ticker if the certificateCache is enabled.
-	if certificateCache.Enabled() {
-
-		// Every certificateRefresh, ask the api.CertificateCache to refresh
-		// metadata from the api.fastly.com/tls/certificates endpoint.
-		var (
-			ctx, cancel = context.WithCancel(context.Background())
-			ticker      = time.NewTicker(certificateRefresh)
-		)
+	{
+		// The exporter, which fetches the initial metadata, and then runs the
+		// refresh loops and real-time subscribers until interrupted.
+		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
-			for {
-				select {
-				case <-ticker.C:
-					if err := certificateCache.Refresh(ctx); err != nil {
-						level.Warn(apiLogger).Log("during", "certificate refresh", "err", err, "msg", "the certificate info metrics may be stale")
-					}
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}
+			return e.Run(ctx)
 		}, func(error) {
-			ticker.Stop()
 			cancel()
 		})
 	}
-	// only setup the ticker if the datacenterCache is enabled.
-	if datacenterCache.Enabled() {
-
-		// Every datacenterRefresh, ask the api.DatacenterCache to refresh
-		// metadata from the api.fastly.com/datacenters endpoint.
+	{
+		// On SIGHUP, or when the config or accounts file changes if
+		// -config-file-watch is set, reload the filters, and apply them to the
+		// exporter. Service overrides are only read at startup.
 		var (
-			ctx, cancel = context.WithCancel(context.Background())
-			ticker      = time.NewTicker(datacenterRefresh)
+			accountNames    = make([]string, 0, len(accountConfigs))
+			ctx, cancel     = context.WithCancel(context.Background())
+			hup             = make(chan os.Signal, 1)
+			configChanges   = watchFile(ctx, configFile, configFileWatch)
+			accountsChanges = watchFile(ctx, accountsFile, configFileWatch)
+			reloadLogger    = log.With(logger, "component", "reload")
 		)
-		g.Add(func() error {
-			for {
-				select {
-				case <-ticker.C:
-					if err := datacenterCache.Refresh(ctx); err != nil {
-						level.Warn(apiLogger).Log("during", "datacenter refresh", "err", err, "msg", "the datacenter info metrics may be stale")
-					}
-				case <-ctx.Done():
-					return ctx.Err()
-				}
+		for _, ac := range accountConfigs {
+			accountNames = append(accountNames, ac.Name)
+		}
+		signal.Notify(hup, syscall.SIGHUP)
+		reload := func(during string) {
+			reconfigure, err := reloadFilters(fs, os.Args[1:], ffOptions, accountsFile, accountNames, reloadLogger)
+			if err != nil {
+				level.Error(reloadLogger).Log("during", during, "err", err, "msg", "keeping the previous configuration")
+				return
 			}
-		}, func(error) {
-			ticker.Stop()
-			cancel()
-		})
-	}
-	if dictionaryCache.Enabled() {
-		var (
-			ctx, cancel = context.WithCancel(context.Background())
-			ticker      = time.NewTicker(dictionaryRefresh)
-		)
+			e.Reconfigure(ctx, reconfigure.metricNameFilter, reconfigure.serviceCacheOptions)
+			level.Info(reloadLogger).Log("during", during, "msg", "filters reloaded")
+		}
 		g.Add(func() error {
 			for {
 				select {
-				case <-ticker.C:
-					if err := dictionaryCache.Refresh(ctx); err != nil {
-						level.Warn(apiLogger).Log("during", "dictionary info refresh", "err", err, "msg", "dictionary info metrics may be stale")
-					}
+				case <-hup:
+					reload("SIGHUP")
+				case <-configChanges:
+					reload("config file change")
+				case <-accountsChanges:
+					reload("accounts file change")
 				case <-ctx.Done():
 					return ctx.Err()
 				}
 			}
 		}, func(error) {
-			ticker.Stop()
+			signal.Stop(hup)
 			cancel()
 		})
 	}
 	{
-		// Every productRefresh, ask the api.ProductCache to refresh
-		// data from the product entitlement endpoint.
-		var (
-			ctx, cancel = context.WithCancel(context.Background())
-			ticker      = time.NewTicker(productRefresh)
-		)
-		g.Add(func() error {
-			for {
-				select {
-				case <-ticker.C:
-					if err := productCache.Refresh(ctx); err != nil {
-						level.Warn(apiLogger).Log("during", "product refresh", "err", err, "msg", "the product entitlement data may be stale")
-					}
-				case <-ctx.Done():
-					return ctx.Err()
-				}
+		// The HTTP server that Prometheus will scrape, which also serves the
+		// liveness and readiness endpoints.
+		serverLogger := log.With(logger, "component", "server")
+		server := http.Server{
+			Addr:    listen,
+			Handler: web.NewAuthenticator(e.Handler(), webConfig, web.WithUnauthenticatedPaths("/healthz", "/readyz")),
+		}
+		if webConfig.TLS.Enabled() {
+			tlsConfig, err := web.NewTLSConfig(webConfig.TLS, serverLogger)
+			if err != nil {
+				level.Error(serverLogger).Log("during", "load TLS config", "err", err)
+				os.Exit(1)
 			}
-		}, func(error) {
-			ticker.Stop()
-			cancel()
-		})
-	}
-	{
-		// Every serviceRefresh, ask the api.ServiceCache to refresh the set of
-		// services we should be exporting data for. Then, ask the rt.Manager to
-		// refresh its set of rt.Subscribers, based on those latest services.
-		var (
-			ctx, cancel = context.WithCancel(context.Background())
-			ticker      = time.NewTicker(serviceRefresh)
-		)
+			server.TLSConfig = tlsConfig
+		}
 		g.Add(func() error {
-			for {
-				select {
-				case <-ticker.C:
-					if err := serviceCache.Refresh(ctx); err != nil {
-						level.Warn(apiLogger).Log("during", "service refresh", "err", err, "msg", "the set of exported services and their metadata may be stale")
-					}
-					manager.Refresh() // safe to do with stale data in the cache
-				case <-ctx.Done():
-					return ctx.Err()
-				}
+			level.Info(serverLogger).Log("listen", listen, "tls", server.TLSConfig != nil)
+			if server.TLSConfig != nil {
+				return server.ListenAndServeTLS("", "") // certificates come from the TLS config
 			}
+			return server.ListenAndServe()
 		}, func(error) {
-			ticker.Stop()
-			cancel()
-		})
-	}
-	{
-		// A pseudo-actor for the rt.Manager, which waits for interrupt and then
-		// tears down all of the managed subscribers.
-		ctx, cancel := context.WithCancel(context.Background())
-		g.Add(func() error {
-			<-ctx.Done()
-			manager.StopAll()
-			return ctx.Err()
-		}, func(error) {
-			cancel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			level.Debug(serverLogger).Log("msg", "shutting down")
+			server.Shutdown(ctx)
 		})
 	}
-	{
-		// The HTTP server that Prometheus will scrape.
-		serverLogger := log.With(logger, "component", "server")
+	if adminListen != "" {
+		// The admin API, on its own address, since it isn't authenticated.
+		adminLogger := log.With(logger, "component", "admin")
 		server := http.Server{
-			Addr:    listen,
-			Handler: registry,
+			Addr:    adminListen,
+			Handler: e.AdminHandler(),
 		}
 		g.Add(func() error {
-			level.Info(serverLogger).Log("listen", listen)
+			level.Info(adminLogger).Log("listen", adminListen)
 			return server.ListenAndServe()
 		}, func(error) {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 			defer cancel()
-			level.Debug(serverLogger).Log("msg", "shutting down")
 			server.Shutdown(ctx)
 		})
 	}
 	{
-		// Catch ctrl-C.
+		// Catch ctrl-C, and termination by e.g. a container runtime, so
+		// that the exporter stops cleanly and writes its state file.
 		var (
 			ctx     = context.Background()
-			signals = os.Interrupt
+			signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
 		)
-		g.Add(run.SignalHandler(ctx, signals))
+		g.Add(run.SignalHandler(ctx, signals...))
 	}
 	level.Info(logger).Log("exit", g.Run())
 }
@@ -627,21 +617,67 @@ func usageFor(fs *flag.FlagSet) func() {
 	}
 }
 
-func envVarSuffix(f *flag.Flag) string {
-	if _, ok := f.Value.(*stringslice); ok {
-		return "" // no repeatable flags as env vars
+// envVarPrefix is the prefix of the environment variables which set flags.
+const envVarPrefix = "FASTLY_EXPORTER"
+
+// envVarFor returns the name of the environment variable which sets the flag,
+// the same way as ff.WithEnvVarPrefix.
+func envVarFor(name string) string {
+	return envVarPrefix + "_" + strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(strings.ToUpper(name))
+}
+
+// parseFlags parses the args, environment, and config file with ff.Parse, in
+// that priority order. Repeatable flags which aren't set on the command line
+// take a comma-separated list of values from their environment variable.
+func parseFlags(fs *flag.FlagSet, args []string, options ...ff.Option) error {
+	// The command line takes precedence over the environment, so find the
+	// flags set there, without touching their values.
+	cmdline := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	cmdline.SetOutput(io.Discard)
+	cmdline.Usage = func() {}
+	fs.VisitAll(func(f *flag.Flag) {
+		cmdline.Var(&placeholderValue{isBool: isBoolFlag(f)}, f.Name, f.Usage)
+	})
+	cmdline.Parse(args) // errors are reported by ff.Parse
+	provided := map[string]bool{}
+	cmdline.Visit(func(f *flag.Flag) { provided[f.Name] = true })
+
+	if err := ff.Parse(fs, args, append(options, ff.WithEnvVarPrefix(envVarPrefix))...); err != nil {
+		return err
 	}
 
+	fs.VisitAll(func(f *flag.Flag) {
+		ss, ok := f.Value.(*stringslice)
+		if !ok || provided[f.Name] {
+			return
+		}
+		value := os.Getenv(envVarFor(f.Name))
+		if value == "" {
+			return
+		}
+		*ss = nil // ff.Parse set the whole value
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				ss.Set(v)
+			}
+		}
+	})
+	return nil
+}
+
+func envVarSuffix(f *flag.Flag) string {
 	switch f.Name {
-	case "version", "config-file-example":
+	case "version", "config-file-example", "config-file-schema":
 		return ""
 
 	case "token":
 		return " (or via FASTLY_API_TOKEN)"
+	}
 
-	default:
-		return " (or via FASTLY_EXPORTER_" + strings.Replace(strings.ToUpper(f.Name), "-", "_", -1) + ")"
+	if _, ok := f.Value.(*stringslice); ok {
+		return " (or via " + envVarFor(f.Name) + ", comma-separated)"
 	}
+	return " (or via " + envVarFor(f.Name) + ")"
 }
 
 var exampleConfigFile = strings.TrimSpace(`
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/peterbourgon/ff/v3"
 )
 
 func TestUserAgentTransport(t *testing.T) {
@@ -23,3 +31,304 @@ func TestUserAgentTransport(t *testing.T) {
 		t.Fatalf("want %q, have %q", want, have)
 	}
 }
+
+func TestLoadFilterConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("token ABC123\nservice-allowlist Prod\nmetric-blocklist imgopto\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		fs    = flag.NewFlagSet("test", flag.ContinueOnError)
+		debug = fs.Bool("debug", false, "")
+		_     = fs.String("token", "", "")
+		_     = fs.String("config-file", "", "")
+		fc    filterConfig
+	)
+	fc.register(fs)
+
+	args := []string{"-debug", "-service", "AAA", "-config-file", configFile}
+	have, err := loadFilterConfig(fs, args, ff.WithConfigFileFlag("config-file"), ff.WithConfigFileParser(ff.PlainParser))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := filterConfig{
+		serviceIDs:       stringslice{"AAA"},
+		serviceAllowlist: stringslice{"Prod"},
+		metricBlocklist:  stringslice{"imgopto"},
+	}
+	if !cmp.Equal(want, have, cmp.AllowUnexported(filterConfig{})) {
+		t.Error(cmp.Diff(want, have, cmp.AllowUnexported(filterConfig{})))
+	}
+
+	if *debug || len(fc.serviceIDs) > 0 {
+		t.Errorf("original flag set was modified")
+	}
+}
+
+func TestParseFlagsEnv(t *testing.T) {
+	t.Setenv("FASTLY_EXPORTER_SERVICE_ALLOWLIST", "Prod, Live")
+	t.Setenv("FASTLY_EXPORTER_SERVICE_BLOCKLIST", "Dev,Staging")
+	t.Setenv("FASTLY_EXPORTER_METRIC_BLOCKLIST", "imgopto")
+	t.Setenv("FASTLY_EXPORTER_SERVICE_SHARD", "1/2")
+
+	var (
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		fc filterConfig
+	)
+	fc.register(fs)
+
+	if err := parseFlags(fs, []string{"-service-blocklist", "Flag,Test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := filterConfig{
+		serviceShard:     "1/2",
+		serviceAllowlist: stringslice{"Prod", "Live"},
+		serviceBlocklist: stringslice{"Flag,Test"}, // the command line takes precedence, and isn't split
+		metricBlocklist:  stringslice{"imgopto"},
+	}
+	if !cmp.Equal(want, fc, cmp.AllowUnexported(filterConfig{})) {
+		t.Error(cmp.Diff(want, fc, cmp.AllowUnexported(filterConfig{})))
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	t.Setenv("TEST_STAGING_TOKEN", "DEF456")
+
+	write := func(t *testing.T, s string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "accounts.json")
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		accounts, err := loadAccounts(write(t, `{"accounts": [
+			{"name": "prod", "token": "ABC123", "service_allowlist": ["Prod"]},
+			{"name": "staging", "token_env": "TEST_STAGING_TOKEN", "service_shard": "1/2"}
+		]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 2, len(accounts); want != have {
+			t.Fatalf("accounts: want %d, have %d", want, have)
+		}
+		if want, have := "DEF456", accounts[1].Token; want != have {
+			t.Errorf("token: want %q, have %q", want, have)
+		}
+
+		global := filterConfig{
+			serviceAllowlist: stringslice{"Global"},
+			serviceBlocklist: stringslice{"Dev"},
+			metricBlocklist:  stringslice{"imgopto"},
+		}
+		want := filterConfig{
+			serviceAllowlist: stringslice{"Prod"},
+			serviceBlocklist: stringslice{"Dev"},
+			metricBlocklist:  stringslice{"imgopto"},
+		}
+		if have := accounts[0].filterConfig(global); !cmp.Equal(want, have, cmp.AllowUnexported(filterConfig{})) {
+			t.Error(cmp.Diff(want, have, cmp.AllowUnexported(filterConfig{})))
+		}
+	})
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+	}{
+		{"no accounts", `{"accounts": []}`},
+		{"missing name", `{"accounts": [{"token": "ABC123"}]}`},
+		{"invalid name", `{"accounts": [{"name": "a b", "token": "ABC123"}]}`},
+		{"duplicate name", `{"accounts": [{"name": "a", "token": "ABC123"}, {"name": "a", "token": "DEF456"}]}`},
+		{"missing token", `{"accounts": [{"name": "a"}]}`},
+		{"empty token env", `{"accounts": [{"name": "a", "token_env": "TEST_UNSET_TOKEN"}]}`},
+		{"duplicate token", `{"accounts": [{"name": "a", "token": "ABC123"}, {"name": "b", "token": "ABC123"}]}`},
+		{"invalid JSON", `{"accounts": [`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := loadAccounts(write(t, tc.contents)); err == nil {
+				t.Errorf("want error, have none")
+			}
+		})
+	}
+}
+
+func TestStructuredConfig(t *testing.T) {
+	parse := func(t *testing.T, filename, contents string, args ...string) (filterConfig, string, structuredConfig, error) {
+		t.Helper()
+
+		configFile := filepath.Join(t.TempDir(), filename)
+		if err := os.WriteFile(configFile, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			fs         = flag.NewFlagSet("test", flag.ContinueOnError)
+			token      = fs.String("token", "", "")
+			fc         filterConfig
+			structured structuredConfig
+		)
+		fs.StringVar(&configFile, "config-file", configFile, "")
+		fc.register(fs)
+
+		err := ff.Parse(fs, args, ff.WithConfigFileFlag("config-file"), ff.WithConfigFileParser(configFileParser(&configFile, &structured)))
+		return fc, *token, structured, err
+	}
+
+	t.Run("yaml", func(t *testing.T) {
+		fc, token, structured, err := parse(t, "config.yaml", `
+token: ABC123
+service-allowlist:
+  - Prod
+  - Live
+metric-blocklist: imgopto
+services:
+  - id: AAA
+    aggregate_only: true
+  - name: "^Prod"
+    products: [default, origin_inspector]
+    labels:
+      team: edge
+`, "-service-allowlist", "Flag")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := "ABC123", token; want != have {
+			t.Errorf("token: want %q, have %q", want, have)
+		}
+		want := filterConfig{
+			serviceAllowlist: stringslice{"Flag"}, // flags take precedence
+			metricBlocklist:  stringslice{"imgopto"},
+		}
+		if !cmp.Equal(want, fc, cmp.AllowUnexported(filterConfig{})) {
+			t.Error(cmp.Diff(want, fc, cmp.AllowUnexported(filterConfig{})))
+		}
+
+		if want, have := 2, len(structured.services); want != have {
+			t.Fatalf("services: want %d, have %d", want, have)
+		}
+		configs := &serviceConfigs{
+			overrides: structured.services,
+			metadata:  metadataMap{"AAA": "Production", "BBB": "Staging"},
+		}
+		if have := configs.ServiceConfig("AAA"); have.AggregateOnly == nil || !*have.AggregateOnly {
+			t.Errorf("AAA: want aggregate only, have %v", have.AggregateOnly)
+		}
+		if want, have := []string{"default", "origin_inspector"}, configs.ServiceConfig("AAA").Products; !cmp.Equal(want, have) {
+			t.Errorf("AAA: %s", cmp.Diff(want, have))
+		}
+		if want, have := "map[team:edge]", fmt.Sprint(configs.Labels("AAA")); want != have {
+			t.Errorf("AAA labels: want %s, have %s", want, have)
+		}
+		if have := configs.ServiceConfig("BBB"); have.AggregateOnly != nil || have.Products != nil {
+			t.Errorf("BBB: want no overrides, have %+v", have)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		fc, token, structured, err := parse(t, "config.json", `{
+			"token": "ABC123",
+			"service": ["AAA", "BBB"],
+			"services": [{"id": "AAA", "account": "prod", "labels": {"team": "edge"}}]
+		}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "ABC123", token; want != have {
+			t.Errorf("token: want %q, have %q", want, have)
+		}
+		if want, have := (stringslice{"AAA", "BBB"}), fc.serviceIDs; !cmp.Equal(want, have) {
+			t.Errorf("service: %s", cmp.Diff(want, have))
+		}
+		configs := &serviceConfigs{account: "staging", overrides: structured.services, metadata: metadataMap{}}
+		if have := configs.Labels("AAA"); have != nil {
+			t.Errorf("override for another account applied: %v", have)
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		if _, token, _, err := parse(t, "config", "token ABC123\n"); err != nil || token != "ABC123" {
+			t.Errorf("want token ABC123 and no error, have %q, %v", token, err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"unknown flag", "token: ABC123\nservice-refreshh: 1m\n", ":2: config file flag \"service-refreshh\" not defined"},
+		{"not a mapping", "- token\n", ":1: expected a mapping"},
+		{"nested flag value", "token:\n  a: b\n", ":2: token: expected a value"},
+		{"services not a list", "services: AAA\n", ":1: services: expected a list"},
+		{"unknown override key", "services:\n  - id: AAA\n    aggregate: true\n", ":3: services[0].aggregate: unknown key"},
+		{"invalid bool", "services:\n  - id: AAA\n    aggregate_only: maybe\n", ":3: services[0].aggregate_only: expected true or false"},
+		{"invalid product", "services:\n  - id: AAA\n    products: [cdn]\n", `:3: services[0].products: unknown product "cdn"`},
+		{"invalid regex", "services:\n  - name: \"(\"\n", ":2: services[0].name: error parsing regexp"},
+		{"reserved label", "services:\n  - id: AAA\n    labels: {datacenter: x}\n", `:3: services[0].labels: label name "datacenter" is reserved`},
+		{"reserved product label", "services:\n  - id: AAA\n    labels: {product: x}\n", `:3: services[0].labels: label name "product" is reserved`},
+		{"id and name", "services:\n  - id: AAA\n    name: Prod\n", ":2: services[0]: exactly one of id or name is required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, _, err := parse(t, "config.yaml", tc.contents)
+			if err == nil {
+				t.Fatalf("want error, have none")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("want error containing %q, have %q", tc.want, err)
+			}
+		})
+	}
+}
+
+type metadataMap map[string]string
+
+func (m metadataMap) Metadata(id string) (name string, version int, found bool) {
+	name, found = m[id]
+	return name, 1, found
+}
+
+func TestOutboundConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		args    []string
+		wantErr string
+	}{
+		{args: nil},
+		{args: []string{"-api-url", "http://localhost:8080/api", "-rt-url", "http://localhost:8080/rt"}},
+		{args: []string{"-outbound-proxy-url", "socks5://proxy:1080"}},
+		{args: []string{"-api-url", "api.example.com"}, wantErr: "invalid -api-url: scheme must be one of http, https"},
+		{args: []string{"-rt-url", "https://"}, wantErr: "invalid -rt-url: missing host"},
+		{args: []string{"-outbound-proxy-url", "ftp://proxy"}, wantErr: "invalid -outbound-proxy-url"},
+		{args: []string{"-outbound-cert-file", "client.crt"}, wantErr: "must be set together"},
+		{args: []string{"-outbound-ca-file", "does-not-exist.pem"}, wantErr: "-outbound-ca-file"},
+	} {
+		var (
+			fs = flag.NewFlagSet("test", flag.ContinueOnError)
+			oc outboundConfig
+		)
+		oc.register(fs)
+		if err := fs.Parse(testcase.args); err != nil {
+			t.Fatal(err)
+		}
+
+		transport, err := oc.transport()
+		switch {
+		case testcase.wantErr == "" && err != nil:
+			t.Errorf("%v: unexpected error: %v", testcase.args, err)
+		case testcase.wantErr != "" && (err == nil || !strings.Contains(err.Error(), testcase.wantErr)):
+			t.Errorf("%v: want error containing %q, have %v", testcase.args, testcase.wantErr, err)
+		case err == nil && oc.proxyURL != "":
+			req := httptest.NewRequest("GET", "https://api.fastly.com/service", nil)
+			if proxyURL, err := transport.Proxy(req); err != nil || proxyURL.String() != oc.proxyURL {
+				t.Errorf("%v: proxy: want %s, have %v (%v)", testcase.args, oc.proxyURL, proxyURL, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/peterbourgon/ff/v3"
+)
+
+// reloadedFilters are the filters built by reloadFilters.
+type reloadedFilters struct {
+	metricNameFilter    filter.Filter
+	serviceCacheOptions map[string][]api.ServiceCacheOption // by account name
+}
+
+// reloadFilters loads the filter config, as well as the accounts file if it's
+// set, and builds the filters of every account. Accounts can't be added or
+// removed without a restart, so changes to the set of accounts are only
+// logged, and accounts which were removed keep their previous filters.
+func reloadFilters(fs *flag.FlagSet, args []string, options []ff.Option, accountsFile string, accounts []string, logger log.Logger) (reloadedFilters, error) {
+	fc, err := loadFilterConfig(fs, args, options...)
+	if err != nil {
+		return reloadedFilters{}, err
+	}
+
+	accountConfigs := map[string]accountConfig{"": {}}
+	if accountsFile != "" {
+		acs, err := loadAccounts(accountsFile)
+		if err != nil {
+			return reloadedFilters{}, err
+		}
+		accountConfigs = make(map[string]accountConfig, len(acs))
+		for _, ac := range acs {
+			accountConfigs[ac.Name] = ac
+		}
+	}
+
+	metricNameFilter, err := fc.metricNameFilter(logger)
+	if err != nil {
+		return reloadedFilters{}, err
+	}
+
+	serviceCacheOptions := make(map[string][]api.ServiceCacheOption, len(accounts))
+	for _, name := range accounts {
+		ac, ok := accountConfigs[name]
+		if !ok {
+			level.Warn(logger).Log("account", name, "msg", "account is no longer in the accounts file, but it's only removed on restart")
+			continue
+		}
+		delete(accountConfigs, name)
+
+		accountLogger := logger
+		if name != "" {
+			accountLogger = log.With(logger, "account", name)
+		}
+		afc := ac.filterConfig(fc)
+		options, err := afc.serviceCacheOptions(accountLogger)
+		if err != nil {
+			if name != "" {
+				err = fmt.Errorf("account %q: %w", name, err)
+			}
+			return reloadedFilters{}, err
+		}
+		serviceCacheOptions[name] = options
+	}
+
+	for name := range accountConfigs {
+		level.Warn(logger).Log("account", name, "msg", "account was added to the accounts file, but it's only added on restart")
+	}
+
+	return reloadedFilters{
+		metricNameFilter:    metricNameFilter,
+		serviceCacheOptions: serviceCacheOptions,
+	}, nil
+}
+
+// loadFilterConfig parses the args, environment, and config file in the same
+// way as at startup, and returns the resulting filter config. The flag set
+// provides the definitions of all other flags, whose values are ignored.
+func loadFilterConfig(fs *flag.FlagSet, args []string, options ...ff.Option) (filterConfig, error) {
+	var (
+		fc   filterConfig
+		next = flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	)
+	next.SetOutput(io.Discard)
+	fc.register(next)
+	fs.VisitAll(func(f *flag.Flag) {
+		if next.Lookup(f.Name) == nil {
+			next.Var(&placeholderValue{isBool: isBoolFlag(f)}, f.Name, f.Usage)
+		}
+	})
+
+	if err := parseFlags(next, args, options...); err != nil {
+		return filterConfig{}, err
+	}
+
+	return fc, nil
+}
+
+// placeholderValue accepts any value for a flag that's only defined so that
+// parsing succeeds. It keeps the value, so that e.g. -config-file still works.
+type placeholderValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *placeholderValue) Set(s string) error { v.value = s; return nil }
+func (v *placeholderValue) String() string     { return v.value }
+func (v *placeholderValue) IsBoolFlag() bool   { return v.isBool }
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// watchFile polls the file at the given path every interval, and signals on
+// the returned channel whenever its size or modification time changes. If the
+// path or interval are empty, it returns a nil channel, which never signals.
+func watchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	if path == "" || interval <= 0 {
+		return nil
+	}
+
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		prevModTime, prevSize := stat()
+		for {
+			select {
+			case <-ticker.C:
+				modTime, size := stat()
+				if modTime.Equal(prevModTime) && size == prevSize {
+					continue
+				}
+				prevModTime, prevSize = modTime, size
+				select {
+				case changes <- struct{}{}:
+				default: // a reload is already pending
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
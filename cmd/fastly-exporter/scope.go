@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/fastly/fastly-exporter/pkg/web"
+)
+
+// scopeAuthorizer implements prom.Authorizer with the scopes of the web config.
+// Service names are resolved via the service cache of each account.
+type scopeAuthorizer struct {
+	config   web.Config
+	metadata map[string]rt.MetadataProvider // by account name
+}
+
+// Authorized implements prom.Authorizer. Requests which weren't authenticated,
+// because authentication is disabled, and credentials without a scope, can see
+// every service.
+func (a *scopeAuthorizer) Authorized(req *http.Request, account, serviceID string) bool {
+	credential, ok := web.CredentialFrom(req.Context())
+	if !ok {
+		return true
+	}
+
+	scope, ok := a.config.ScopeFor(credential)
+	if !ok {
+		return true
+	}
+
+	var (
+		name  string
+		found bool
+	)
+	if metadata, ok := a.metadata[account]; ok {
+		name, _, found = metadata.Metadata(serviceID)
+	}
+	return scope.Allows(account, serviceID, name, found)
+}
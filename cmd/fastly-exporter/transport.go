@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+)
+
+// outboundConfig collects the flags which determine where and how requests to
+// the Fastly APIs are sent. The settings apply to both the api.fastly.com and
+// the rt.fastly.com HTTP clients.
+type outboundConfig struct {
+	apiURL             string
+	rtURL              string
+	proxyURL           string
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+}
+
+func (oc *outboundConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&oc.apiURL, "api-url", api.DefaultBaseURL, "base URL of the Fastly API")
+	fs.StringVar(&oc.rtURL, "rt-url", rt.DefaultBaseURL, "base URL of the Fastly real-time stats API")
+	fs.StringVar(&oc.proxyURL, "outbound-proxy-url", "", "if set, send requests to the Fastly APIs via this proxy; by default, HTTPS_PROXY and NO_PROXY are respected")
+	fs.StringVar(&oc.caFile, "outbound-ca-file", "", "if set, also trust the CA certificates in this PEM file for requests to the Fastly APIs, e.g. for a TLS-intercepting proxy")
+	fs.StringVar(&oc.certFile, "outbound-cert-file", "", "if set, present the client certificate in this PEM file for requests to the Fastly APIs (requires -outbound-key-file)")
+	fs.StringVar(&oc.keyFile, "outbound-key-file", "", "private key in PEM format for -outbound-cert-file")
+	fs.BoolVar(&oc.insecureSkipVerify, "outbound-insecure-skip-verify", false, "don't verify the certificates of the Fastly APIs or proxy (insecure, only for testing)")
+}
+
+// validate checks the base URLs, the proxy URL, and the client certificate
+// flags.
+func (oc *outboundConfig) validate() error {
+	if err := validateURL("-api-url", oc.apiURL, "http", "https"); err != nil {
+		return err
+	}
+	if err := validateURL("-rt-url", oc.rtURL, "http", "https"); err != nil {
+		return err
+	}
+	if oc.proxyURL != "" {
+		if err := validateURL("-outbound-proxy-url", oc.proxyURL, "http", "https", "socks5"); err != nil {
+			return err
+		}
+	}
+	if (oc.certFile == "") != (oc.keyFile == "") {
+		return errors.New("-outbound-cert-file and -outbound-key-file must be set together")
+	}
+	return nil
+}
+
+func validateURL(flag, value string, schemes ...string) error {
+	u, err := url.Parse(value)
+	switch {
+	case err != nil:
+		return fmt.Errorf("invalid %s: %w", flag, err)
+	case !contains(schemes, u.Scheme):
+		return fmt.Errorf("invalid %s: scheme must be one of %s", flag, strings.Join(schemes, ", "))
+	case u.Host == "":
+		return fmt.Errorf("invalid %s: missing host", flag)
+	case u.RawQuery != "" || u.Fragment != "":
+		return fmt.Errorf("invalid %s: must not have a query or fragment", flag)
+	default:
+		return nil
+	}
+}
+
+// transport returns a transport with the proxy and TLS settings, based on the
+// default transport.
+func (oc *outboundConfig) transport() (*http.Transport, error) {
+	if err := oc.validate(); err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if oc.proxyURL != "" {
+		proxyURL, _ := url.Parse(oc.proxyURL) // validated above
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if oc.caFile == "" && oc.certFile == "" && !oc.insecureSkipVerify {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: oc.insecureSkipVerify,
+	}
+
+	if oc.caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		buf, err := os.ReadFile(oc.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("-outbound-ca-file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("-outbound-ca-file: no certificates in %s", oc.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if oc.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(oc.certFile, oc.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("-outbound-cert-file: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
@@ -55,31 +55,38 @@ type CertificateCache struct {
 	token   string
 	enabled bool
 	logger  log.Logger
+	baseURL string
 
 	mtx   sync.Mutex
 	certs []Certificate
+
+	status refreshStatus
 }
 
 // NewCertificateCache returns an empty cache of certificates metadata. Use the
 // Refresh method to update the cache.
-func NewCertificateCache(client HTTPClient, token string, enabled bool, logger log.Logger) *CertificateCache {
+func NewCertificateCache(client HTTPClient, token string, enabled bool, logger log.Logger, options ...Option) *CertificateCache {
+	o := newOptions(options)
 	return &CertificateCache{
-		client:  client,
+		client:  throttled(client, o.throttle),
 		token:   token,
 		enabled: enabled,
 		logger:  logger,
+		baseURL: o.baseURL,
+		status:  refreshStatus{cache: "certificates", metrics: o.refreshMetrics},
 	}
 }
 
 // Refresh the cache with metadata retreived from the Fastly API.
-func (c *CertificateCache) Refresh(ctx context.Context) error {
+func (c *CertificateCache) Refresh(ctx context.Context) (err error) {
 	if !c.enabled {
 		return nil
 	}
 	begin := time.Now()
+	defer func() { c.status.record(begin, err) }()
 
 	var (
-		uri       = fmt.Sprintf("https://api.fastly.com/tls/certificates?page%%5Bnumber%%5D=1&page%%5Bsize%%5D=%d&sort=created_at", maxCertificatesPageSize)
+		uri       = endpoint(c.baseURL, fmt.Sprintf("/tls/certificates?page%%5Bnumber%%5D=1&page%%5Bsize%%5D=%d&sort=created_at", maxCertificatesPageSize))
 		nextCerts = []Certificate{}
 		total     = 0
 	)
@@ -118,7 +125,7 @@ func (c *CertificateCache) Refresh(ctx context.Context) error {
 			break
 		}
 
-		uri = next.String()
+		uri = rebase(c.baseURL, next)
 	}
 
 	level.Debug(c.logger).Log(
@@ -133,6 +140,11 @@ func (c *CertificateCache) Refresh(ctx context.Context) error {
 	return nil
 }
 
+// Status returns the outcome of the recent refreshes of the cache.
+func (c *CertificateCache) Status() RefreshStatus {
+	return c.status.get()
+}
+
 // Certificates returns a copy of the currently cached certificates.
 func (c *CertificateCache) Certificates() []Certificate {
 	c.mtx.Lock()
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -32,27 +33,36 @@ type DatacenterCache struct {
 	client  HTTPClient
 	token   string
 	enabled bool
+	baseURL string
 
 	mtx sync.Mutex
 	dcs []Datacenter
+
+	status refreshStatus
 }
 
 // NewDatacenterCache returns an empty cache of datacenter metadata. Use the
 // Refresh method to update the cache.
-func NewDatacenterCache(client HTTPClient, token string, enabled bool) *DatacenterCache {
+func NewDatacenterCache(client HTTPClient, token string, enabled bool, options ...Option) *DatacenterCache {
+	o := newOptions(options)
 	return &DatacenterCache{
-		client:  client,
+		client:  throttled(client, o.throttle),
 		token:   token,
 		enabled: enabled,
+		baseURL: o.baseURL,
+		status:  refreshStatus{cache: "datacenters", metrics: o.refreshMetrics},
 	}
 }
 
 // Refresh the cache with metadata retreived from the Fastly API.
-func (c *DatacenterCache) Refresh(ctx context.Context) error {
+func (c *DatacenterCache) Refresh(ctx context.Context) (err error) {
 	if !c.enabled {
 		return nil
 	}
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.fastly.com/datacenters", nil)
+	begin := time.Now()
+	defer func() { c.status.record(begin, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint(c.baseURL, "/datacenters"), nil)
 	if err != nil {
 		return fmt.Errorf("error constructing API datacenters request: %w", err)
 	}
@@ -85,6 +95,11 @@ func (c *DatacenterCache) Refresh(ctx context.Context) error {
 	return nil
 }
 
+// Status returns the outcome of the recent refreshes of the cache.
+func (c *DatacenterCache) Status() RefreshStatus {
+	return c.status.get()
+}
+
 // Datacenters returns a copy of the currently cached datacenters.
 func (c *DatacenterCache) Datacenters() []Datacenter {
 	c.mtx.Lock()
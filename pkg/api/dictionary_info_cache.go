@@ -37,11 +37,14 @@ type DictionaryInfoCache struct {
 	logger       log.Logger
 	serviceCache *ServiceCache
 	enabled      bool
+	baseURL      string
 
 	mtx sync.RWMutex
 
 	// Cached snapshot used by the collector to avoid network I/O on scrape.
 	dictionaries []Dictionary
+
+	status refreshStatus
 }
 
 // Dictionary holds information about a single dictionary,
@@ -59,16 +62,19 @@ type Dictionary struct {
 
 // NewDictionaryInfoCache returns an empty cache of dictionary metadata. Use the
 // Refresh method to update the cache.
-func NewDictionaryInfoCache(client HTTPClient, token string, logger log.Logger, serviceCache *ServiceCache, enabled bool) *DictionaryInfoCache {
+func NewDictionaryInfoCache(client HTTPClient, token string, logger log.Logger, serviceCache *ServiceCache, enabled bool, options ...Option) *DictionaryInfoCache {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	o := newOptions(options)
 	return &DictionaryInfoCache{
-		client:       client,
+		client:       throttled(client, o.throttle),
 		token:        token,
 		logger:       log.With(logger, "component", "dictionary-info"),
 		serviceCache: serviceCache,
 		enabled:      enabled,
+		baseURL:      o.baseURL,
+		status:       refreshStatus{cache: "dictionaries", metrics: o.refreshMetrics},
 	}
 }
 
@@ -76,10 +82,13 @@ func NewDictionaryInfoCache(client HTTPClient, token string, logger log.Logger,
 func (c *DictionaryInfoCache) Enabled() bool { return c.enabled }
 
 // Refresh queries Fastly APIs and rebuilds the in-memory snapshot.
-func (c *DictionaryInfoCache) Refresh(ctx context.Context) error {
+func (c *DictionaryInfoCache) Refresh(ctx context.Context) (err error) {
 	if !c.enabled {
 		return nil
 	}
+	begin := time.Now()
+	defer func() { c.status.record(begin, err) }()
+
 	out := []Dictionary{}
 	for _, s := range c.serviceCache.Services() {
 		active := s.Version
@@ -122,6 +131,11 @@ func (c *DictionaryInfoCache) Refresh(ctx context.Context) error {
 	return nil
 }
 
+// Status returns the outcome of the recent refreshes of the cache.
+func (c *DictionaryInfoCache) Status() RefreshStatus {
+	return c.status.get()
+}
+
 // Dictionaries returns a copy of the currently cached dictionaries.
 func (c *DictionaryInfoCache) Dictionaries() []Dictionary {
 	c.mtx.RLock()
@@ -201,7 +215,7 @@ func (c *DictionaryInfoCache) req(ctx context.Context, method, url string) (*htt
 }
 
 func (c *DictionaryInfoCache) listDictionaries(ctx context.Context, serviceID string, version int) ([]dictionaryResp, error) {
-	u := fmt.Sprintf("https://api.fastly.com/service/%s/version/%d/dictionary", serviceID, version)
+	u := endpoint(c.baseURL, fmt.Sprintf("/service/%s/version/%d/dictionary", serviceID, version))
 	req, err := c.req(ctx, http.MethodGet, u)
 	if err != nil {
 		return nil, err
@@ -223,7 +237,7 @@ func (c *DictionaryInfoCache) listDictionaries(ctx context.Context, serviceID st
 }
 
 func (c *DictionaryInfoCache) getDictionaryInfo(ctx context.Context, serviceID string, version int, dictID string) (dictionaryInfo, error) {
-	u := fmt.Sprintf("https://api.fastly.com/service/%s/version/%d/dictionary/%s/info", serviceID, version, dictID)
+	u := endpoint(c.baseURL, fmt.Sprintf("/service/%s/version/%d/dictionary/%s/info", serviceID, version, dictID))
 	req, err := c.req(ctx, http.MethodGet, u)
 	if err != nil {
 		return dictionaryInfo{}, err
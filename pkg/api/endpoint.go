@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultBaseURL is the base URL of the Fastly API.
+const DefaultBaseURL = "https://api.fastly.com"
+
+// Option provides some additional behavior to the certificate, datacenter,
+// dictionary info, and product caches, and the token recorder. The service
+// cache has its own options.
+type Option func(*options)
+
+type options struct {
+	baseURL        string
+	products       []string
+	refreshMetrics *RefreshMetrics
+	throttle       Throttle
+}
+
+func newOptions(opts []Option) options {
+	o := options{baseURL: DefaultBaseURL, products: Products}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBaseURL sets the base URL of the Fastly API, e.g. to send requests to a
+// local stand-in. By default, DefaultBaseURL is used.
+func WithBaseURL(baseURL string) Option {
+	return func(o *options) { o.baseURL = baseURL }
+}
+
+// WithProducts sets the products whose entitlement the product cache checks,
+// e.g. to include products registered beyond the built-in ones. Other caches
+// ignore it. By default, Products is used.
+func WithProducts(products ...string) Option {
+	return func(o *options) { o.products = products }
+}
+
+// WithRefreshMetrics sets the metrics which instrument the refreshes of the
+// cache. By default, refreshes aren't instrumented.
+func WithRefreshMetrics(m *RefreshMetrics) Option {
+	return func(o *options) { o.refreshMetrics = m }
+}
+
+// WithThrottle sets a throttle which every request waits for, e.g. to spread
+// the requests of a token whose rate limit is nearly exhausted. By default,
+// requests aren't throttled.
+func WithThrottle(t Throttle) Option {
+	return func(o *options) { o.throttle = t }
+}
+
+// endpoint returns the URL of the API path, which may include a query, relative
+// to the base URL. The path of the base URL, if any, is kept as a prefix.
+func endpoint(baseURL, path string) string {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return strings.TrimRight(baseURL, "/") + path
+}
+
+// rebase returns the next page link of a paginated response, relative to the
+// base URL. Next links are absolute URLs of api.fastly.com, so only their path
+// and query are kept.
+func rebase(baseURL string, next *url.URL) string {
+	if baseURL == "" || baseURL == DefaultBaseURL {
+		return next.String()
+	}
+	path := next.EscapedPath()
+	if next.RawQuery != "" {
+		path += "?" + next.RawQuery
+	}
+	return endpoint(baseURL, path)
+}
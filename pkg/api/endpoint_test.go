@@ -0,0 +1,63 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBaseURL(t *testing.T) {
+	t.Parallel()
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/fastly/service":
+			if r.URL.Query().Get("page") == "1" {
+				// Next links are absolute URLs of the real API.
+				w.Header().Set("Link", `<https://api.fastly.com/service?page=2>; rel="next"`)
+				fmt.Fprint(w, `[{"id":"AAA","name":"Service One","version":1}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"id":"BBB","name":"Service Two","version":1}]`)
+		case "/fastly/datacenters":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var (
+		ctx             = context.Background()
+		baseURL         = server.URL + "/fastly/"
+		serviceCache    = api.NewServiceCache(http.DefaultClient, "irrelevant", api.WithServiceCacheBaseURL(baseURL))
+		datacenterCache = api.NewDatacenterCache(http.DefaultClient, "irrelevant", true, api.WithBaseURL(baseURL))
+	)
+
+	if err := serviceCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := datacenterCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := []string{"AAA", "BBB"}, serviceCache.ServiceIDs(); !cmp.Equal(want, have) {
+		t.Errorf("service IDs: %s", cmp.Diff(want, have))
+	}
+
+	want := []string{
+		"/fastly/service?page=1&per_page=1000&filter%5Binclude_versions%5D=false",
+		"/fastly/service?page=2",
+		"/fastly/datacenters",
+	}
+	if !cmp.Equal(want, paths) {
+		t.Errorf("paths: %s", cmp.Diff(want, paths))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -38,32 +39,43 @@ type Product struct {
 // ProductCache fetches product information from the Fastly Product Entitlement API
 // and stores results in a local cache.
 type ProductCache struct {
-	client HTTPClient
-	token  string
-	logger log.Logger
+	client   HTTPClient
+	token    string
+	logger   log.Logger
+	baseURL  string
+	entitled []string
 
 	mtx      sync.Mutex
 	products map[string]bool
+
+	status refreshStatus
 }
 
 // NewProductCache returns an empty cache of Product information. Use the Refresh method
 // to populate with data.
-func NewProductCache(client HTTPClient, token string, logger log.Logger) *ProductCache {
+func NewProductCache(client HTTPClient, token string, logger log.Logger, options ...Option) *ProductCache {
+	o := newOptions(options)
 	return &ProductCache{
-		client:   client,
+		client:   throttled(client, o.throttle),
 		token:    token,
 		logger:   logger,
+		baseURL:  o.baseURL,
+		entitled: o.products,
 		products: make(map[string]bool),
+		status:   refreshStatus{cache: "products", metrics: o.refreshMetrics},
 	}
 }
 
 // Refresh requests data from the Fastly API and stores data in the cache.
-func (p *ProductCache) Refresh(ctx context.Context) error {
-	for _, product := range Products {
+func (p *ProductCache) Refresh(ctx context.Context) (err error) {
+	begin := time.Now()
+	defer func() { p.status.record(begin, err) }()
+
+	for _, product := range p.entitled {
 		if product == ProductDefault {
 			continue
 		}
-		uri := fmt.Sprintf("https://api.fastly.com/entitled-products/%s", product)
+		uri := endpoint(p.baseURL, "/entitled-products/"+product)
 
 		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 		if err != nil {
@@ -99,6 +111,11 @@ func (p *ProductCache) Refresh(ctx context.Context) error {
 	return nil
 }
 
+// Status returns the outcome of the recent refreshes of the cache.
+func (p *ProductCache) Status() RefreshStatus {
+	return p.status.get()
+}
+
 // HasAccess takes a product as a string and returns a boolean
 // based on the response from the Product API.
 func (p *ProductCache) HasAccess(product string) bool {
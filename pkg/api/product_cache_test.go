@@ -55,6 +55,29 @@ func TestProductCache(t *testing.T) {
 	}
 }
 
+func TestProductCacheWithProducts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		client = newSequentialResponseClient(productsResponseCustom)
+		cache  = api.NewProductCache(client, "irrelevant token", log.NewNopLogger(), api.WithProducts(api.ProductDefault, "custom_inspector"))
+	)
+
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for product, want := range map[string]bool{
+		api.ProductDefault:         true,
+		"custom_inspector":         false,
+		api.ProductOriginInspector: true, // not checked
+	} {
+		if have := cache.HasAccess(product); want != have {
+			t.Errorf("%s: want %v, have %v", product, want, have)
+		}
+	}
+}
+
 const productsResponseOne = `
 {
   "product": {
@@ -86,3 +109,13 @@ const productsResponseTwo = `
   }
 }
 `
+
+const productsResponseCustom = `
+{
+  "product": {
+    "id": "custom_inspector",
+    "object": "product"
+  },
+  "has_access": false
+}
+`
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/retry"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Refresher is implemented by every cache, and the token recorder. Refresh
+// fetches the data from the Fastly API, and Status reports the outcome of the
+// recent refreshes.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+	Status() RefreshStatus
+}
+
+// DefaultJitter is the fraction of the interval by which scheduled refreshes
+// are brought forward at random, by default, so that the refreshes of several
+// caches or exporters don't line up.
+const DefaultJitter = 0.1
+
+// Schedule refreshes a refresher: once initially, and then periodically at
+// the interval, minus some jitter. Failed refreshes, including the initial
+// one, are retried with the backoff of the retry policy, rather than at the
+// next interval, if that's sooner. Refreshes can also be triggered on demand.
+type Schedule struct {
+	name      string
+	refresher Refresher
+	interval  time.Duration
+	jitter    float64
+	policy    retry.Policy
+	logger    log.Logger
+	after     func()
+
+	refreshMtx sync.Mutex // serializes refreshes
+	triggered  chan struct{}
+
+	mtx  sync.Mutex
+	next time.Time
+}
+
+// ScheduleOption provides some additional behavior to a schedule.
+type ScheduleOption func(*Schedule)
+
+// WithJitter sets the fraction of the interval by which refreshes are brought
+// forward at random. By default, DefaultJitter is used.
+func WithJitter(f float64) ScheduleOption {
+	return func(s *Schedule) { s.jitter = f }
+}
+
+// WithSchedulePolicy sets the backoff after failed refreshes. By default,
+// retry.DefaultPolicy is used.
+func WithSchedulePolicy(p retry.Policy) ScheduleOption {
+	return func(s *Schedule) { s.policy = p }
+}
+
+// WithScheduleLogger sets the logger used to report failed refreshes. By
+// default, no log events are emitted.
+func WithScheduleLogger(logger log.Logger) ScheduleOption {
+	return func(s *Schedule) { s.logger = logger }
+}
+
+// WithAfterRefresh sets a function which is called after every refresh but the
+// initial one, whether it succeeded or not, e.g. to act on the refreshed data.
+// By default, nothing is called.
+func WithAfterRefresh(f func()) ScheduleOption {
+	return func(s *Schedule) { s.after = f }
+}
+
+// NewSchedule returns a schedule of the refresher at the interval. The name
+// identifies the refresher in logs, e.g. "services".
+func NewSchedule(name string, refresher Refresher, interval time.Duration, options ...ScheduleOption) *Schedule {
+	s := &Schedule{
+		name:      name,
+		refresher: refresher,
+		interval:  interval,
+		jitter:    DefaultJitter,
+		logger:    log.NewNopLogger(),
+		triggered: make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.logger = log.With(s.logger, "cache", name)
+	return s
+}
+
+// Name returns the name of the refresher.
+func (s *Schedule) Name() string {
+	return s.name
+}
+
+// Interval returns the interval between refreshes.
+func (s *Schedule) Interval() time.Duration {
+	return s.interval
+}
+
+// Status returns the outcome of the recent refreshes of the refresher.
+func (s *Schedule) Status() RefreshStatus {
+	return s.refresher.Status()
+}
+
+// Next returns when the next scheduled refresh is due. It's zero until Run has
+// started.
+func (s *Schedule) Next() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.next
+}
+
+// Initial refreshes the refresher for the first time. Failures are logged, and
+// retried once Run starts.
+func (s *Schedule) Initial(ctx context.Context) error {
+	s.refreshMtx.Lock()
+	defer s.refreshMtx.Unlock()
+
+	err := s.refresher.Refresh(ctx)
+	if err != nil {
+		level.Warn(s.logger).Log("during", "initial refresh", "err", err, "msg", "the cached data is unavailable, will retry")
+	}
+	return err
+}
+
+// Trigger refreshes the refresher right away, and returns the error of the
+// refresh. The next scheduled refresh is rescheduled from now.
+func (s *Schedule) Trigger(ctx context.Context) error {
+	err := s.refresh(ctx)
+	s.schedule(time.Now())
+	select {
+	case s.triggered <- struct{}{}:
+	default: // already pending
+	}
+	return err
+}
+
+// Run refreshes the refresher on schedule until the context is canceled. Call
+// Initial before.
+func (s *Schedule) Run(ctx context.Context) error {
+	timer := time.NewTimer(s.schedule(time.Now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			s.refresh(ctx)
+			timer.Reset(s.schedule(time.Now()))
+		case <-s.triggered:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(s.Next()))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Schedule) refresh(ctx context.Context) error {
+	s.refreshMtx.Lock()
+	defer s.refreshMtx.Unlock()
+
+	begin := time.Now()
+	err := s.refresher.Refresh(ctx)
+	if err != nil {
+		level.Warn(s.logger).Log("during", "refresh", "err", err, "msg", "the cached data may be stale, will retry")
+	} else {
+		level.Debug(s.logger).Log("during", "refresh", "took", time.Since(begin))
+	}
+	if s.after != nil {
+		s.after()
+	}
+	return err
+}
+
+// schedule returns the delay until the next refresh, and records when it's
+// due: after failures, the backoff of the policy, otherwise the jittered
+// interval.
+func (s *Schedule) schedule(now time.Time) time.Duration {
+	delay := s.interval
+	if failures := s.refresher.Status().ConsecutiveFailures; failures > 0 {
+		if backoff := s.policy.Backoff(failures); backoff < delay {
+			delay = backoff
+		}
+	} else if s.jitter > 0 {
+		delay -= time.Duration(rand.Float64() * s.jitter * float64(delay))
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.next = now.Add(delay)
+	return delay
+}
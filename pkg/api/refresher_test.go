@@ -0,0 +1,141 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/retry"
+)
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+
+	var (
+		refresher = &fakeRefresher{}
+		after     atomic.Int64
+		schedule  = api.NewSchedule("fake", refresher, 50*time.Millisecond, api.WithJitter(0), api.WithAfterRefresh(func() { after.Add(1) }))
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := schedule.Initial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := int64(0), after.Load(); want != have {
+		t.Errorf("after the initial refresh: want %d calls, have %d", want, have)
+	}
+	go schedule.Run(ctx)
+
+	waitForRefreshes(t, refresher, 3)
+	if next := schedule.Next(); next.IsZero() {
+		t.Errorf("next refresh: want a time, have zero")
+	}
+	if have := after.Load(); have < 2 {
+		t.Errorf("after scheduled refreshes: want at least 2 calls, have %d", have)
+	}
+}
+
+func TestScheduleRetry(t *testing.T) {
+	t.Parallel()
+
+	var (
+		refresher = &fakeRefresher{fail: 2}
+		policy    = retry.Policy{Base: time.Millisecond, Max: time.Millisecond}
+		schedule  = api.NewSchedule("fake", refresher, time.Hour, api.WithSchedulePolicy(policy))
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := schedule.Initial(ctx); err == nil {
+		t.Fatal("initial refresh: want error, have none")
+	}
+	go schedule.Run(ctx)
+
+	waitForRefreshes(t, refresher, 3) // failed twice, then retried long before the interval
+	if status := schedule.Status(); status.ConsecutiveFailures != 0 || status.LastFailure.IsZero() {
+		t.Errorf("want recovered status with a last failure, have %+v", status)
+	}
+}
+
+func TestScheduleTrigger(t *testing.T) {
+	t.Parallel()
+
+	var (
+		refresher = &fakeRefresher{}
+		schedule  = api.NewSchedule("fake", refresher, time.Hour)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go schedule.Run(ctx)
+
+	if err := schedule.Trigger(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, refresher.count(); want != have {
+		t.Errorf("refreshes: want %d, have %d", want, have)
+	}
+
+	refresher.setFail(1)
+	if err := schedule.Trigger(ctx); err == nil {
+		t.Errorf("failed refresh: want error, have none")
+	}
+}
+
+type fakeRefresher struct {
+	mtx    sync.Mutex
+	n      int
+	fail   int
+	status api.RefreshStatus
+}
+
+func (r *fakeRefresher) Refresh(context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.n++
+	now := time.Now()
+	r.status.LastAttempt = now
+	if r.fail > 0 {
+		r.fail--
+		r.status.LastFailure = now
+		r.status.ConsecutiveFailures++
+		return errors.New("refresh failed")
+	}
+	r.status.LastSuccess = now
+	r.status.ConsecutiveFailures = 0
+	return nil
+}
+
+func (r *fakeRefresher) Status() api.RefreshStatus {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.status
+}
+
+func (r *fakeRefresher) count() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.n
+}
+
+func (r *fakeRefresher) setFail(n int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.fail = n
+}
+
+func waitForRefreshes(t *testing.T, r *fakeRefresher, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); r.count() < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("refreshes: want at least %d, have %d", n, r.count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
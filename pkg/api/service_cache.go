@@ -30,16 +30,20 @@ type Service struct {
 // ServiceCache polls api.fastly.com/service to keep metadata about
 // one or more service IDs up-to-date.
 type ServiceCache struct {
-	client HTTPClient
-	token  string
+	client  HTTPClient
+	token   string
+	logger  log.Logger
+	baseURL string
 
+	filterMtx  sync.RWMutex
 	serviceIDs stringSet
 	nameFilter filter.Filter
 	shard      shardSlice
-	logger     log.Logger
 
 	mtx      sync.RWMutex
 	services map[string]Service
+
+	status refreshStatus
 }
 
 // NewServiceCache returns an empty cache of service metadata. By default, it
@@ -47,9 +51,11 @@ type ServiceCache struct {
 // options to restrict which services the cache should manage.
 func NewServiceCache(client HTTPClient, token string, options ...ServiceCacheOption) *ServiceCache {
 	c := &ServiceCache{
-		client: client,
-		token:  token,
-		logger: log.NewNopLogger(),
+		client:  client,
+		token:   token,
+		logger:  log.NewNopLogger(),
+		baseURL: DefaultBaseURL,
+		status:  refreshStatus{cache: "services"},
 	}
 	for _, option := range options {
 		option(c)
@@ -85,18 +91,62 @@ func WithShard(n, m uint64) ServiceCacheOption {
 	return func(c *ServiceCache) { c.shard = shardSlice{n, m} }
 }
 
+// WithServiceCacheBaseURL sets the base URL of the Fastly API, e.g. to send
+// requests to a local stand-in. By default, DefaultBaseURL is used.
+func WithServiceCacheBaseURL(baseURL string) ServiceCacheOption {
+	return func(c *ServiceCache) { c.baseURL = baseURL }
+}
+
+// WithServiceCacheRefreshMetrics sets the metrics which instrument the
+// refreshes of the cache. By default, refreshes aren't instrumented.
+func WithServiceCacheRefreshMetrics(m *RefreshMetrics) ServiceCacheOption {
+	return func(c *ServiceCache) { c.status.metrics = m }
+}
+
+// WithServiceCacheThrottle sets a throttle which every request of the cache
+// waits for. By default, requests aren't throttled.
+func WithServiceCacheThrottle(t Throttle) ServiceCacheOption {
+	return func(c *ServiceCache) { c.client = throttled(c.client, t) }
+}
+
 // WithLogger sets the logger used by the cache during refresh.
 // By default, no log events are emitted.
 func WithLogger(logger log.Logger) ServiceCacheOption {
 	return func(c *ServiceCache) { c.logger = logger }
 }
 
+// Reconfigure replaces the options that restrict which services are cached.
+// Restrictions that aren't provided are removed, and other options, such as
+// WithLogger, are ignored. The new restrictions take effect on the next
+// Refresh.
+func (c *ServiceCache) Reconfigure(options ...ServiceCacheOption) {
+	next := &ServiceCache{}
+	for _, option := range options {
+		option(next)
+	}
+
+	c.filterMtx.Lock()
+	defer c.filterMtx.Unlock()
+	c.serviceIDs = next.serviceIDs
+	c.nameFilter = next.nameFilter
+	c.shard = next.shard
+}
+
 // Refresh services and their metadata.
-func (c *ServiceCache) Refresh(ctx context.Context) error {
+func (c *ServiceCache) Refresh(ctx context.Context) (err error) {
 	begin := time.Now()
+	defer func() { c.status.record(begin, err) }()
+
+	c.filterMtx.RLock()
+	var (
+		serviceIDs = c.serviceIDs
+		nameFilter = c.nameFilter
+		shard      = c.shard
+	)
+	c.filterMtx.RUnlock()
 
 	var (
-		uri     = fmt.Sprintf("https://api.fastly.com/service?page=1&per_page=%d&filter%%5Binclude_versions%%5D=false", maxServicePageSize)
+		uri     = endpoint(c.baseURL, fmt.Sprintf("/service?page=1&per_page=%d&filter%%5Binclude_versions%%5D=false", maxServicePageSize))
 		total   = 0
 		nextgen = map[string]Service{}
 	)
@@ -132,17 +182,17 @@ func (c *ServiceCache) Refresh(ctx context.Context) error {
 				"service_version", s.Version,
 			))
 
-			if reject := !c.serviceIDs.empty() && !c.serviceIDs.has(s.ID); reject {
+			if reject := !serviceIDs.empty() && !serviceIDs.has(s.ID); reject {
 				debug.Log("result", "rejected", "reason", "service ID not explicitly allowed")
 				continue
 			}
 
-			if reject := !c.nameFilter.Permit(s.Name); reject {
+			if reject := !nameFilter.Permit(s.Name); reject {
 				debug.Log("result", "rejected", "reason", "service name rejected by name filter")
 				continue
 			}
 
-			if reject := !c.shard.match(s.ID); reject {
+			if reject := !shard.match(s.ID); reject {
 				debug.Log("result", "rejected", "reason", "service ID in different shard")
 				continue
 			}
@@ -156,7 +206,7 @@ func (c *ServiceCache) Refresh(ctx context.Context) error {
 			break
 		}
 
-		uri = next.String()
+		uri = rebase(c.baseURL, next)
 	}
 
 	level.Debug(c.logger).Log(
@@ -190,6 +240,11 @@ func (c *ServiceCache) Refresh(ctx context.Context) error {
 	return nil
 }
 
+// Status returns the outcome of the recent refreshes of the cache.
+func (c *ServiceCache) Status() RefreshStatus {
+	return c.status.get()
+}
+
 // ServiceIDs currently being monitored by the cache.
 // The set can change over time.
 func (c *ServiceCache) ServiceIDs() (ids []string) {
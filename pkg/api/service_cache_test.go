@@ -182,6 +182,37 @@ func TestServiceCachePagination(t *testing.T) {
 	}
 }
 
+func TestServiceCacheReconfigure(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		client = fixedResponseClient{code: 200, response: serviceResponseLarge}
+		s1     = "AbcDef123ghiJKlmnOPsq"
+		s2     = "XXXXXXXXXXXXXXXXXXXXXX"
+		cache  = api.NewServiceCache(client, "irrelevant_token", api.WithExplicitServiceIDs(s1))
+	)
+
+	for _, step := range []struct {
+		options []api.ServiceCacheOption
+		want    []string
+	}{
+		{nil, []string{s1}}, // constructor options
+		{[]api.ServiceCacheOption{api.WithNameFilter(filterAllowlist(`mmy`))}, []string{s2}},
+		{[]api.ServiceCacheOption{}, []string{s1, s2}},
+	} {
+		if step.options != nil {
+			cache.Reconfigure(step.options...)
+		}
+		if err := cache.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := step.want, cache.ServiceIDs(); !cmp.Equal(want, have) {
+			t.Fatal(cmp.Diff(want, have))
+		}
+	}
+}
+
 func filterAllowlist(a string) (f filter.Filter) {
 	f.Allow(a)
 	return f
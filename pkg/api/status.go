@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RefreshStatus describes the outcome of the recent refreshes of a cache.
+type RefreshStatus struct {
+	// LastAttempt is when the most recent refresh finished. It's zero if the
+	// cache has never been refreshed.
+	LastAttempt time.Time
+
+	// LastSuccess is when the most recent successful refresh finished. It's
+	// zero if the cache has never been refreshed successfully.
+	LastSuccess time.Time
+
+	// LastFailure is when the most recent failed refresh finished. It's zero
+	// if no refresh has failed.
+	LastFailure time.Time
+
+	// LastError is the error of the most recent refresh, or nil if it was
+	// successful.
+	LastError error
+
+	// ConsecutiveFailures is the number of refreshes which have failed since
+	// the most recent successful refresh.
+	ConsecutiveFailures int
+}
+
+// RefreshMetrics instruments the refreshes of caches, by cache: how long they
+// took, and how many of them failed.
+type RefreshMetrics struct {
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+// NewRefreshMetrics returns refresh metrics registered with r. If r already
+// has them, e.g. because they're shared by several accounts without an account
+// label, the registered metrics are reused.
+func NewRefreshMetrics(namespace, subsystem string, r prometheus.Registerer) (*RefreshMetrics, error) {
+	m := &RefreshMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "cache_refresh_duration_seconds", Help: "Time it took to refresh a cache of API metadata.", Buckets: prometheus.ExponentialBuckets(0.05, 2, 10)}, []string{"cache"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Subsystem: subsystem, Name: "cache_refresh_failures_total", Help: "Count of failed refreshes of a cache of API metadata."}, []string{"cache"}),
+	}
+	if err := r.Register(m.duration); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, fmt.Errorf("registering refresh duration: %w", err)
+		}
+		m.duration = are.ExistingCollector.(*prometheus.HistogramVec)
+	}
+	if err := r.Register(m.failures); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, fmt.Errorf("registering refresh failures: %w", err)
+		}
+		m.failures = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	return m, nil
+}
+
+func (m *RefreshMetrics) observe(cache string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.duration.WithLabelValues(cache).Observe(took.Seconds())
+	if err != nil {
+		m.failures.WithLabelValues(cache).Inc()
+	} else {
+		m.failures.WithLabelValues(cache).Add(0)
+	}
+}
+
+// refreshStatus tracks the RefreshStatus of a cache, and reports its refreshes
+// to the refresh metrics, if any.
+type refreshStatus struct {
+	cache   string
+	metrics *RefreshMetrics
+
+	mtx    sync.Mutex
+	status RefreshStatus
+}
+
+func (s *refreshStatus) record(begin time.Time, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	s.metrics.observe(s.cache, now.Sub(begin), err)
+	s.status.LastAttempt = now
+	s.status.LastError = err
+	if err != nil {
+		s.status.LastFailure = now
+		s.status.ConsecutiveFailures++
+		return
+	}
+	s.status.LastSuccess = now
+	s.status.ConsecutiveFailures = 0
+}
+
+func (s *refreshStatus) get() RefreshStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.status
+}
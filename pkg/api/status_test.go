@@ -0,0 +1,93 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRefreshStatus(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		client = &switchableClient{client: fixedResponseClient{code: http.StatusOK, response: datacentersResponseSmall}}
+		cache  = api.NewDatacenterCache(client, "irrelevant token", true)
+	)
+
+	if status := cache.Status(); !status.LastAttempt.IsZero() || !status.LastSuccess.IsZero() {
+		t.Fatalf("want zero status before the first refresh, have %+v", status)
+	}
+
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first := cache.Status()
+	if first.LastSuccess.IsZero() || first.LastError != nil || first.ConsecutiveFailures != 0 {
+		t.Fatalf("want success, have %+v", first)
+	}
+
+	client.client = fixedResponseClient{code: http.StatusUnauthorized}
+	cache.Refresh(ctx)
+	cache.Refresh(ctx)
+	status := cache.Status()
+	if want, have := 2, status.ConsecutiveFailures; want != have {
+		t.Errorf("consecutive failures: want %d, have %d", want, have)
+	}
+	if status.LastError == nil {
+		t.Errorf("want last error, have none")
+	}
+	if want, have := first.LastSuccess, status.LastSuccess; !want.Equal(have) {
+		t.Errorf("last success: want %s, have %s", want, have)
+	}
+
+	disabled := api.NewDatacenterCache(client, "irrelevant token", false)
+	disabled.Refresh(ctx)
+	if status := disabled.Status(); !status.LastAttempt.IsZero() {
+		t.Errorf("want no status for a disabled cache, have %+v", status)
+	}
+}
+
+type switchableClient struct {
+	client api.HTTPClient
+}
+
+func (c *switchableClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req)
+}
+
+func TestRefreshMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	metrics, err := api.NewRefreshMetrics("ns", "ss", registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := api.NewRefreshMetrics("ns", "ss", registry) // e.g. by the datacenter cache
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ctx         = context.Background()
+		client      = &switchableClient{client: fixedResponseClient{code: http.StatusOK, response: datacentersResponseSmall}}
+		datacenters = api.NewDatacenterCache(client, "irrelevant token", true, api.WithRefreshMetrics(shared))
+		products    = api.NewProductCache(fixedResponseClient{code: http.StatusUnauthorized}, "irrelevant token", log.NewNopLogger(), api.WithRefreshMetrics(metrics))
+	)
+
+	datacenters.Refresh(ctx)
+	client.client = fixedResponseClient{code: http.StatusUnauthorized}
+	datacenters.Refresh(ctx)
+	products.Refresh(ctx)
+
+	fastlytest.AssertMetric(t, registry, `ns_ss_cache_refresh_duration_seconds_count{cache="datacenters"}`, 2)
+	fastlytest.AssertMetric(t, registry, `ns_ss_cache_refresh_failures_total{cache="datacenters"}`, 1)
+	fastlytest.AssertMetric(t, registry, `ns_ss_cache_refresh_duration_seconds_count{cache="products"}`, 1)
+	fastlytest.AssertMetric(t, registry, `ns_ss_cache_refresh_failures_total{cache="products"}`, 1)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// Throttle is a consumer contract for the caches. It models a tracker of the
+// rate limits of the Fastly API, which delays requests while the remaining
+// budget of the token is low.
+type Throttle interface {
+	Wait(ctx context.Context, token string) error
+}
+
+// throttledClient waits for the throttle before each request.
+type throttledClient struct {
+	client   HTTPClient
+	throttle Throttle
+}
+
+func (c throttledClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.throttle.Wait(req.Context(), req.Header.Get("Fastly-Key")); err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// throttled wraps the client with the throttle, if any.
+func throttled(client HTTPClient, throttle Throttle) HTTPClient {
+	if throttle == nil {
+		return client
+	}
+	return throttledClient{client: client, throttle: throttle}
+}
@@ -0,0 +1,57 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestThrottle(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx         = context.Background()
+		throttle    = &recordingThrottle{}
+		services    = api.NewServiceCache(fixedResponseClient{code: http.StatusOK, response: serviceResponseLarge}, "service token", api.WithServiceCacheThrottle(throttle))
+		datacenters = api.NewDatacenterCache(fixedResponseClient{code: http.StatusOK, response: datacentersResponseSmall}, "datacenter token", true, api.WithThrottle(throttle))
+	)
+
+	if err := services.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := datacenters.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []string{"service token", "datacenter token"}, throttle.tokens(); !cmp.Equal(want, have) {
+		t.Errorf("throttled tokens: %s", cmp.Diff(want, have))
+	}
+
+	throttle.err = context.Canceled
+	if err := datacenters.Refresh(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("refresh while throttled: want %v, have %v", context.Canceled, err)
+	}
+}
+
+type recordingThrottle struct {
+	mtx    sync.Mutex
+	waited []string
+	err    error
+}
+
+func (t *recordingThrottle) Wait(_ context.Context, token string) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.waited = append(t.waited, token)
+	return t.err
+}
+
+func (t *recordingThrottle) tokens() []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return append([]string{}, t.waited...)
+}
@@ -10,19 +10,25 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// TokenRecorder requests api.fastly.com/tokens/self once and sets a gauge metric
+// TokenRecorder requests api.fastly.com/tokens/self and sets a gauge metric
 type TokenRecorder struct {
-	client HTTPClient
-	token  string
-	metric *prometheus.GaugeVec
+	client  HTTPClient
+	token   string
+	baseURL string
+	metric  *prometheus.GaugeVec
+
+	status refreshStatus
 }
 
 // NewTokenRecorder returns an empty token recorder. Use the
-// Set method to get token data and set the gauge metric.
-func NewTokenRecorder(client HTTPClient, token string) *TokenRecorder {
+// Refresh method to get token data and set the gauge metric.
+func NewTokenRecorder(client HTTPClient, token string, options ...Option) *TokenRecorder {
+	o := newOptions(options)
 	return &TokenRecorder{
-		client: client,
-		token:  token,
+		client:  throttled(client, o.throttle),
+		token:   token,
+		baseURL: o.baseURL,
+		status:  refreshStatus{cache: "token", metrics: o.refreshMetrics},
 	}
 }
 
@@ -39,19 +45,35 @@ func (t *TokenRecorder) Gatherer(namespace, subsystem string) (prometheus.Gather
 	return registry, nil
 }
 
-// Set retreives token metadata from the Fastly API and sets the gauge metric
-func (t *TokenRecorder) Set(ctx context.Context) error {
+// Refresh retrieves token metadata from the Fastly API and sets the gauge
+// metric.
+func (t *TokenRecorder) Refresh(ctx context.Context) (err error) {
+	begin := time.Now()
+	defer func() { t.status.record(begin, err) }()
+
 	token, err := t.getToken(ctx)
 	if err != nil {
 		return err
 	}
 
-	if !token.Expiration.IsZero() {
+	if !token.Expiration.IsZero() && t.metric != nil {
 		t.metric.WithLabelValues(token.ID, token.UserID).Set(float64(token.Expiration.Unix()))
 	}
 	return nil
 }
 
+// Set is Refresh.
+//
+// Deprecated: use Refresh.
+func (t *TokenRecorder) Set(ctx context.Context) error {
+	return t.Refresh(ctx)
+}
+
+// Status returns the outcome of the recent refreshes of the recorder.
+func (t *TokenRecorder) Status() RefreshStatus {
+	return t.status.get()
+}
+
 type token struct {
 	ID         string    `json:"id"`
 	UserID     string    `json:"user_id"`
@@ -59,7 +81,7 @@ type token struct {
 }
 
 func (t *TokenRecorder) getToken(ctx context.Context) (*token, error) {
-	uri := "https://api.fastly.com/tokens/self"
+	uri := endpoint(t.baseURL, "/tokens/self")
 
 	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
 	if err != nil {
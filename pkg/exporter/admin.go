@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/gorilla/mux"
+)
+
+// newAdminHandler returns the handler of the admin API.
+func (e *Exporter) newAdminHandler() http.Handler {
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/caches").HandlerFunc(e.handleCaches)
+	router.Methods("POST").Path("/caches/{cache}/refresh").HandlerFunc(e.handleRefresh)
+	router.Methods("GET").Path("/subscribers").HandlerFunc(e.handleSubscribers)
+	router.Methods("POST").Path("/subscribers/refresh").HandlerFunc(e.handleSubscribersRefresh)
+	router.Methods("PUT").Path("/services/{service}").HandlerFunc(e.handleServiceAdd)
+	router.Methods("DELETE").Path("/services/{service}").HandlerFunc(e.handleServiceRemove)
+	router.Methods("POST").Path("/services/{service}/pause").HandlerFunc(e.handleServicePause)
+	router.Methods("POST").Path("/services/{service}/resume").HandlerFunc(e.handleServiceResume)
+	return router
+}
+
+// AdminHandler serves the admin API, which controls the exporter at runtime.
+//
+//	GET    /caches                    lists the caches, their schedules, and status
+//	POST   /caches/{cache}/refresh    refreshes a cache right away
+//	GET    /subscribers               lists the real-time subscribers
+//	POST   /subscribers/refresh       starts and stops subscribers right away
+//	PUT    /services/{service}        polls a service, even if it's filtered
+//	DELETE /services/{service}        undoes PUT
+//	POST   /services/{service}/pause  stops polling a service; select a single
+//	                                  product via ?product=<name>
+//	POST   /services/{service}/resume undoes pause, likewise
+//
+// Except for the listings, select the account via ?account=<name>. Changes to
+// services are kept in memory, and apply right away. Responses are JSON. The
+// admin API isn't authenticated, so serve it on a private address.
+func (e *Exporter) AdminHandler() http.Handler {
+	return e.admin
+}
+
+type cacheResponse struct {
+	Name                string     `json:"name"`
+	Account             string     `json:"account,omitempty"`
+	Interval            string     `json:"interval"`
+	NextRefresh         *time.Time `json:"next_refresh,omitempty"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+func cacheResponseOf(s schedule) cacheResponse {
+	status := s.Status()
+	r := cacheResponse{
+		Name:                s.Name(),
+		Account:             s.account,
+		Interval:            s.Interval().String(),
+		NextRefresh:         timeOrNil(s.Next()),
+		LastAttempt:         timeOrNil(status.LastAttempt),
+		LastSuccess:         timeOrNil(status.LastSuccess),
+		LastFailure:         timeOrNil(status.LastFailure),
+		ConsecutiveFailures: status.ConsecutiveFailures,
+	}
+	if status.LastError != nil {
+		r.LastError = status.LastError.Error()
+	}
+	return r
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (e *Exporter) handleCaches(w http.ResponseWriter, r *http.Request) {
+	caches := make([]cacheResponse, 0, len(e.schedules))
+	for _, s := range e.schedules {
+		caches = append(caches, cacheResponseOf(s))
+	}
+	writeJSON(w, http.StatusOK, caches)
+}
+
+func (e *Exporter) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	s, ok := e.scheduleOf(mux.Vars(r)["cache"], r.URL.Query().Get("account"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"unknown cache"})
+		return
+	}
+	if err := s.Trigger(r.Context()); err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, cacheResponseOf(s))
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf)
+}
+
+type subscriberResponse struct {
+	Account     string     `json:"account,omitempty"`
+	ServiceID   string     `json:"service_id"`
+	Product     string     `json:"product"`
+	State       string     `json:"state"`
+	Added       bool       `json:"added,omitempty"`
+	LastAdvance *time.Time `json:"last_advance,omitempty"`
+}
+
+func subscriberResponsesOf(a *account, serviceID string) []subscriberResponse {
+	subscribers := []subscriberResponse{}
+	for _, s := range a.manager.Subscribers() {
+		if serviceID != "" && s.ServiceID != serviceID {
+			continue
+		}
+		subscribers = append(subscribers, subscriberResponseOf(a.name, s))
+	}
+	return subscribers
+}
+
+func subscriberResponseOf(account string, s rt.SubscriberInfo) subscriberResponse {
+	return subscriberResponse{
+		Account:     account,
+		ServiceID:   s.ServiceID,
+		Product:     s.Product,
+		State:       s.State,
+		Added:       s.Added,
+		LastAdvance: timeOrNil(s.LastAdvance),
+	}
+}
+
+func (e *Exporter) handleSubscribers(w http.ResponseWriter, r *http.Request) {
+	subscribers := []subscriberResponse{}
+	for _, a := range e.accounts {
+		subscribers = append(subscribers, subscriberResponsesOf(a, "")...)
+	}
+	writeJSON(w, http.StatusOK, subscribers)
+}
+
+func (e *Exporter) handleSubscribersRefresh(w http.ResponseWriter, r *http.Request) {
+	a, ok := e.accountOf(r.URL.Query().Get("account"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"unknown account"})
+		return
+	}
+	a.manager.Refresh()
+	writeJSON(w, http.StatusOK, subscriberResponsesOf(a, ""))
+}
+
+func (e *Exporter) handleServiceAdd(w http.ResponseWriter, r *http.Request) {
+	e.updateService(w, r, func(a *account, serviceID string) bool {
+		a.manager.AddService(serviceID) // adding twice is fine
+		return true
+	})
+}
+
+func (e *Exporter) handleServiceRemove(w http.ResponseWriter, r *http.Request) {
+	e.updateService(w, r, func(a *account, serviceID string) bool {
+		return a.manager.RemoveService(serviceID)
+	})
+}
+
+func (e *Exporter) handleServicePause(w http.ResponseWriter, r *http.Request) {
+	e.updateService(w, r, func(a *account, serviceID string) bool {
+		a.manager.Pause(serviceID, r.URL.Query().Get("product"))
+		return true
+	})
+}
+
+func (e *Exporter) handleServiceResume(w http.ResponseWriter, r *http.Request) {
+	e.updateService(w, r, func(a *account, serviceID string) bool {
+		return a.manager.Resume(serviceID, r.URL.Query().Get("product"))
+	})
+}
+
+// updateService applies the update to the service of the account selected by
+// the request, and refreshes the subscribers of the account, so that the
+// update applies right away. It responds with the subscribers of the service,
+// or 404 Not Found if the update returns false, i.e. there was nothing to undo.
+func (e *Exporter) updateService(w http.ResponseWriter, r *http.Request, update func(a *account, serviceID string) bool) {
+	a, ok := e.accountOf(r.URL.Query().Get("account"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{"unknown account"})
+		return
+	}
+	if name := r.URL.Query().Get("product"); name != "" && !isProduct(name) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"unknown product"})
+		return
+	}
+
+	serviceID := mux.Vars(r)["service"]
+	if !update(a, serviceID) {
+		writeJSON(w, http.StatusNotFound, errorResponse{"no such change to undo"})
+		return
+	}
+	a.manager.Refresh()
+	writeJSON(w, http.StatusOK, subscriberResponsesOf(a, serviceID))
+}
+
+func isProduct(name string) bool {
+	for _, candidate := range product.Names() {
+		if candidate == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gathererCollector adapts a gatherer to a collector, so that the exporter's
+// metrics can be registered with another registry. It's an unchecked
+// collector, because the set of metrics changes with the exported services.
+type gathererCollector struct {
+	gatherer prometheus.Gatherer
+}
+
+// Describe implements prometheus.Collector. It describes nothing, which makes
+// the collector unchecked.
+func (c gathererCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c gathererCollector) Collect(ch chan<- prometheus.Metric) {
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("fastly_exporter_gather_error", "Error gathering exporter metrics.", nil, nil), err)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labelNames := make([]string, 0, len(m.GetLabel()))
+			for _, pair := range m.GetLabel() {
+				labelNames = append(labelNames, pair.GetName())
+			}
+			ch <- gatheredMetric{
+				desc:   prometheus.NewDesc(family.GetName(), family.GetHelp(), labelNames, nil),
+				metric: m,
+			}
+		}
+	}
+}
+
+// gatheredMetric is a metric which was already gathered.
+type gatheredMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m gatheredMetric) Desc() *prometheus.Desc { return m.desc }
+
+func (m gatheredMetric) Write(out *dto.Metric) error {
+	out.Label = m.metric.Label
+	out.Gauge = m.metric.Gauge
+	out.Counter = m.metric.Counter
+	out.Summary = m.metric.Summary
+	out.Untyped = m.metric.Untyped
+	out.Histogram = m.metric.Histogram
+	out.TimestampMs = m.metric.TimestampMs
+	return nil
+}
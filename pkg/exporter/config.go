@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/health"
+	"github.com/fastly/fastly-exporter/pkg/retry"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config of an exporter. Only Accounts is required; other fields which aren't
+// set take the same defaults as the flags of the fastly-exporter command.
+type Config struct {
+	// Accounts whose services are exported. At most one account may have an
+	// empty name, and its metrics don't have an account label.
+	Accounts []Account
+
+	// Namespace and Subsystem of the metrics. Default "fastly" and "rt".
+	Namespace string
+	Subsystem string
+
+	// MetricNameFilter restricts the exported metrics.
+	MetricNameFilter filter.Filter
+
+	// How often to refresh each kind of metadata from the Fastly API.
+	// Refreshes are brought forward by up to 10% at random, and failed ones
+	// are retried with the backoff of the RetryPolicy. Certificates aren't
+	// refreshed, or exported, if DisableCertificates is set.
+	ServiceRefresh      time.Duration // default 1m
+	ProductRefresh      time.Duration // default 10m
+	DatacenterRefresh   time.Duration // default 10m
+	CertificateRefresh  time.Duration // default 6h
+	DictionaryRefresh   time.Duration // default 5m
+	TokenRefresh        time.Duration // default 1h
+	DisableCertificates bool
+
+	// ServiceExpiry is how long to keep exporting metrics for a service after
+	// it's no longer found. SeriesTTL, if set, is how long series are kept
+	// after their last update.
+	ServiceExpiry time.Duration
+	SeriesTTL     time.Duration
+
+	// AggregateOnly exports aggregated real-time stats, rather than stats per
+	// datacenter.
+	AggregateOnly bool
+
+	// StateFile, if set, persists the counters and histograms of the real-time
+	// stats, and the progress of the subscribers. The state is restored from
+	// the file when the exporter runs, and written to it every StateInterval,
+	// default 1m, and when the exporter stops.
+	StateFile     string
+	StateInterval time.Duration
+
+	// DisableBackfill stops subscribers from requesting the last 120 seconds
+	// of real-time stats when they start, and after failed requests.
+	DisableBackfill bool
+
+	// APIClient and RTClient send requests to the Fastly API and real-time
+	// API. By default, they're HTTP clients with timeouts of 15s and 45s.
+	APIClient api.HTTPClient
+	RTClient  rt.HTTPClient
+
+	// APIThrottle, if set, is waited for by every request to the Fastly API,
+	// e.g. an outbound.Transport, which spreads the requests of tokens whose
+	// rate limit is nearly exhausted.
+	APIThrottle api.Throttle
+
+	// RetryPolicy is the backoff after failed requests to either API, and the
+	// circuit breaker of each real-time endpoint. Requests to the Fastly API
+	// are retried. Subscribers which terminate prematurely are restarted with
+	// the same backoff. Zero fields take the value of retry.DefaultPolicy.
+	RetryPolicy retry.Policy
+
+	// APIBaseURL and RTBaseURL are the base URLs of the Fastly API and
+	// real-time API. By default, the public endpoints are used.
+	APIBaseURL string
+	RTBaseURL  string
+
+	// RTMaxInFlight and RTMaxRate, if set, cap the requests to the real-time
+	// API of all accounts' subscribers: the long-polls in flight, and the
+	// requests started per second. Requests over budget are queued fairly by
+	// service.
+	RTMaxInFlight int
+	RTMaxRate     float64
+
+	// RTStallWindow, if set, enables the watchdog of the subscribers: those
+	// which haven't advanced within the window are restarted. It should be
+	// comfortably longer than the timeout of the RTClient.
+	RTStallWindow time.Duration
+
+	// Readiness is the criteria of the readiness endpoint.
+	Readiness health.Criteria
+
+	// Gatherers, if set, are served along with the exported metrics, e.g. the
+	// metrics of an outbound.Transport.
+	Gatherers []prometheus.Gatherer
+
+	// Registerer, if set, gets a collector of every exported metric, in
+	// addition to the exporter's handler.
+	Registerer prometheus.Registerer
+
+	// Logger for the exporter. By default, nothing is logged.
+	Logger log.Logger
+
+	// Version of the program embedding the exporter, which is shown on the
+	// index page.
+	Version string
+}
+
+// Account is a Fastly account, i.e. an API token.
+type Account struct {
+	Name  string
+	Token string
+
+	// ServiceCacheOptions, e.g. filters, apply to the service cache of the
+	// account. The base URL and logger are set by the exporter.
+	ServiceCacheOptions []api.ServiceCacheOption
+
+	// ServiceOverrides, if set, returns the per-service configuration and
+	// labels of the account. The metadata provides the names of services.
+	ServiceOverrides func(metadata rt.MetadataProvider) ServiceOverrides
+}
+
+// ServiceOverrides customizes the subscribers and metrics of individual
+// services.
+type ServiceOverrides interface {
+	rt.ServiceConfigProvider
+	Labels(serviceID string) prometheus.Labels
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if len(c.Accounts) == 0 {
+		return Config{}, errors.New("at least one account is required")
+	}
+	names := map[string]bool{}
+	for _, a := range c.Accounts {
+		switch {
+		case a.Token == "":
+			return Config{}, fmt.Errorf("account %q: token is required", a.Name)
+		case names[a.Name]:
+			return Config{}, fmt.Errorf("account %q: duplicate name", a.Name)
+		}
+		names[a.Name] = true
+	}
+
+	if c.RTMaxInFlight < 0 || c.RTMaxRate < 0 {
+		return Config{}, errors.New("real-time request limits can't be negative")
+	}
+	if c.RTStallWindow < 0 {
+		return Config{}, errors.New("real-time stall window can't be negative")
+	}
+
+	if c.Namespace == "" {
+		c.Namespace = "fastly"
+	}
+	if c.Subsystem == "" {
+		c.Subsystem = "rt"
+	}
+	if c.ServiceRefresh <= 0 {
+		c.ServiceRefresh = time.Minute
+	}
+	if c.ProductRefresh <= 0 {
+		c.ProductRefresh = 10 * time.Minute
+	}
+	if c.DatacenterRefresh <= 0 {
+		c.DatacenterRefresh = 10 * time.Minute
+	}
+	if c.CertificateRefresh <= 0 {
+		c.CertificateRefresh = 6 * time.Hour
+	}
+	if c.DictionaryRefresh <= 0 {
+		c.DictionaryRefresh = 5 * time.Minute
+	}
+	if c.TokenRefresh <= 0 {
+		c.TokenRefresh = time.Hour
+	}
+	if c.StateInterval <= 0 {
+		c.StateInterval = time.Minute
+	}
+	if c.APIClient == nil {
+		c.APIClient = &http.Client{Timeout: 15 * time.Second}
+	}
+	if c.RTClient == nil {
+		c.RTClient = &http.Client{Timeout: 45 * time.Second}
+	}
+	if c.APIBaseURL == "" {
+		c.APIBaseURL = api.DefaultBaseURL
+	}
+	if c.RTBaseURL == "" {
+		c.RTBaseURL = rt.DefaultBaseURL
+	}
+	if c.Logger == nil {
+		c.Logger = log.NewNopLogger()
+	}
+	return c, nil
+}
@@ -0,0 +1,4 @@
+// Package exporter assembles the caches, real-time subscribers, and registry
+// of the other packages into a complete exporter, which can be embedded in
+// other programs. The fastly-exporter command is a thin shell over it.
+package exporter
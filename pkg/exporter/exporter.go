@@ -0,0 +1,541 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/health"
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/retry"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/fastly/fastly-exporter/pkg/state"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+// Exporter exports the real-time stats and metadata of the services of one or
+// more Fastly accounts as Prometheus metrics.
+type Exporter struct {
+	config          Config
+	logger          log.Logger
+	apiLogger       log.Logger
+	accounts        []*account
+	datacenterCache *api.DatacenterCache
+	schedules       []schedule
+	registry        *prom.Registry
+	checker         *health.Checker
+	handler         http.Handler
+	admin           http.Handler
+}
+
+// account collects the components which are specific to a single Fastly
+// account, i.e. a single token.
+type account struct {
+	name             string
+	token            string
+	logger           log.Logger
+	apiLogger        log.Logger
+	serviceCache     *api.ServiceCache
+	certificateCache *api.CertificateCache
+	productCache     *api.ProductCache
+	dictionaryCache  *api.DictionaryInfoCache
+	tokenRecorder    *api.TokenRecorder
+	metrics          *prom.Account
+	manager          *rt.Manager
+
+	stateMtx sync.Mutex
+	pending  *state.State // loaded from the state file, but not yet restored
+}
+
+// schedule is the refresh schedule of a cache of an account, or of the
+// datacenter cache, whose account is empty.
+type schedule struct {
+	account string
+	*api.Schedule
+}
+
+// keyvals returns the log context which identifies the account, if any.
+func (a *account) keyvals() []interface{} {
+	if a.name == "" {
+		return nil
+	}
+	return []interface{}{"account", a.name}
+}
+
+// New returns an exporter for the config. It doesn't make any requests; they
+// start when Run is called.
+func New(config Config) (*Exporter, error) {
+	config, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	config.APIClient = retry.NewClient(config.APIClient, config.RetryPolicy)
+
+	e := &Exporter{
+		config:    config,
+		logger:    config.Logger,
+		apiLogger: log.With(config.Logger, "component", "api.fastly.com"),
+	}
+
+	var (
+		apiOptions = []api.Option{api.WithBaseURL(config.APIBaseURL), api.WithThrottle(config.APIThrottle)}
+		blocked    = func(name string) bool {
+			return config.MetricNameFilter.Blocked(prometheus.BuildFQName(config.Namespace, config.Subsystem, name))
+		}
+		selfMetrics = prometheus.NewRegistry() // instruments the exporter itself
+	)
+
+	for _, ac := range config.Accounts {
+		a := &account{name: ac.Name, token: ac.Token}
+		a.logger = log.With(e.logger, a.keyvals()...)
+		a.apiLogger = log.With(e.apiLogger, a.keyvals()...)
+
+		refreshMetrics, err := api.NewRefreshMetrics(config.Namespace, config.Subsystem, e.registererFor(selfMetrics, a.name))
+		if err != nil {
+			return nil, fmt.Errorf("create refresh metrics: %w", err)
+		}
+		accountAPIOptions := append([]api.Option{api.WithRefreshMetrics(refreshMetrics)}, apiOptions...)
+
+		serviceCacheOptions := append([]api.ServiceCacheOption{api.WithLogger(a.apiLogger), api.WithServiceCacheRefreshMetrics(refreshMetrics), api.WithServiceCacheThrottle(config.APIThrottle)}, ac.ServiceCacheOptions...)
+		a.serviceCache = api.NewServiceCache(config.APIClient, a.token, append(serviceCacheOptions, api.WithServiceCacheBaseURL(config.APIBaseURL))...)
+		a.certificateCache = api.NewCertificateCache(config.APIClient, a.token, !config.DisableCertificates && !blocked("cert_expiry_timestamp_seconds"), a.logger, accountAPIOptions...)
+		a.productCache = api.NewProductCache(config.APIClient, a.token, a.apiLogger, append(accountAPIOptions, api.WithProducts(product.Names()...))...)
+		a.dictionaryCache = api.NewDictionaryInfoCache(config.APIClient, a.token, a.apiLogger, a.serviceCache, !blocked("dictionary_item_count"), accountAPIOptions...)
+		if !blocked("token_expiration") {
+			a.tokenRecorder = api.NewTokenRecorder(config.APIClient, a.token, accountAPIOptions...)
+		}
+		e.accounts = append(e.accounts, a)
+	}
+
+	// Datacenters are the same for every account, so the first token is
+	// enough to fetch them. Without account labels, the datacenter cache
+	// shares the refresh metrics of the accounts.
+	refreshMetrics, err := api.NewRefreshMetrics(config.Namespace, config.Subsystem, e.registererFor(selfMetrics, ""))
+	if err != nil {
+		return nil, fmt.Errorf("create refresh metrics: %w", err)
+	}
+	e.datacenterCache = api.NewDatacenterCache(config.APIClient, e.accounts[0].token, !blocked("datacenter_info"), append(apiOptions, api.WithRefreshMetrics(refreshMetrics))...)
+
+	defaultGatherers := append(prometheus.Gatherers{}, config.Gatherers...)
+	for _, a := range e.accounts {
+		if a.certificateCache.Enabled() {
+			g, err := a.certificateCache.Gatherer(config.Namespace, config.Subsystem)
+			if err != nil {
+				return nil, fmt.Errorf("create certificate gatherer: %w", err)
+			}
+			defaultGatherers = append(defaultGatherers, g)
+		}
+		if a.dictionaryCache.Enabled() {
+			g, err := a.dictionaryCache.Gatherer(config.Namespace, config.Subsystem)
+			if err != nil {
+				return nil, fmt.Errorf("create dictionary info gatherer: %w", err)
+			}
+			defaultGatherers = append(defaultGatherers, g)
+		}
+		if a.tokenRecorder != nil {
+			g, err := a.tokenRecorder.Gatherer(config.Namespace, config.Subsystem)
+			if err != nil {
+				return nil, fmt.Errorf("create token gatherer: %w", err)
+			}
+			defaultGatherers = append(defaultGatherers, g)
+		}
+	}
+	if e.datacenterCache.Enabled() {
+		g, err := e.datacenterCache.Gatherer(config.Namespace, config.Subsystem)
+		if err != nil {
+			return nil, fmt.Errorf("create datacenter gatherer: %w", err)
+		}
+		defaultGatherers = append(defaultGatherers, g)
+	}
+	var limiter *rt.Limiter
+	if config.RTMaxInFlight > 0 || config.RTMaxRate > 0 {
+		limiter = rt.NewLimiter(config.RTMaxInFlight, config.RTMaxRate)
+		g, err := limiter.Gatherer(config.Namespace, config.Subsystem)
+		if err != nil {
+			return nil, fmt.Errorf("create limiter gatherer: %w", err)
+		}
+		defaultGatherers = append(defaultGatherers, g)
+	}
+	managerMetrics := prometheus.NewRegistry() // registered once the managers exist
+	defaultGatherers = append(defaultGatherers, managerMetrics, selfMetrics)
+	if g, err := prom.BuildInfoGatherer(config.Namespace, config.Subsystem); err != nil {
+		level.Error(e.apiLogger).Log("during", "create build info gatherer", "err", err)
+	} else {
+		defaultGatherers = append(defaultGatherers, g)
+	}
+
+	e.registry = prom.NewRegistry(config.Version, config.Namespace, config.Subsystem, config.MetricNameFilter, defaultGatherers)
+	if config.Registerer != nil {
+		if err := config.Registerer.Register(gathererCollector{e.registry}); err != nil {
+			return nil, fmt.Errorf("register exporter collector: %w", err)
+		}
+	}
+
+	scheduleOptions := []api.ScheduleOption{api.WithSchedulePolicy(config.RetryPolicy)}
+	for _, a := range e.accounts {
+		options := append([]api.ScheduleOption{api.WithScheduleLogger(a.apiLogger)}, scheduleOptions...)
+		e.schedule(a.name, api.NewSchedule("services", a.serviceCache, config.ServiceRefresh, append(options, api.WithAfterRefresh(func() {
+			e.restoreState(a)   // before the subscribers of the services start
+			a.manager.Refresh() // safe to do with stale data in the cache
+		}))...))
+		e.schedule(a.name, api.NewSchedule("products", a.productCache, config.ProductRefresh, options...))
+		if a.certificateCache.Enabled() {
+			e.schedule(a.name, api.NewSchedule("certificates", a.certificateCache, config.CertificateRefresh, options...))
+		}
+		if a.dictionaryCache.Enabled() {
+			e.schedule(a.name, api.NewSchedule("dictionaries", a.dictionaryCache, config.DictionaryRefresh, options...))
+		}
+		if a.tokenRecorder != nil {
+			e.schedule(a.name, api.NewSchedule("token", a.tokenRecorder, config.TokenRefresh, options...))
+		}
+	}
+	if e.datacenterCache.Enabled() {
+		e.schedule("", api.NewSchedule("datacenters", e.datacenterCache, config.DatacenterRefresh, append([]api.ScheduleOption{api.WithScheduleLogger(e.apiLogger)}, scheduleOptions...)...))
+	}
+
+	e.checker = health.NewChecker(config.Readiness)
+	for _, s := range e.schedules {
+		e.checker.AddCache(s.Name(), s.account, s)
+	}
+
+	for i, a := range e.accounts {
+		var (
+			rtLogger          = log.With(e.logger, append([]interface{}{"component", "rt.fastly.com"}, a.keyvals()...)...)
+			subscriberOptions = []rt.SubscriberOption{
+				rt.WithLogger(rtLogger),
+				rt.WithMetadataProvider(a.serviceCache),
+				rt.WithAggregateOnly(config.AggregateOnly),
+				rt.WithBaseURL(config.RTBaseURL),
+				rt.WithLimiter(limiter),
+				rt.WithRetryPolicy(config.RetryPolicy),
+				rt.WithBackfill(!config.DisableBackfill),
+			}
+			accountOptions []prom.AccountOption
+			managerOptions = []rt.ManagerOption{rt.WithMetricsExpiry(config.ServiceExpiry), rt.WithRestartPolicy(config.RetryPolicy), rt.WithStallWindow(config.RTStallWindow)}
+		)
+		if f := config.Accounts[i].ServiceOverrides; f != nil {
+			overrides := f(a.serviceCache)
+			accountOptions = append(accountOptions, prom.WithServiceLabels(overrides.Labels))
+			managerOptions = append(managerOptions, rt.WithServiceConfig(overrides))
+		}
+		subscriberMetrics, err := rt.NewSubscriberMetrics(config.Namespace, config.Subsystem, e.registererFor(selfMetrics, a.name))
+		if err != nil {
+			return nil, fmt.Errorf("create subscriber metrics: %w", err)
+		}
+		subscriberOptions = append(subscriberOptions, rt.WithSubscriberMetrics(subscriberMetrics))
+		a.metrics = e.registry.Account(a.name, accountOptions...)
+		a.manager = rt.NewManager(a.serviceCache, config.RTClient, a.token, a.metrics, subscriberOptions, a.productCache, rtLogger, managerOptions...)
+		if err := e.registererFor(managerMetrics, a.name).Register(a.manager.Collector(config.Namespace, config.Subsystem)); err != nil {
+			return nil, fmt.Errorf("register subscriber collector: %w", err)
+		}
+		e.checker.AddManager(a.name, a.manager)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", e.checker)
+	mux.Handle("/readyz", e.checker)
+	mux.Handle("/", e.registry)
+	e.handler = mux
+	e.admin = e.newAdminHandler()
+
+	return e, nil
+}
+
+// schedule adds the refresh schedule of a cache of the account.
+func (e *Exporter) schedule(account string, s *api.Schedule) {
+	e.schedules = append(e.schedules, schedule{account, s})
+}
+
+// accountLabels returns true if metrics carry an account label, i.e. if any
+// account has a name. Unnamed accounts then get an empty label, so that the
+// metrics of all accounts have the same label names.
+func (e *Exporter) accountLabels() bool {
+	for _, ac := range e.config.Accounts {
+		if ac.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// registererFor returns a registerer which adds the account label of the
+// account to the metrics it registers with r, if metrics carry one.
+func (e *Exporter) registererFor(r prometheus.Registerer, account string) prometheus.Registerer {
+	if !e.accountLabels() {
+		return r
+	}
+	return prometheus.WrapRegistererWith(prometheus.Labels{"account": account}, r)
+}
+
+// Handler serves the metrics at /metrics, service discovery at /sd, and the
+// liveness and readiness endpoints at /healthz and /readyz.
+func (e *Exporter) Handler() http.Handler {
+	return e.handler
+}
+
+// SetAuthorizer restricts the services visible to each request of the handler.
+func (e *Exporter) SetAuthorizer(authorizer prom.Authorizer) {
+	e.registry.SetAuthorizer(authorizer)
+}
+
+// ServiceMetadata returns the service cache of the named account, which
+// provides the names and versions of its services, or false if there's no
+// such account.
+func (e *Exporter) ServiceMetadata(account string) (rt.MetadataProvider, bool) {
+	a, ok := e.accountOf(account)
+	if !ok {
+		return nil, false
+	}
+	return a.serviceCache, true
+}
+
+// accountOf returns the named account, if it exists.
+func (e *Exporter) accountOf(name string) (*account, bool) {
+	for _, a := range e.accounts {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Reconfigure replaces the metric name filter, and the service cache options
+// of the accounts in the map, by name. The affected service caches are
+// refreshed right away, so that only the affected subscribers are started or
+// stopped.
+func (e *Exporter) Reconfigure(ctx context.Context, metricNameFilter filter.Filter, serviceCacheOptions map[string][]api.ServiceCacheOption) {
+	e.registry.SetMetricNameFilter(metricNameFilter)
+	for _, a := range e.accounts {
+		options, ok := serviceCacheOptions[a.name]
+		if !ok {
+			continue
+		}
+		a.serviceCache.Reconfigure(options...)
+		e.Refresh(ctx, "services", a.name) // failures are logged by the schedule
+	}
+}
+
+// Refresh refreshes the named cache of the account right away, e.g. "services",
+// and returns the error of the refresh. The datacenter cache has no account.
+// Refreshes of the service cache also start and stop subscribers.
+func (e *Exporter) Refresh(ctx context.Context, cache, account string) error {
+	s, ok := e.scheduleOf(cache, account)
+	if !ok {
+		return fmt.Errorf("unknown cache %q of account %q", cache, account)
+	}
+	return s.Trigger(ctx)
+}
+
+// scheduleOf returns the schedule of the named cache of the account, if it
+// exists, and is enabled.
+func (e *Exporter) scheduleOf(cache, account string) (schedule, bool) {
+	for _, s := range e.schedules {
+		if s.Name() == cache && s.account == account {
+			return s, true
+		}
+	}
+	return schedule{}, false
+}
+
+// Run fetches the initial metadata, starts the real-time subscribers, and then
+// refreshes the metadata periodically, until the context is canceled. Then,
+// it stops the subscribers, and returns the context's error.
+func (e *Exporter) Run(ctx context.Context) error {
+	e.initialRefresh(ctx)
+	if e.config.StateFile != "" {
+		e.loadState()
+	}
+
+	for _, a := range e.accounts {
+		a.manager.Refresh() // populate initial subscribers, based on the initial cache refresh
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range e.schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(ctx)
+		}()
+	}
+	if e.config.SeriesTTL > 0 {
+		e.every(ctx, &wg, time.Minute, func(context.Context) {
+			if n := e.registry.ExpireSeries(e.config.SeriesTTL); n > 0 {
+				level.Debug(e.logger).Log("during", "series expiry", "expired", n)
+			}
+		})
+	}
+
+	if e.config.StateFile != "" {
+		e.every(ctx, &wg, e.config.StateInterval, func(context.Context) {
+			e.saveState()
+		})
+	}
+	if e.config.RTStallWindow > 0 {
+		e.every(ctx, &wg, e.config.RTStallWindow/4, func(context.Context) {
+			for _, a := range e.accounts {
+				a.manager.RestartStalled()
+			}
+		})
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+	for _, a := range e.accounts {
+		a.manager.StopAll()
+	}
+	if e.config.StateFile != "" {
+		e.saveState() // the subscribers are stopped, so the state is consistent
+	}
+	return ctx.Err()
+}
+
+// loadState loads the state file, and restores the state of every account
+// whose services are known. Failures are logged, and the exporter starts from
+// scratch.
+func (e *Exporter) loadState() {
+	s, err := state.Load(e.config.StateFile)
+	if err != nil {
+		level.Warn(e.logger).Log("during", "state restore", "err", err, "msg", "counters start from zero")
+		return
+	}
+
+	for _, a := range e.accounts {
+		a.stateMtx.Lock()
+		a.pending = &s
+		a.stateMtx.Unlock()
+		if !e.restoreState(a) {
+			level.Info(a.logger).Log("during", "state restore", "msg", "deferred until the services are refreshed successfully")
+		}
+	}
+}
+
+// restoreState restores the metrics and the progress of the subscribers of
+// the services of the account which are currently exported from the loaded
+// state, once the service cache was refreshed successfully. Until then, it's
+// unknown which services are exported, and saveState writes the loaded state
+// of the account back unchanged. It returns false if the state is still
+// pending.
+func (e *Exporter) restoreState(a *account) bool {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+
+	s := a.pending
+	if s == nil {
+		return true
+	}
+	if a.serviceCache.Status().LastSuccess.IsZero() {
+		return false
+	}
+	a.pending = nil
+
+	keep := func(serviceID string) bool {
+		_, _, found := a.serviceCache.Metadata(serviceID)
+		return found
+	}
+	n := a.metrics.Restore(s.Series, keep)
+
+	var progress []state.Progress
+	for _, p := range s.Progress {
+		if p.Account == a.name && keep(p.ServiceID) {
+			progress = append(progress, p)
+		}
+	}
+	a.manager.RestoreProgress(progress)
+	level.Info(a.logger).Log("during", "state restore", "series", n, "subscribers", len(progress), "written", s.Written)
+	return true
+}
+
+// saveState writes the metrics and the progress of the subscribers to the
+// state file, along with the pending state of accounts whose state wasn't
+// restored yet. Failures are logged, and retried at the next interval.
+func (e *Exporter) saveState() {
+	s := state.State{Written: time.Now(), Series: e.registry.Snapshot()}
+	for _, a := range e.accounts { // after the metrics, so that no second is counted twice after a restore
+		for _, p := range a.manager.Progress() {
+			p.Account = a.name
+			s.Progress = append(s.Progress, p)
+		}
+	}
+	for _, a := range e.accounts {
+		s.Series, s.Progress = a.appendPending(s.Series, s.Progress)
+	}
+	if err := state.Save(e.config.StateFile, s); err != nil {
+		level.Warn(e.logger).Log("during", "state save", "err", err, "msg", "the state will be older after a restart")
+	}
+}
+
+// appendPending appends the series and the progress of the account's pending
+// state, if any, except for services which already have current series or
+// progress, e.g. services added via the admin API.
+func (a *account) appendPending(series []state.Series, progress []state.Progress) ([]state.Series, []state.Progress) {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+
+	if a.pending == nil {
+		return series, progress
+	}
+
+	current := map[string]bool{}
+	for _, s := range series {
+		if s.Account == a.name {
+			current[s.Labels["service_id"]] = true
+		}
+	}
+	for _, p := range progress {
+		if p.Account == a.name {
+			current[p.ServiceID] = true
+		}
+	}
+
+	for _, s := range a.pending.Series {
+		if s.Account == a.name && !current[s.Labels["service_id"]] {
+			series = append(series, s)
+		}
+	}
+	for _, p := range a.pending.Progress {
+		if p.Account == a.name && !current[p.ServiceID] {
+			progress = append(progress, p)
+		}
+	}
+	return series, progress
+}
+
+// initialRefresh fetches the metadata of every cache concurrently. Failures
+// are logged by the schedules, and retried once they run.
+func (e *Exporter) initialRefresh(ctx context.Context) {
+	var g errgroup.Group
+	for _, s := range e.schedules {
+		g.Go(func() error {
+			s.Initial(ctx)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// every calls f at the interval until the context is canceled.
+func (e *Exporter) every(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, f func(context.Context)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
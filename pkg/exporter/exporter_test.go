@@ -0,0 +1,363 @@
+package exporter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/exporter"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/fastly/fastly-exporter/pkg/state"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestExporter(t *testing.T) {
+	t.Parallel()
+
+	var (
+		serviceID = "AbcDef123ghiJKlmnOPsq"
+		script    fastlytest.RealtimeScript
+		server    = fastlytest.NewServer(t,
+			fastlytest.Services(api.Service{ID: serviceID, Name: "my-service", Version: 3}),
+			fastlytest.Products(map[string]bool{api.ProductDefault: true}),
+			fastlytest.Datacenters(api.Datacenter{Code: "AMS", Name: "Amsterdam", Group: "Europe"}),
+			fastlytest.Certificates(),
+			fastlytest.Dictionaries(serviceID, 3),
+			fastlytest.Token("token-id", "user-id", time.Time{}),
+			fastlytest.Realtime(serviceID, &script),
+		)
+		registry = prometheus.NewRegistry()
+	)
+
+	script.Add(1000, realtime.Datacenter{Requests: 3}, map[string]realtime.Datacenter{"AMS": {Requests: 3}})
+
+	e, err := exporter.New(exporter.Config{
+		Accounts:   []exporter.Account{{Token: "token"}},
+		APIClient:  server.Client(),
+		RTClient:   server.Client(),
+		APIBaseURL: server.URL,
+		RTBaseURL:  server.URL,
+		Registerer: registry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- e.Run(ctx) }()
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	series := `fastly_rt_requests_total{datacenter="AMS",service_id="AbcDef123ghiJKlmnOPsq",service_name="my-service"} 3`
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, body := get("/metrics"); strings.Contains(body, series) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", series)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code, body := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz: %d %s", code, body)
+	}
+	if _, body := get("/sd"); !strings.Contains(body, serviceID) {
+		t.Errorf("/sd: %s", body)
+	}
+
+	fastlytest.AssertMetric(t, registry, `fastly_rt_requests_total{datacenter="AMS",service_id="AbcDef123ghiJKlmnOPsq",service_name="my-service"}`, 3)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_datacenter_info{datacenter="AMS",group="Europe",latitude="0",longitude="0",name="Amsterdam"}`, 1)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_subscribers{product="default",state="running"}`, 1)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_subscriber_process_duration_seconds_count{product="default"}`, 1)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_cache_refresh_failures_total{cache="services"}`, 0)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_cache_refresh_failures_total{cache="datacenters"}`, 0)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_cache_refresh_failures_total{cache="token"}`, 0)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_cache_refresh_duration_seconds_count{cache="token"}`, 1)
+
+	admin := func(method, path string) (int, string) {
+		rec := httptest.NewRecorder()
+		e.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	if code, body := admin("GET", "/caches"); code != http.StatusOK || !strings.Contains(body, `"name": "services"`) || !strings.Contains(body, `"name": "token"`) {
+		t.Errorf("GET /caches: %d %s", code, body)
+	}
+	if code, body := admin("POST", "/caches/services/refresh"); code != http.StatusOK || !strings.Contains(body, `"last_success"`) {
+		t.Errorf("POST /caches/services/refresh: %d %s", code, body)
+	}
+	if code, body := admin("POST", "/caches/services/refresh?account=other"); code != http.StatusNotFound {
+		t.Errorf("POST /caches/services/refresh?account=other: %d %s", code, body)
+	}
+	if code, body := admin("POST", "/caches/unknown/refresh"); code != http.StatusNotFound {
+		t.Errorf("POST /caches/unknown/refresh: %d %s", code, body)
+	}
+	if code, body := admin("GET", "/subscribers"); code != http.StatusOK || !strings.Contains(body, `"state": "running"`) {
+		t.Errorf("GET /subscribers: %d %s", code, body)
+	}
+	if code, body := admin("POST", "/services/"+serviceID+"/pause?product=nope"); code != http.StatusBadRequest {
+		t.Errorf("POST /services/%s/pause?product=nope: %d %s", serviceID, code, body)
+	}
+	if code, body := admin("POST", "/services/"+serviceID+"/pause"); code != http.StatusOK || !strings.Contains(body, `"state": "paused"`) {
+		t.Errorf("POST /services/%s/pause: %d %s", serviceID, code, body)
+	}
+	fastlytest.AssertMetric(t, registry, `fastly_rt_subscribers{product="default",state="paused"}`, 1)
+	if _, body := get("/sd"); !strings.Contains(body, serviceID) {
+		t.Errorf("/sd of a paused service: %s", body)
+	}
+	if code, body := admin("POST", "/services/"+serviceID+"/resume"); code != http.StatusOK || strings.Contains(body, `"state": "paused"`) {
+		t.Errorf("POST /services/%s/resume: %d %s", serviceID, code, body)
+	}
+	if code, body := admin("POST", "/services/"+serviceID+"/resume"); code != http.StatusNotFound {
+		t.Errorf("POST /services/%s/resume twice: %d %s", serviceID, code, body)
+	}
+	adHocID := "XyzXyz123ghiJKlmnOPsq"
+	if code, body := admin("PUT", "/services/"+adHocID); code != http.StatusOK || !strings.Contains(body, `"added": true`) {
+		t.Errorf("PUT /services/%s: %d %s", adHocID, code, body)
+	}
+	if _, body := get("/sd"); !strings.Contains(body, adHocID) {
+		t.Errorf("/sd after adding a service: %s", body)
+	}
+	if code, body := admin("DELETE", "/services/"+adHocID); code != http.StatusOK || body != "[]" {
+		t.Errorf("DELETE /services/%s: %d %s", adHocID, code, body)
+	}
+	if _, body := get("/sd"); strings.Contains(body, adHocID) {
+		t.Errorf("/sd after removing a service: %s", body)
+	}
+	if code, body := admin("POST", "/subscribers/refresh"); code != http.StatusOK {
+		t.Errorf("POST /subscribers/refresh: %d %s", code, body)
+	}
+	if code, body := admin("POST", "/subscribers/refresh?account=other"); code != http.StatusNotFound {
+		t.Errorf("POST /subscribers/refresh?account=other: %d %s", code, body)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: want %v, have %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for Run to return")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		name   string
+		config exporter.Config
+		want   string
+	}{
+		{
+			name:   "no accounts",
+			config: exporter.Config{},
+			want:   "at least one account is required",
+		},
+		{
+			name:   "no token",
+			config: exporter.Config{Accounts: []exporter.Account{{Name: "a"}}},
+			want:   `account "a": token is required`,
+		},
+		{
+			name:   "duplicate name",
+			config: exporter.Config{Accounts: []exporter.Account{{Name: "a", Token: "1"}, {Name: "a", Token: "2"}}},
+			want:   `account "a": duplicate name`,
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			_, err := exporter.New(testcase.config)
+			if err == nil || err.Error() != testcase.want {
+				t.Errorf("want %q, have %v", testcase.want, err)
+			}
+		})
+	}
+}
+
+func TestExporterState(t *testing.T) {
+	t.Parallel()
+
+	var (
+		serviceID = "AbcDef123ghiJKlmnOPsq"
+		stateFile = filepath.Join(t.TempDir(), "state.json")
+		series    = `fastly_rt_requests_total{datacenter="AMS",service_id="AbcDef123ghiJKlmnOPsq",service_name="my-service"}`
+	)
+
+	// run runs an exporter against the script until the series has the value,
+	// and then stops it.
+	run := func(script *fastlytest.RealtimeScript, want float64) {
+		t.Helper()
+
+		server := fastlytest.NewServer(t,
+			fastlytest.Services(api.Service{ID: serviceID, Name: "my-service", Version: 3}),
+			fastlytest.Products(map[string]bool{api.ProductDefault: true}),
+			fastlytest.Datacenters(),
+			fastlytest.Realtime(serviceID, script),
+		)
+		e, err := exporter.New(exporter.Config{
+			Accounts:            []exporter.Account{{Token: "token"}},
+			APIClient:           server.Client(),
+			RTClient:            server.Client(),
+			APIBaseURL:          server.URL,
+			RTBaseURL:           server.URL,
+			DisableCertificates: true,
+			StateFile:           stateFile,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		errc := make(chan error, 1)
+		go func() { errc <- e.Run(ctx) }()
+
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			rec := httptest.NewRecorder()
+			e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			if strings.Contains(rec.Body.String(), fmt.Sprintf("%s %v\n", series, want)) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s %v", series, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		cancel()
+		if err := <-errc; !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run: want %v, have %v", context.Canceled, err)
+		}
+	}
+
+	var first fastlytest.RealtimeScript
+	first.Add(1000, realtime.Datacenter{Requests: 3}, map[string]realtime.Datacenter{"AMS": {Requests: 3}})
+	run(&first, 3)
+
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("state file: %v", err)
+	}
+
+	// The restarted exporter continues from the state. Its backfill serves
+	// second 1000 again, which was already counted.
+	var second fastlytest.RealtimeScript
+	second.Add(1000, realtime.Datacenter{Requests: 3}, map[string]realtime.Datacenter{"AMS": {Requests: 3}})
+	second.Add(1001, realtime.Datacenter{Requests: 2}, map[string]realtime.Datacenter{"AMS": {Requests: 2}})
+	run(&second, 5)
+}
+
+func TestExporterStateDeferred(t *testing.T) {
+	t.Parallel()
+
+	var (
+		serviceID = "AbcDef123ghiJKlmnOPsq"
+		stateFile = filepath.Join(t.TempDir(), "state.json")
+		labels    = map[string]string{"service_id": serviceID, "service_name": "my-service", "datacenter": "AMS"}
+		begin     = time.Now()
+	)
+	if err := state.Save(stateFile, state.State{
+		Series:   []state.Series{{Product: api.ProductDefault, Name: "fastly_rt_requests_total", Labels: labels, Value: 7}},
+		Progress: []state.Progress{{ServiceID: serviceID, Product: api.ProductDefault, Recorded: 1000}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		failing  atomic.Bool
+		services = fastlytest.Services(api.Service{ID: serviceID, Name: "my-service", Version: 3})
+		script   fastlytest.RealtimeScript
+		server   = fastlytest.NewServer(t,
+			fastlytest.Endpoint{Method: services.Method, Path: services.Path, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing.Load() {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				services.Handler.ServeHTTP(w, r)
+			})},
+			fastlytest.Products(map[string]bool{api.ProductDefault: true}),
+			fastlytest.Datacenters(),
+			fastlytest.Realtime(serviceID, &script),
+		)
+	)
+	failing.Store(true)
+	script.Add(1000, realtime.Datacenter{Requests: 7}, map[string]realtime.Datacenter{"AMS": {Requests: 7}})
+	script.Add(1001, realtime.Datacenter{Requests: 2}, map[string]realtime.Datacenter{"AMS": {Requests: 2}})
+
+	e, err := exporter.New(exporter.Config{
+		Accounts:            []exporter.Account{{Token: "token"}},
+		APIClient:           server.Client(),
+		RTClient:            server.Client(),
+		APIBaseURL:          server.URL,
+		RTBaseURL:           server.URL,
+		DisableCertificates: true,
+		StateFile:           stateFile,
+		StateInterval:       10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- e.Run(ctx) }()
+
+	// While the services are unknown, the state is written back unchanged.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s, err := state.Load(stateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Written.After(begin) {
+			if len(s.Series) != 1 || s.Series[0].Value != 7 || len(s.Progress) != 1 || s.Progress[0].Recorded != 1000 {
+				t.Fatalf("state after a failed refresh: %+v", s)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the state to be saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once the services are known, the state is restored, and second 1000
+	// isn't counted again.
+	failing.Store(false)
+	if err := e.Refresh(ctx, "services", ""); err != nil {
+		t.Fatal(err)
+	}
+	series := `fastly_rt_requests_total{datacenter="AMS",service_id="AbcDef123ghiJKlmnOPsq",service_name="my-service"} 9`
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		rec := httptest.NewRecorder()
+		e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if strings.Contains(rec.Body.String(), series) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", series)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: want %v, have %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,174 @@
+package fakefastly
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/gorilla/mux"
+)
+
+// Services is the /service endpoint, which lists the services. It honors the
+// page and per_page parameters, and links to the next page like the Fastly
+// API.
+func Services(services ...api.Service) Endpoint {
+	return Endpoint{
+		Method: "GET",
+		Path:   "/service",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page, perPage := pageParams(r.URL.Query(), "page", "per_page", 100)
+			begin, end, more := pageBounds(page, perPage, len(services))
+			if more {
+				w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPage(r, "page", page)))
+			}
+			writeJSON(w, http.StatusOK, append([]api.Service{}, services[begin:end]...))
+		}),
+	}
+}
+
+// Datacenters is the /datacenters endpoint.
+func Datacenters(datacenters ...api.Datacenter) Endpoint {
+	return Endpoint{
+		Method: "GET",
+		Path:   "/datacenters",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, append([]api.Datacenter{}, datacenters...))
+		}),
+	}
+}
+
+// Products is the /entitled-products endpoint. The account has access to the
+// products which map to true, and no others.
+func Products(products map[string]bool) Endpoint {
+	return Endpoint{
+		Method: "GET",
+		Path:   "/entitled-products/{product}",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var p api.Product
+			p.Product.Name = mux.Vars(r)["product"]
+			p.HasAccess = products[p.Product.Name]
+			writeJSON(w, http.StatusOK, p)
+		}),
+	}
+}
+
+// Certificates is the /tls/certificates endpoint. It honors the page[number]
+// and page[size] parameters, and links to the next page like the Fastly API.
+func Certificates(certificates ...api.Certificate) Endpoint {
+	return Endpoint{
+		Method: "GET",
+		Path:   "/tls/certificates",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page, perPage := pageParams(r.URL.Query(), "page[number]", "page[size]", 20)
+			begin, end, more := pageBounds(page, perPage, len(certificates))
+			response := api.CertificateResponse{
+				Certificates: append([]api.Certificate{}, certificates[begin:end]...),
+				Links:        map[string]string{},
+			}
+			if more {
+				response.Links["next"] = nextPage(r, "page[number]", page)
+			}
+			writeJSON(w, http.StatusOK, response)
+		}),
+	}
+}
+
+// Token is the /tokens/self endpoint. A zero expiration means the token
+// doesn't expire.
+func Token(id, userID string, expiration time.Time) Endpoint {
+	return Endpoint{
+		Method: "GET",
+		Path:   "/tokens/self",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response := map[string]interface{}{"id": id, "user_id": userID}
+			if !expiration.IsZero() {
+				response["expires_at"] = expiration.UTC().Format(time.RFC3339)
+			}
+			writeJSON(w, http.StatusOK, response)
+		}),
+	}
+}
+
+// Dictionary is an edge dictionary of a service version.
+type Dictionary struct {
+	ID          string
+	Name        string
+	Digest      string
+	ItemCount   int64
+	LastUpdated time.Time
+}
+
+// Dictionaries serves the dictionary list and info endpoints of a service
+// version.
+func Dictionaries(serviceID string, version int, dictionaries ...Dictionary) Endpoint {
+	type listed struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		UpdatedAt string `json:"updated_at"`
+	}
+	type info struct {
+		Digest      string `json:"digest"`
+		ItemCount   int64  `json:"item_count"`
+		LastUpdated string `json:"last_updated"`
+	}
+
+	return Endpoint{
+		Method: "GET",
+		Path:   fmt.Sprintf("/service/%s/version/%d/dictionary{info:(?:/[^/]+/info)?}", serviceID, version),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if mux.Vars(r)["info"] == "" {
+				response := make([]listed, 0, len(dictionaries))
+				for _, d := range dictionaries {
+					response = append(response, listed{d.ID, d.Name, d.LastUpdated.UTC().Format(time.RFC3339)})
+				}
+				writeJSON(w, http.StatusOK, response)
+				return
+			}
+			for _, d := range dictionaries {
+				if mux.Vars(r)["info"] == "/"+d.ID+"/info" {
+					writeJSON(w, http.StatusOK, info{d.Digest, d.ItemCount, d.LastUpdated.UTC().Format(time.RFC3339)})
+					return
+				}
+			}
+			writeJSON(w, http.StatusNotFound, map[string]string{"msg": "Record not found"})
+		}),
+	}
+}
+
+//
+//
+//
+
+func pageParams(query url.Values, pageKey, sizeKey string, defaultSize int) (page, size int) {
+	page, _ = strconv.Atoi(query.Get(pageKey))
+	size, _ = strconv.Atoi(query.Get(sizeKey))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = defaultSize
+	}
+	return page, size
+}
+
+func pageBounds(page, size, total int) (begin, end int, more bool) {
+	begin, end = (page-1)*size, page*size
+	if begin > total {
+		begin = total
+	}
+	if end > total {
+		end = total
+	}
+	return begin, end, end < total
+}
+
+func nextPage(r *http.Request, pageKey string, page int) string {
+	next := *r.URL
+	query := next.Query()
+	query.Set(pageKey, strconv.Itoa(page+1))
+	next.RawQuery = query.Encode()
+	return next.RequestURI()
+}
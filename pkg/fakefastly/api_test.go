@@ -0,0 +1,180 @@
+package fakefastly_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/fakefastly"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPICaches(t *testing.T) {
+	t.Parallel()
+
+	var (
+		services = []api.Service{
+			{ID: "AbcDef123ghiJKlmnOPsq", Name: "my-service", Version: 3},
+			{ID: "XXXXXXXXXXXXXXXXXXXXXX", Name: "other-service", Version: 1},
+		}
+		datacenters = []api.Datacenter{
+			{Code: "AMS", Name: "Amsterdam", Group: "Europe", Coördinates: api.Coördinates{Latitude: 52.308613, Longitude: 4.763889}},
+		}
+		certificate = api.Certificate{
+			ID:         "cert1",
+			Attributes: api.Attributes{CN: "example.com", Name: "example", Issuer: "Example CA", NotAfter: "2030-01-01T00:00:00.000Z", SN: "123"},
+		}
+		updated = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		server  = fastlytest.NewServer(t,
+			fakefastly.Services(services...),
+			fakefastly.Datacenters(datacenters...),
+			fakefastly.Products(map[string]bool{api.ProductDefault: true, api.ProductOriginInspector: true}),
+			fakefastly.Certificates(certificate),
+			fakefastly.Token("token-id", "user-id", updated),
+			fakefastly.Dictionaries("AbcDef123ghiJKlmnOPsq", 3, fakefastly.Dictionary{ID: "dict1", Name: "redirects", Digest: "abc", ItemCount: 42, LastUpdated: updated}),
+			fakefastly.Dictionaries("XXXXXXXXXXXXXXXXXXXXXX", 1),
+		)
+		ctx    = context.Background()
+		client = server.Client()
+		logger = log.NewNopLogger()
+		option = api.WithBaseURL(server.URL)
+	)
+
+	serviceCache := api.NewServiceCache(client, "token", api.WithServiceCacheBaseURL(server.URL))
+	if err := serviceCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := services, serviceCache.Services(); !cmp.Equal(want, have) {
+		t.Errorf("services: %s", cmp.Diff(want, have))
+	}
+
+	datacenterCache := api.NewDatacenterCache(client, "token", true, option)
+	if err := datacenterCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := datacenters, datacenterCache.Datacenters(); !cmp.Equal(want, have) {
+		t.Errorf("datacenters: %s", cmp.Diff(want, have))
+	}
+
+	productCache := api.NewProductCache(client, "token", logger, option)
+	if err := productCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for product, want := range map[string]bool{api.ProductDefault: true, api.ProductOriginInspector: true, api.ProductDomainInspector: false} {
+		if have := productCache.HasAccess(product); want != have {
+			t.Errorf("products: %s: want %v, have %v", product, want, have)
+		}
+	}
+
+	certificateCache := api.NewCertificateCache(client, "token", true, logger, option)
+	if err := certificateCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []api.Certificate{certificate}, certificateCache.Certificates(); !cmp.Equal(want, have) {
+		t.Errorf("certificates: %s", cmp.Diff(want, have))
+	}
+
+	dictionaryCache := api.NewDictionaryInfoCache(client, "token", logger, serviceCache, true, option)
+	if err := dictionaryCache.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []api.Dictionary{{
+		ServiceID: "AbcDef123ghiJKlmnOPsq", ServiceName: "my-service", Version: 3,
+		DictionaryID: "dict1", DictionaryName: "redirects", Digest: "abc", ItemCount: 42, LastUpdatedTS: float64(updated.Unix()),
+	}}
+	if have := dictionaryCache.Dictionaries(); !cmp.Equal(want, have) {
+		t.Errorf("dictionaries: %s", cmp.Diff(want, have))
+	}
+
+	tokenRecorder := api.NewTokenRecorder(client, "token", option)
+	gatherer, err := tokenRecorder.Gatherer("fastly", "rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokenRecorder.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fastlytest.AssertMetric(t, gatherer, `fastly_rt_token_expiration{token_id="token-id",user_id="user-id"}`, float64(updated.Unix()))
+}
+
+func TestPagination(t *testing.T) {
+	t.Parallel()
+
+	var services []api.Service
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		services = append(services, api.Service{ID: id, Name: id, Version: 1})
+	}
+	server := fastlytest.NewServer(t, fakefastly.Services(services...))
+
+	var (
+		uri  = server.URL + "/service?per_page=2"
+		have []string
+	)
+	for uri != "" {
+		resp, err := http.Get(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page []api.Service
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if len(page) > 2 {
+			t.Fatalf("page of %d services", len(page))
+		}
+		for _, s := range page {
+			have = append(have, s.ID)
+		}
+		uri = ""
+		if next, err := api.GetNextLink(resp); err == nil {
+			uri = next.String()
+		}
+	}
+
+	if want := []string{"a", "b", "c", "d", "e"}; !cmp.Equal(want, have) {
+		t.Error(cmp.Diff(want, have))
+	}
+}
+
+func TestCertificatePagination(t *testing.T) {
+	t.Parallel()
+
+	var certificates []api.Certificate
+	for _, id := range []string{"a", "b", "c"} {
+		certificates = append(certificates, api.Certificate{ID: id})
+	}
+	server := fastlytest.NewServer(t, fakefastly.Certificates(certificates...))
+
+	var (
+		uri  = server.URL + "/tls/certificates?page%5Bnumber%5D=1&page%5Bsize%5D=2"
+		have []string
+	)
+	for uri != "" {
+		resp, err := http.Get(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page api.CertificateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		for _, c := range page.Certificates {
+			have = append(have, c.ID)
+		}
+		uri = ""
+		if next := page.Links["next"]; next != "" {
+			uri = server.URL + next
+		}
+	}
+
+	if want := []string{"a", "b", "c"}; !cmp.Equal(want, have) {
+		t.Error(cmp.Diff(want, have))
+	}
+}
@@ -0,0 +1,154 @@
+package fakefastly
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config describes the fake account and its traffic.
+type Config struct {
+	// Services in the account. By default, DefaultServices are used.
+	Services []Service
+
+	// Datacenters which serve traffic. By default, DefaultDatacenters are used.
+	Datacenters []Datacenter
+
+	// Products the account is entitled to, by name. Products which aren't
+	// listed are entitled.
+	Products map[string]bool
+
+	// Shape of the traffic of every service. By default, DefaultShape is used.
+	Shape Shape
+
+	// Seed for the pseudo-random variation of the traffic. The traffic is a
+	// deterministic function of the seed, the service, the datacenter, and the
+	// second, so fake servers with the same config serve the same data.
+	Seed uint64
+}
+
+// Service is a fake service.
+type Service struct {
+	ID      string
+	Name    string
+	Version int
+
+	// Origins and Domains are the names reported by the origin and domain
+	// inspectors. Their traffic is split evenly.
+	Origins []string
+	Domains []string
+
+	// Dictionaries are the names of the edge dictionaries of the service.
+	Dictionaries []string
+
+	// Scale multiplies the requests per second of the shape for the service.
+	// Zero means 1.
+	Scale float64
+}
+
+// Datacenter is a fake datacenter, aka POP.
+type Datacenter struct {
+	Code      string
+	Name      string
+	Group     string
+	Latitude  float64
+	Longitude float64
+
+	// Weight is the relative share of traffic served by the datacenter. Zero
+	// means 1.
+	Weight float64
+}
+
+// Shape describes how the traffic of a service varies over time.
+type Shape struct {
+	// RequestsPerSecond is the peak average request rate of a service, across
+	// all datacenters.
+	RequestsPerSecond float64
+
+	// DiurnalAmplitude is the fraction (0–1) by which the request rate drops
+	// from its peak to its trough over each DiurnalPeriod, which defaults to
+	// 24h. The trough is at midnight UTC, and the peak is at noon UTC.
+	DiurnalAmplitude float64
+	DiurnalPeriod    time.Duration
+
+	// HitRatio is the fraction (0–1) of requests served from cache.
+	HitRatio float64
+
+	// ErrorRatio is the fraction (0–1) of requests which result in a 5xx
+	// error outside of error bursts.
+	ErrorRatio float64
+
+	// Every ErrorBurstEvery, for ErrorBurstDuration, the fraction of requests
+	// which result in a 5xx error is ErrorBurstRatio instead. Bursts are
+	// disabled if either duration is zero.
+	ErrorBurstEvery    time.Duration
+	ErrorBurstDuration time.Duration
+	ErrorBurstRatio    float64
+
+	// Every FailoverEvery, for FailoverDuration, one datacenter fails, in
+	// turn, and its traffic is served by the next datacenter in the list.
+	// Failovers are disabled if either duration is zero.
+	FailoverEvery    time.Duration
+	FailoverDuration time.Duration
+}
+
+// DefaultShape is a moderately busy service, with a daily cycle, a 5-minute
+// error burst every hour, and a 10-minute datacenter failover every 2 hours.
+var DefaultShape = Shape{
+	RequestsPerSecond:  500,
+	DiurnalAmplitude:   0.6,
+	DiurnalPeriod:      24 * time.Hour,
+	HitRatio:           0.9,
+	ErrorRatio:         0.002,
+	ErrorBurstEvery:    time.Hour,
+	ErrorBurstDuration: 5 * time.Minute,
+	ErrorBurstRatio:    0.15,
+	FailoverEvery:      2 * time.Hour,
+	FailoverDuration:   10 * time.Minute,
+}
+
+// DefaultDatacenters are a few real datacenters around the world.
+var DefaultDatacenters = []Datacenter{
+	{Code: "IAD", Name: "Ashburn", Group: "United States", Latitude: 38.944533, Longitude: -77.455811, Weight: 3},
+	{Code: "JFK", Name: "New York City", Group: "United States", Latitude: 40.639751, Longitude: -73.778925, Weight: 2},
+	{Code: "SJC", Name: "San Jose", Group: "United States", Latitude: 37.363947, Longitude: -121.928938, Weight: 2},
+	{Code: "AMS", Name: "Amsterdam", Group: "Europe", Latitude: 52.308613, Longitude: 4.763889, Weight: 2},
+	{Code: "FRA", Name: "Frankfurt", Group: "Europe", Latitude: 50.033333, Longitude: 8.570556, Weight: 2},
+	{Code: "LHR", Name: "London", Group: "Europe", Latitude: 51.4775, Longitude: -0.461389, Weight: 2},
+	{Code: "NRT", Name: "Tokyo", Group: "Asia", Latitude: 35.764722, Longitude: 140.386389, Weight: 1},
+	{Code: "SYD", Name: "Sydney", Group: "Asia/Pacific", Latitude: -33.946111, Longitude: 151.177222, Weight: 1},
+}
+
+// DefaultServices returns n fake services, each with a couple of origins,
+// domains, and dictionaries.
+func DefaultServices(n int) []Service {
+	services := make([]Service, n)
+	for i := range services {
+		name := fmt.Sprintf("service-%d", i+1)
+		services[i] = Service{
+			ID:           fmt.Sprintf("FAKE%018d", i+1),
+			Name:         name,
+			Version:      1 + i%5,
+			Origins:      []string{name + "-primary", name + "-secondary"},
+			Domains:      []string{name + ".example.com", "www." + name + ".example.com"},
+			Dictionaries: []string{"redirects", "feature_flags"},
+			Scale:        1 / float64(i+1),
+		}
+	}
+	return services
+}
+
+func (c Config) withDefaults() Config {
+	if c.Services == nil {
+		c.Services = DefaultServices(3)
+	}
+	if c.Datacenters == nil {
+		c.Datacenters = DefaultDatacenters
+	}
+	if c.Shape == (Shape{}) {
+		c.Shape = DefaultShape
+	}
+	if c.Shape.DiurnalPeriod <= 0 {
+		c.Shape.DiurnalPeriod = 24 * time.Hour
+	}
+	return c
+}
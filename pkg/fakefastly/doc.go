@@ -0,0 +1,8 @@
+// Package fakefastly serves a stand-in for the Fastly APIs used by the
+// exporter, with synthetic but realistic services and traffic, so that
+// dashboards and alerts can be built without a Fastly token.
+//
+// The server is made of fake endpoints, which serve the responses of the
+// Fastly APIs from exact data. They're also available on their own, via
+// NewHandler, e.g. for tests, see the fastlytest package.
+package fakefastly
@@ -0,0 +1,67 @@
+package fakefastly
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Endpoint is a fake of a Fastly API endpoint. Endpoints are served by
+// NewHandler, and make up the Server.
+type Endpoint struct {
+	// Method and Path of the requests to the endpoint. The path is a
+	// gorilla/mux template, and may contain variables, which the handler can
+	// read with mux.Vars.
+	Method string
+	Path   string
+
+	Handler http.Handler
+}
+
+// NewHandler returns a handler which serves the endpoints. A request is served
+// by the first endpoint which matches it, so an endpoint may be overridden by
+// an earlier one, e.g. an Error. Requests which match no endpoint get a 404 in
+// the format of the Fastly API.
+func NewHandler(endpoints ...Endpoint) http.Handler {
+	router := mux.NewRouter()
+	for _, e := range endpoints {
+		route := router.Path(e.Path).Handler(e.Handler)
+		if e.Method != "" {
+			route.Methods(e.Method)
+		}
+	}
+	router.NotFoundHandler = Error("", "", http.StatusNotFound, "Record not found").Handler
+	return router
+}
+
+// Error is an endpoint which fails every request with the code, and a body
+// with the message, like the Fastly API. An empty method matches every method.
+func Error(method, path string, code int, msg string) Endpoint {
+	return Endpoint{
+		Method: method,
+		Path:   path,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, code, map[string]string{"msg": msg})
+		}),
+	}
+}
+
+// dynamic is the endpoint returned by build, which is called for every
+// request, so that the response can depend on e.g. the current time.
+func dynamic(build func() Endpoint) Endpoint {
+	e := build()
+	return Endpoint{
+		Method: e.Method,
+		Path:   e.Path,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			build().Handler.ServeHTTP(w, r)
+		}),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
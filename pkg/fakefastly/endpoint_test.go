@@ -0,0 +1,39 @@
+package fakefastly_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/fakefastly"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	server := fastlytest.NewServer(t,
+		fakefastly.Error("GET", "/datacenters", http.StatusUnauthorized, "Provided credentials are missing or invalid"),
+		fakefastly.Datacenters(api.Datacenter{Code: "AMS"}),
+	)
+
+	cache := api.NewDatacenterCache(server.Client(), "token", true, api.WithBaseURL(server.URL))
+	want := &api.Error{Code: http.StatusUnauthorized, Msg: "Provided credentials are missing or invalid"}
+	if have := cache.Refresh(context.Background()); !cmp.Equal(error(want), have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	t.Parallel()
+
+	server := fastlytest.NewServer(t)
+
+	cache := api.NewDatacenterCache(server.Client(), "token", true, api.WithBaseURL(server.URL))
+	want := &api.Error{Code: http.StatusNotFound, Msg: "Record not found"}
+	if have := cache.Refresh(context.Background()); !cmp.Equal(error(want), have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
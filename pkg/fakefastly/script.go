@@ -0,0 +1,178 @@
+package fakefastly
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/fastly/fastly-exporter/pkg/domain"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/gorilla/mux"
+)
+
+// script is a sequence of seconds of real-time data, served like rt.fastly.com
+// serves them. A request gets every second recorded after its timestamp, or
+// blocks until there is one. A timestamp of zero, or h for the recent history,
+// gets every second.
+type script struct {
+	mtx     sync.Mutex
+	seconds []scriptedSecond
+	changed chan struct{}
+	served  uint64
+}
+
+type scriptedSecond struct {
+	recorded uint64
+	data     json.RawMessage
+}
+
+// add records data for the second, and unblocks waiting requests.
+func (s *script) add(recorded uint64, data interface{}) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		panic(fmt.Sprintf("fakefastly: encoding second %d: %v", recorded, err))
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.seconds = append(s.seconds, scriptedSecond{recorded, buf})
+	sort.SliceStable(s.seconds, func(i, j int) bool { return s.seconds[i].recorded < s.seconds[j].recorded })
+
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// after returns the seconds recorded after ts, or a channel which is closed
+// when more seconds are added.
+func (s *script) after(ts uint64) ([]scriptedSecond, <-chan struct{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	i := sort.Search(len(s.seconds), func(i int) bool { return s.seconds[i].recorded > ts })
+	if i < len(s.seconds) {
+		seconds := append([]scriptedSecond{}, s.seconds[i:]...)
+		s.served = seconds[len(seconds)-1].recorded
+		return seconds, nil
+	}
+
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return nil, s.changed
+}
+
+// Served returns the timestamp of the last response served, or zero if no
+// response has been served.
+func (s *script) Served() uint64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.served
+}
+
+func (s *script) endpoint(path string) Endpoint {
+	return Endpoint{
+		Method: "GET",
+		Path:   path,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ts, _, err := parseTimestamp(mux.Vars(r)["ts"]) // the history is every second
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "invalid timestamp"})
+				return
+			}
+
+			for {
+				seconds, changed := s.after(ts)
+				if seconds != nil {
+					response := struct {
+						Timestamp      uint64            `json:"Timestamp"`
+						AggregateDelay int               `json:"AggregateDelay"`
+						Data           []json.RawMessage `json:"Data"`
+					}{
+						Timestamp: seconds[len(seconds)-1].recorded,
+					}
+					for _, second := range seconds {
+						response.Data = append(response.Data, second.data)
+					}
+					writeJSON(w, http.StatusOK, response)
+					return
+				}
+
+				select {
+				case <-changed:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}),
+	}
+}
+
+// parseTimestamp parses the timestamp of a real-time request, which is either
+// a Unix timestamp, or h for the recent history.
+func parseTimestamp(s string) (ts uint64, history bool, err error) {
+	if s == "h" {
+		return 0, true, nil
+	}
+	ts, err = strconv.ParseUint(s, 10, 64)
+	return ts, false, err
+}
+
+//
+//
+//
+
+// RealtimeScript is a sequence of seconds of real-time stats, served by the
+// Realtime endpoint. The zero value is an empty script, which may be added to
+// while it's served.
+type RealtimeScript struct{ script }
+
+// Add records the stats of the second, in total and by datacenter.
+func (s *RealtimeScript) Add(recorded uint64, aggregated realtime.Datacenter, datacenters map[string]realtime.Datacenter) {
+	s.add(recorded, struct {
+		Datacenter map[string]realtime.Datacenter `json:"datacenter"`
+		Aggregated realtime.Datacenter            `json:"aggregated"`
+		Recorded   uint64                         `json:"recorded"`
+	}{datacenters, aggregated, recorded})
+}
+
+// Realtime is the /v1/channel endpoint of the service, which serves the script.
+func Realtime(serviceID string, s *RealtimeScript) Endpoint {
+	return s.endpoint("/v1/channel/" + serviceID + "/ts/{ts}")
+}
+
+// OriginScript is a sequence of seconds of origin inspector stats, served by
+// the Origins endpoint. The zero value is an empty script, which may be added
+// to while it's served.
+type OriginScript struct{ script }
+
+// Add records the stats of the second, in total and by datacenter.
+func (s *OriginScript) Add(recorded uint64, aggregated origin.ByOrigin, datacenters origin.ByDatacenter) {
+	s.add(recorded, origin.Data{Datacenter: datacenters, Aggregated: aggregated, Recorded: recorded})
+}
+
+// Origins is the /v1/origins endpoint of the service, which serves the script.
+func Origins(serviceID string, s *OriginScript) Endpoint {
+	return s.endpoint("/v1/origins/" + serviceID + "/ts/{ts}")
+}
+
+// DomainScript is a sequence of seconds of domain inspector stats, served by
+// the Domains endpoint. The zero value is an empty script, which may be added
+// to while it's served.
+type DomainScript struct{ script }
+
+// Add records the stats of the second, in total and by datacenter.
+func (s *DomainScript) Add(recorded uint64, aggregated domain.ByDomain, datacenters domain.ByDatacenter) {
+	s.add(recorded, domain.Data{Datacenter: datacenters, Aggregated: aggregated, Recorded: recorded})
+}
+
+// Domains is the /v1/domains endpoint of the service, which serves the script.
+func Domains(serviceID string, s *DomainScript) Endpoint {
+	return s.endpoint("/v1/domains/" + serviceID + "/ts/{ts}")
+}
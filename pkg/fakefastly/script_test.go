@@ -0,0 +1,86 @@
+package fakefastly_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fakefastly"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRealtimeScript(t *testing.T) {
+	t.Parallel()
+
+	var (
+		serviceID = "my-service-id"
+		script    fakefastly.RealtimeScript
+		server    = fastlytest.NewServer(t, fakefastly.Realtime(serviceID, &script))
+		registry  = prometheus.NewRegistry()
+		metrics   = prom.NewMetrics("fastly", "rt", filter.Filter{}, registry)
+		processed = make(chan struct{}, 10)
+		options   = []rt.SubscriberOption{rt.WithBaseURL(server.URL), rt.WithPostprocess(func() { processed <- struct{}{} })}
+		sub       = rt.NewSubscriber(server.Client(), "token", serviceID, metrics, options...)
+	)
+
+	script.Add(1000, realtime.Datacenter{Requests: 3}, map[string]realtime.Datacenter{"AMS": {Requests: 1}, "SJC": {Requests: 2}})
+	script.Add(1001, realtime.Datacenter{Requests: 4}, map[string]realtime.Datacenter{"AMS": {Requests: 4}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sub.RunRealtime(ctx)
+
+	wait(t, processed)
+	if want, have := uint64(1001), script.Served(); want != have {
+		t.Errorf("served: want %d, have %d", want, have)
+	}
+	fastlytest.AssertMetrics(t, registry, "fastly_rt_requests_total", map[string]float64{
+		`fastly_rt_requests_total{datacenter="AMS",service_id="my-service-id",service_name="my-service-id"}`: 5,
+		`fastly_rt_requests_total{datacenter="SJC",service_id="my-service-id",service_name="my-service-id"}`: 2,
+	})
+
+	// The subscriber waits for the next second.
+	script.Add(1002, realtime.Datacenter{Requests: 10}, map[string]realtime.Datacenter{"SJC": {Requests: 10}})
+	wait(t, processed)
+	fastlytest.AssertMetric(t, registry, `fastly_rt_requests_total{datacenter="SJC",service_id="my-service-id",service_name="my-service-id"}`, 12)
+}
+
+func TestOriginScript(t *testing.T) {
+	t.Parallel()
+
+	var (
+		serviceID = "my-service-id"
+		script    fakefastly.OriginScript
+		server    = fastlytest.NewServer(t, fakefastly.Origins(serviceID, &script))
+		registry  = prometheus.NewRegistry()
+		metrics   = prom.NewMetrics("fastly", "rt", filter.Filter{}, registry)
+		processed = make(chan struct{}, 10)
+		options   = []rt.SubscriberOption{rt.WithBaseURL(server.URL), rt.WithPostprocess(func() { processed <- struct{}{} })}
+		sub       = rt.NewSubscriber(server.Client(), "token", serviceID, metrics, options...)
+	)
+
+	script.Add(1000, origin.ByOrigin{"backend": {Responses: 7}}, origin.ByDatacenter{"AMS": {"backend": {Responses: 7}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sub.RunOrigins(ctx)
+
+	wait(t, processed)
+	fastlytest.AssertMetric(t, registry, `fastly_origin_responses_total{datacenter="AMS",origin="backend",service_id="my-service-id",service_name="my-service-id",source="delivery"}`, 7)
+}
+
+func wait(t *testing.T, c <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,326 @@
+package fakefastly
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/domain"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/gorilla/mux"
+)
+
+// maxSeconds is the maximum number of seconds of real-time data in a single
+// response, which bounds the size of responses to clients which fall behind.
+const maxSeconds = 60
+
+// Server is an http.Handler which serves the endpoints of api.fastly.com and
+// rt.fastly.com used by the exporter. Real-time requests block until there's
+// at least one second of new data, like the real API. Tokens aren't checked.
+type Server struct {
+	config  Config
+	traffic traffic
+	now     func() time.Time
+
+	http.Handler
+}
+
+// ServerOption provides some additional behavior to a server.
+type ServerOption func(*Server)
+
+// WithClock sets the source of the current time of the server, which is
+// useful to serve traffic from a specific time. By default, time.Now is used.
+func WithClock(now func() time.Time) ServerOption {
+	return func(s *Server) { s.now = now }
+}
+
+// NewServer returns a fake server for the config.
+func NewServer(config Config, options ...ServerOption) *Server {
+	config = config.withDefaults()
+	s := &Server{
+		config:  config,
+		traffic: traffic{config: config},
+		now:     time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	endpoints := []Endpoint{
+		Services(s.services()...),
+		Datacenters(s.datacenters()...),
+		Products(s.products()),
+		dynamic(func() Endpoint { return Certificates(s.certificates()...) }),
+		dynamic(func() Endpoint {
+			return Token("faketoken", "fakeuser", s.now().UTC().Truncate(24*time.Hour).AddDate(1, 0, 0))
+		}),
+	}
+	for _, svc := range config.Services {
+		endpoints = append(endpoints, dynamic(func() Endpoint { return Dictionaries(svc.ID, svc.Version, s.dictionaries(svc)...) }))
+	}
+	endpoints = append(endpoints,
+		Endpoint{Method: "GET", Path: "/v1/channel/{id}/ts/{ts}", Handler: http.HandlerFunc(s.handleRealtime)},
+		Endpoint{Method: "GET", Path: "/v1/origins/{id}/ts/{ts}", Handler: http.HandlerFunc(s.handleOrigins)},
+		Endpoint{Method: "GET", Path: "/v1/domains/{id}/ts/{ts}", Handler: http.HandlerFunc(s.handleDomains)},
+	)
+	s.Handler = NewHandler(endpoints...)
+
+	return s
+}
+
+func (s *Server) service(id string) (Service, bool) {
+	for _, svc := range s.config.Services {
+		if svc.ID == id {
+			return svc, true
+		}
+	}
+	return Service{}, false
+}
+
+//
+//
+//
+
+func (s *Server) services() []api.Service {
+	services := make([]api.Service, 0, len(s.config.Services))
+	for _, svc := range s.config.Services {
+		services = append(services, api.Service{ID: svc.ID, Name: svc.Name, Version: svc.Version})
+	}
+	return services
+}
+
+func (s *Server) datacenters() []api.Datacenter {
+	datacenters := make([]api.Datacenter, 0, len(s.config.Datacenters))
+	for _, d := range s.config.Datacenters {
+		datacenters = append(datacenters, api.Datacenter{Code: d.Code, Name: d.Name, Group: d.Group, Coördinates: api.Coördinates{Latitude: d.Latitude, Longitude: d.Longitude}})
+	}
+	return datacenters
+}
+
+// products returns the entitlements of the config, where products which
+// aren't listed are entitled.
+func (s *Server) products() map[string]bool {
+	products := map[string]bool{}
+	for _, name := range product.Names() {
+		products[name] = true
+	}
+	for name, hasAccess := range s.config.Products {
+		products[name] = hasAccess
+	}
+	return products
+}
+
+// certificates returns a certificate for the first domain of every service,
+// which expire 30 days apart.
+func (s *Server) certificates() []api.Certificate {
+	var (
+		now          = s.now().UTC()
+		certificates = make([]api.Certificate, 0, len(s.config.Services))
+	)
+	for i, svc := range s.config.Services {
+		if len(svc.Domains) == 0 {
+			continue
+		}
+		certificates = append(certificates, api.Certificate{
+			ID: fmt.Sprintf("cert%d", i+1),
+			Attributes: api.Attributes{
+				CN:       svc.Domains[0],
+				Name:     svc.Name,
+				Issuer:   "Fake CA",
+				NotAfter: now.Truncate(24*time.Hour).AddDate(0, 0, 30*(i+1)).Format("2006-01-02T15:04:05.000Z"),
+				SN:       strconv.Itoa(1000000 + i),
+			},
+		})
+	}
+	return certificates
+}
+
+// dictionaries returns the dictionaries of the service, which are updated
+// every hour, with a varying number of items.
+func (s *Server) dictionaries(svc Service) []Dictionary {
+	var (
+		updated      = s.now().UTC().Truncate(time.Hour)
+		dictionaries = make([]Dictionary, 0, len(svc.Dictionaries))
+	)
+	for _, name := range svc.Dictionaries {
+		id := svc.ID + "-" + name
+		items := 10 + int64(s.traffic.noise(id, 0, uint64(updated.Unix()), 1)*1000)
+		dictionaries = append(dictionaries, Dictionary{
+			ID:          id,
+			Name:        name,
+			Digest:      fmt.Sprintf("%x", items*2654435761),
+			ItemCount:   items,
+			LastUpdated: updated,
+		})
+	}
+	return dictionaries
+}
+
+//
+//
+//
+
+// seconds blocks until there's at least one second of new data after ts, and
+// returns the seconds whose data should be in the response. A ts of zero
+// starts at the previous second. It returns false if the request is canceled.
+func (s *Server) seconds(r *http.Request, ts uint64) ([]uint64, bool) {
+	now := uint64(s.now().Unix())
+	if ts == 0 || ts > now {
+		ts = now - 1
+	}
+	if now-ts > maxSeconds {
+		ts = now - maxSeconds
+	}
+
+	for ts >= now-1 {
+		// The current second isn't complete yet. Poll the clock, which may
+		// not be the real one.
+		wait := time.Unix(int64(ts)+2, 0).Sub(s.now())
+		switch {
+		case wait < 10*time.Millisecond:
+			wait = 10 * time.Millisecond
+		case wait > time.Second:
+			wait = time.Second
+		}
+		select {
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return nil, false
+		}
+		now = uint64(s.now().Unix())
+	}
+
+	seconds := make([]uint64, 0, now-1-ts)
+	for second := ts + 1; second < now; second++ {
+		seconds = append(seconds, second)
+	}
+	return seconds, true
+}
+
+// parseRealtime resolves the service and timestamp of a real-time request, and
+// writes an error response if they're invalid.
+func (s *Server) parseRealtime(w http.ResponseWriter, r *http.Request) (Service, uint64, bool) {
+	svc, ok := s.service(mux.Vars(r)["id"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"Error": "Service not found"})
+		return Service{}, 0, false
+	}
+	ts, history, err := parseTimestamp(mux.Vars(r)["ts"])
+	if history {
+		ts = uint64(s.now().Unix()) - maxSeconds // as far back as a single response goes
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"Error": "invalid timestamp " + url.QueryEscape(mux.Vars(r)["ts"])})
+		return Service{}, 0, false
+	}
+	return svc, ts, true
+}
+
+// realtimeResponse has the same JSON encoding as realtime.Response, whose Data
+// elements are of an anonymous type.
+type realtimeResponse struct {
+	Timestamp      uint64         `json:"Timestamp"`
+	AggregateDelay int64          `json:"AggregateDelay"`
+	Data           []realtimeData `json:"Data"`
+	Error          string         `json:"error,omitempty"`
+}
+
+type realtimeData struct {
+	Datacenter map[string]realtime.Datacenter `json:"datacenter"`
+	Aggregated realtime.Datacenter            `json:"aggregated"`
+	Recorded   uint64                         `json:"recorded"`
+}
+
+func (s *Server) handleRealtime(w http.ResponseWriter, r *http.Request) {
+	svc, ts, ok := s.parseRealtime(w, r)
+	if !ok {
+		return
+	}
+	seconds, ok := s.seconds(r, ts)
+	if !ok {
+		return
+	}
+
+	response := realtimeResponse{Timestamp: seconds[len(seconds)-1], AggregateDelay: 5}
+	for _, second := range seconds {
+		d := realtimeData{Datacenter: map[string]realtime.Datacenter{}, Recorded: second}
+		var total sample
+		for i, dc := range s.config.Datacenters {
+			smp := s.traffic.sample(svc, i, second)
+			d.Datacenter[dc.Code] = realtimeStats(smp)
+			total = total.add(smp)
+		}
+		d.Aggregated = realtimeStats(total)
+		response.Data = append(response.Data, d)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleOrigins(w http.ResponseWriter, r *http.Request) {
+	svc, ts, ok := s.parseRealtime(w, r)
+	if !ok {
+		return
+	}
+	seconds, ok := s.seconds(r, ts)
+	if !ok {
+		return
+	}
+
+	response := origin.Response{Timestamp: seconds[len(seconds)-1], AggregateDelay: 5}
+	for _, second := range seconds {
+		response.Data = append(response.Data, origin.Data{Datacenter: origin.ByDatacenter{}, Aggregated: origin.ByOrigin{}, Recorded: second})
+	}
+	for j, second := range seconds {
+		d := response.Data[j]
+		totals := make([]sample, len(svc.Origins))
+		for i, dc := range s.config.Datacenters {
+			byOrigin := origin.ByOrigin{}
+			for k, smp := range splitSample(s.traffic.sample(svc, i, second).missesOnly(), len(svc.Origins)) {
+				byOrigin[svc.Origins[k]] = originStats(smp)
+				totals[k] = totals[k].add(smp)
+			}
+			d.Datacenter[dc.Code] = byOrigin
+		}
+		for k, total := range totals {
+			d.Aggregated[svc.Origins[k]] = originStats(total)
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleDomains(w http.ResponseWriter, r *http.Request) {
+	svc, ts, ok := s.parseRealtime(w, r)
+	if !ok {
+		return
+	}
+	seconds, ok := s.seconds(r, ts)
+	if !ok {
+		return
+	}
+
+	response := domain.Response{Timestamp: seconds[len(seconds)-1], AggregateDelay: 5}
+	for _, second := range seconds {
+		response.Data = append(response.Data, domain.Data{Datacenter: domain.ByDatacenter{}, Aggregated: domain.ByDomain{}, Recorded: second})
+	}
+	for j, second := range seconds {
+		d := response.Data[j]
+		totals := make([]sample, len(svc.Domains))
+		for i, dc := range s.config.Datacenters {
+			byDomain := domain.ByDomain{}
+			for k, smp := range splitSample(s.traffic.sample(svc, i, second), len(svc.Domains)) {
+				byDomain[svc.Domains[k]] = domainStats(smp)
+				totals[k] = totals[k].add(smp)
+			}
+			d.Datacenter[dc.Code] = byDomain
+		}
+		for k, total := range totals {
+			d.Aggregated[svc.Domains[k]] = domainStats(total)
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
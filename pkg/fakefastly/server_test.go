@@ -0,0 +1,216 @@
+package fakefastly_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/domain"
+	"github.com/fastly/fastly-exporter/pkg/fakefastly"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/go-kit/log"
+)
+
+func TestAPI(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(fakefastly.NewServer(fakefastly.Config{
+		Services: fakefastly.DefaultServices(250),
+		Products: map[string]bool{"origin_inspector": false},
+	}))
+	defer server.Close()
+
+	var (
+		ctx    = context.Background()
+		client = server.Client()
+		logger = log.NewNopLogger()
+	)
+
+	services := api.NewServiceCache(client, "token", api.WithServiceCacheBaseURL(server.URL))
+	if err := services.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 250, len(services.ServiceIDs()); want != have {
+		t.Errorf("services: want %d, have %d", want, have)
+	}
+
+	datacenters := api.NewDatacenterCache(client, "token", true, api.WithBaseURL(server.URL))
+	if err := datacenters.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := len(fakefastly.DefaultDatacenters), len(datacenters.Datacenters()); want != have {
+		t.Errorf("datacenters: want %d, have %d", want, have)
+	}
+
+	products := api.NewProductCache(client, "token", logger, api.WithBaseURL(server.URL))
+	if err := products.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if products.HasAccess("origin_inspector") || !products.HasAccess("domain_inspector") {
+		t.Errorf("products: want domain_inspector only")
+	}
+
+	certificates := api.NewCertificateCache(client, "token", true, logger, api.WithBaseURL(server.URL))
+	if err := certificates.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 250, len(certificates.Certificates()); want != have {
+		t.Errorf("certificates: want %d, have %d", want, have)
+	}
+
+	dictionaries := api.NewDictionaryInfoCache(client, "token", logger, services, true, api.WithBaseURL(server.URL))
+	if err := dictionaries.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2*250, len(dictionaries.Dictionaries()); want != have {
+		t.Errorf("dictionaries: want %d, have %d", want, have)
+	}
+}
+
+func TestRealtime(t *testing.T) {
+	t.Parallel()
+
+	var (
+		services = fakefastly.DefaultServices(1)
+		id       = services[0].ID
+		now      = time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+		server   = httptest.NewServer(fakefastly.NewServer(fakefastly.Config{Services: services}, fakefastly.WithClock(func() time.Time { return now })))
+	)
+	defer server.Close()
+
+	since := uint64(now.Unix()) - 10
+
+	var rt realtime.Response
+	get(t, fmt.Sprintf("%s/v1/channel/%s/ts/%d", server.URL, id, since), &rt)
+	if want, have := 9, len(rt.Data); want != have {
+		t.Fatalf("realtime: want %d seconds, have %d", want, have)
+	}
+	if want, have := uint64(now.Unix())-1, rt.Timestamp; want != have {
+		t.Errorf("realtime: want timestamp %d, have %d", want, have)
+	}
+	for _, d := range rt.Data {
+		var sum uint64
+		for _, dc := range d.Datacenter {
+			sum += dc.Requests
+		}
+		if sum == 0 || sum != d.Aggregated.Requests {
+			t.Errorf("realtime: %d: aggregated requests %d, datacenter requests %d", d.Recorded, d.Aggregated.Requests, sum)
+		}
+	}
+
+	var or origin.Response
+	get(t, fmt.Sprintf("%s/v1/origins/%s/ts/%d", server.URL, id, since), &or)
+	if want, have := 9, len(or.Data); want != have {
+		t.Fatalf("origins: want %d seconds, have %d", want, have)
+	}
+	if want, have := 2, len(or.Data[0].Aggregated); want != have {
+		t.Errorf("origins: want %d origins, have %d", want, have)
+	}
+
+	var dr domain.Response
+	get(t, fmt.Sprintf("%s/v1/domains/%s/ts/%d", server.URL, id, since), &dr)
+	if want, have := 9, len(dr.Data); want != have {
+		t.Fatalf("domains: want %d seconds, have %d", want, have)
+	}
+	var edge uint64
+	for _, stats := range dr.Data[0].Aggregated {
+		edge += stats.EdgeRequests
+	}
+	if want, have := rt.Data[0].Aggregated.Requests, edge; want != have {
+		t.Errorf("domains: want %d edge requests, have %d", want, have)
+	}
+}
+
+func TestRealtimeBlocks(t *testing.T) {
+	t.Parallel()
+
+	var (
+		services = fakefastly.DefaultServices(1)
+		now      = time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+		server   = httptest.NewServer(fakefastly.NewServer(fakefastly.Config{Services: services}, fakefastly.WithClock(func() time.Time { return now })))
+	)
+	defer server.Close()
+
+	// With a stopped clock, there's never any new data.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v1/channel/%s/ts/0", server.URL, services[0].ID), nil)
+	if resp, err := server.Client().Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("want error, have %s", resp.Status)
+	}
+}
+
+func TestShape(t *testing.T) {
+	t.Parallel()
+
+	var (
+		services = fakefastly.DefaultServices(1)
+		shape    = fakefastly.Shape{
+			RequestsPerSecond:  1000,
+			HitRatio:           0.9,
+			ErrorRatio:         0.001,
+			ErrorBurstEvery:    time.Hour,
+			ErrorBurstDuration: 5 * time.Minute,
+			ErrorBurstRatio:    0.5,
+			FailoverEvery:      time.Hour,
+			FailoverDuration:   10 * time.Minute,
+		}
+	)
+
+	fetch := func(at time.Time) realtime.Response {
+		server := httptest.NewServer(fakefastly.NewServer(fakefastly.Config{Services: services, Shape: shape}, fakefastly.WithClock(func() time.Time { return at })))
+		defer server.Close()
+		var response realtime.Response
+		get(t, fmt.Sprintf("%s/v1/channel/%s/ts/%d", server.URL, services[0].ID, at.Unix()-2), &response)
+		return response
+	}
+
+	// Failovers start on the hour, and move through the datacenters in turn.
+	// Error bursts start on the half hour.
+	var (
+		hour     = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		index    = int(hour.Unix()/3600) % len(fakefastly.DefaultDatacenters)
+		failed   = fakefastly.DefaultDatacenters[index].Code
+		failover = fetch(hour.Add(time.Minute)).Data[0]
+		normal   = fetch(hour.Add(20 * time.Minute)).Data[0]
+		burst    = fetch(hour.Add(31 * time.Minute)).Data[0]
+	)
+
+	if have := failover.Datacenter[failed].Requests; have != 0 {
+		t.Errorf("failover: %s: want 0 requests, have %d", failed, have)
+	}
+	if have := normal.Datacenter[failed].Requests; have == 0 {
+		t.Errorf("normal: %s: want requests, have 0", failed)
+	}
+
+	ratio := func(d realtime.Datacenter) float64 { return float64(d.Errors) / float64(d.Requests) }
+	if have := ratio(normal.Aggregated); have > 0.01 {
+		t.Errorf("normal: want error ratio of about 0.001, have %.3f", have)
+	}
+	if have := ratio(burst.Aggregated); have < 0.4 || have > 0.6 {
+		t.Errorf("burst: want error ratio of about 0.5, have %.3f", have)
+	}
+}
+
+func get(t *testing.T, url string, response interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatal(err)
+	}
+}
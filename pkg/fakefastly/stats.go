@@ -0,0 +1,142 @@
+package fakefastly
+
+import (
+	"github.com/fastly/fastly-exporter/pkg/domain"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+)
+
+// Average sizes and latencies of the synthetic traffic.
+const (
+	headerBytes = 450
+	bodyBytes   = 18 * 1024
+	hitSeconds  = 0.0004
+	missSeconds = 0.08
+)
+
+func (a sample) add(b sample) sample {
+	return sample{
+		requests: a.requests + b.requests,
+		hits:     a.hits + b.hits,
+		misses:   a.misses + b.misses,
+		errors:   a.errors + b.errors,
+		notFound: a.notFound + b.notFound,
+		notMod:   a.notMod + b.notMod,
+		ok:       a.ok + b.ok,
+	}
+}
+
+// missesOnly returns the part of the sample which reaches the origin. Errors
+// are attributed to the origin.
+func (a sample) missesOnly() sample {
+	m := sample{requests: a.misses, misses: a.misses, errors: a.errors}
+	if m.errors > m.requests {
+		m.requests = m.errors
+	}
+	rest := m.requests - m.errors
+	m.notFound = a.notFound
+	if m.notFound > rest {
+		m.notFound = rest
+	}
+	m.ok = rest - m.notFound
+	return m
+}
+
+// splitSample divides the sample evenly into n parts.
+func splitSample(a sample, n int) []sample {
+	if n == 0 {
+		return nil
+	}
+	var (
+		requests = split(a.requests, n)
+		hits     = split(a.hits, n)
+		misses   = split(a.misses, n)
+		errors   = split(a.errors, n)
+		notFound = split(a.notFound, n)
+		notMod   = split(a.notMod, n)
+		ok       = split(a.ok, n)
+		parts    = make([]sample, n)
+	)
+	for i := range parts {
+		parts[i] = sample{requests[i], hits[i], misses[i], errors[i], notFound[i], notMod[i], ok[i]}
+	}
+	return parts
+}
+
+func realtimeStats(a sample) realtime.Datacenter {
+	var missHistogram map[string]uint64
+	if a.misses > 0 {
+		fast := a.misses * 7 / 10
+		missHistogram = map[string]uint64{
+			"50":  fast,
+			"200": a.misses - fast,
+		}
+	}
+	return realtime.Datacenter{
+		Requests:        a.requests,
+		Edge:            a.requests,
+		Hits:            a.hits,
+		Misses:          a.misses,
+		HitsTime:        float64(a.hits) * hitSeconds,
+		MissTime:        float64(a.misses) * missSeconds,
+		MissHistogram:   missHistogram,
+		Errors:          a.errors,
+		Status200:       a.ok,
+		Status2xx:       a.ok,
+		Status304:       a.notMod,
+		Status3xx:       a.notMod,
+		Status404:       a.notFound,
+		Status4xx:       a.notFound,
+		Status503:       a.errors,
+		Status5xx:       a.errors,
+		RespHeaderBytes: a.requests * headerBytes,
+		RespBodyBytes:   a.ok * bodyBytes,
+		ObjectSize10k:   a.ok / 2,
+		ObjectSize100k:  a.ok - a.ok/2,
+	}
+}
+
+func originStats(a sample) origin.Stats {
+	fast := a.requests * 7 / 10
+	return origin.Stats{
+		Responses:       a.requests,
+		Status200:       a.ok,
+		Status2xx:       a.ok,
+		Status404:       a.notFound,
+		Status4xx:       a.notFound,
+		Status503:       a.errors,
+		Status5xx:       a.errors,
+		RespHeaderBytes: a.requests * headerBytes,
+		RespBodyBytes:   a.ok * bodyBytes,
+		Latency10to50:   fast,
+		Latency100to250: a.requests - fast,
+	}
+}
+
+func domainStats(a sample) domain.Stats {
+	var hitRatio, offload float64
+	if a.requests > 0 {
+		hitRatio = float64(a.hits) / float64(a.requests)
+		offload = hitRatio
+	}
+	edgeBytes := a.requests*headerBytes + a.ok*bodyBytes
+	return domain.Stats{
+		Bandwidth:                edgeBytes,
+		EdgeRequests:             a.requests,
+		EdgeHitRequests:          a.hits,
+		EdgeMissRequests:         a.misses,
+		EdgeHitRatio:             hitRatio,
+		EdgeRespHeaderBytes:      a.requests * headerBytes,
+		EdgeRespBodyBytes:        a.ok * bodyBytes,
+		OriginFetches:            a.misses,
+		OriginFetchRespBodyBytes: uint64(float64(a.ok*bodyBytes) * (1 - hitRatio)),
+		OriginOffload:            offload,
+		OriginStatus200:          a.misses,
+		OriginStatus2xx:          a.misses,
+		Status2xx:                a.ok,
+		Status4xx:                a.notFound,
+		Status404:                a.notFound,
+		Status5xx:                a.errors,
+		Status503:                a.errors,
+	}
+}
@@ -0,0 +1,144 @@
+package fakefastly
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// traffic computes the synthetic traffic of a service in a datacenter.
+type traffic struct {
+	config Config
+}
+
+// sample is the traffic of a service in a datacenter during one second.
+type sample struct {
+	requests uint64
+	hits     uint64
+	misses   uint64
+	errors   uint64 // 5xx
+	notFound uint64 // 404
+	notMod   uint64 // 304
+	ok       uint64 // 200
+}
+
+// rate returns the average request rate of the service in the datacenter at
+// the given time, taking into account the diurnal cycle and failovers.
+func (t traffic) rate(s Service, dc int, at time.Time) float64 {
+	shape := t.config.Shape
+
+	scale := s.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	rate := shape.RequestsPerSecond * scale
+
+	// Diurnal cycle: trough at the start of each period, peak half way.
+	if shape.DiurnalAmplitude > 0 {
+		phase := float64(at.UnixNano()%int64(shape.DiurnalPeriod)) / float64(shape.DiurnalPeriod)
+		rate *= 1 - shape.DiurnalAmplitude*(1+math.Cos(2*math.Pi*phase))/2
+	}
+
+	// Share of the datacenter, including the share of a failed datacenter.
+	var total float64
+	weights := make([]float64, len(t.config.Datacenters))
+	for i, d := range t.config.Datacenters {
+		weights[i] = d.Weight
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+		total += weights[i]
+	}
+	if failed, ok := t.failedDatacenter(at); ok {
+		next := (failed + 1) % len(weights)
+		weights[next] += weights[failed]
+		weights[failed] = 0
+	}
+	if total == 0 {
+		return 0
+	}
+	return rate * weights[dc] / total
+}
+
+// failedDatacenter returns the index of the datacenter which is failed over
+// at the given time, if any.
+func (t traffic) failedDatacenter(at time.Time) (int, bool) {
+	shape := t.config.Shape
+	if shape.FailoverEvery <= 0 || shape.FailoverDuration <= 0 || len(t.config.Datacenters) < 2 {
+		return 0, false
+	}
+	n := at.UnixNano() / int64(shape.FailoverEvery)
+	if at.UnixNano()%int64(shape.FailoverEvery) >= int64(shape.FailoverDuration) {
+		return 0, false
+	}
+	return int(n % int64(len(t.config.Datacenters))), true
+}
+
+// errorRatio returns the fraction of requests which fail at the given time.
+func (t traffic) errorRatio(at time.Time) float64 {
+	shape := t.config.Shape
+	if shape.ErrorBurstEvery > 0 && shape.ErrorBurstDuration > 0 {
+		// Bursts start half way through each interval, so that they don't
+		// coincide with failovers at the default settings.
+		offset := (at.UnixNano() + int64(shape.ErrorBurstEvery)/2) % int64(shape.ErrorBurstEvery)
+		if offset < int64(shape.ErrorBurstDuration) {
+			return shape.ErrorBurstRatio
+		}
+	}
+	return shape.ErrorRatio
+}
+
+// sample returns the traffic of the service in the datacenter during the
+// second starting at the given Unix time.
+func (t traffic) sample(s Service, dc int, second uint64) sample {
+	at := time.Unix(int64(second), 0)
+
+	// Vary the rate by ±20%, deterministically.
+	rate := t.rate(s, dc, at) * (0.8 + 0.4*t.noise(s.ID, dc, second, 0))
+	requests := uint64(math.Round(rate))
+
+	var (
+		errors   = uint64(math.Round(float64(requests) * t.errorRatio(at)))
+		rest     = requests - errors
+		notFound = uint64(math.Round(float64(rest) * 0.01))
+		notMod   = uint64(math.Round(float64(rest) * 0.05))
+		hits     = uint64(math.Round(float64(requests) * t.config.Shape.HitRatio))
+	)
+	if hits > requests {
+		hits = requests
+	}
+	return sample{
+		requests: requests,
+		hits:     hits,
+		misses:   requests - hits,
+		errors:   errors,
+		notFound: notFound,
+		notMod:   notMod,
+		ok:       rest - notFound - notMod,
+	}
+}
+
+// noise returns a deterministic pseudo-random number in [0, 1).
+func (t traffic) noise(serviceID string, dc int, second uint64, salt uint64) float64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, n := range []uint64{t.config.Seed, uint64(dc), second, salt} {
+		binary.LittleEndian.PutUint64(buf[:], n)
+		h.Write(buf[:])
+	}
+	h.Write([]byte(serviceID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// split divides n into parts which add up to n.
+func split(n uint64, parts int) []uint64 {
+	out := make([]uint64, parts)
+	for i := range out {
+		out[i] = n / uint64(parts)
+		if uint64(i) < n%uint64(parts) {
+			out[i]++
+		}
+	}
+	return out
+}
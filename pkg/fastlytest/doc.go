@@ -0,0 +1,10 @@
+// Package fastlytest provides fake Fastly API endpoints and metric assertions
+// for tests of code built on the exporter's packages.
+//
+// Each fake endpoint serves responses encoded from the same types that the api
+// caches and rt.Subscriber decode, so fixtures built with this package keep up
+// with changes to the response shapes. The endpoints are those of the
+// fakefastly package, with exact rather than synthetic data. Combine endpoints
+// with NewServer, and point the api caches and subscribers at the server with
+// their base URL options.
+package fastlytest
@@ -0,0 +1,96 @@
+package fastlytest
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics gathers the metrics of the gatherer whose names start with the
+// prefix. They're keyed by series in the text exposition format, e.g.
+// `fastly_rt_requests_total{datacenter="AMS",service_id="x",service_name="y"}`,
+// with labels sorted by name. Histograms and summaries are split into their
+// _bucket, _sum, and _count series.
+func Metrics(t testing.TB, g prometheus.Gatherer, prefix string) map[string]float64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("gathering metrics: %d %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+
+	selected := map[string]float64{}
+
+	s := bufio.NewScanner(rec.Body)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ' ')
+		f, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		selected[line[:i]] = f
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("gathering metrics: %v", err)
+	}
+
+	return selected
+}
+
+// AssertMetrics fails the test unless the metrics of the gatherer whose names
+// start with the prefix are exactly the wanted ones, in the format returned
+// by Metrics. Values are compared to 3 decimal places.
+func AssertMetrics(t testing.TB, g prometheus.Gatherer, prefix string, want map[string]float64) {
+	t.Helper()
+
+	if have := Metrics(t, g, prefix); !cmp.Equal(round(want), round(have)) {
+		t.Errorf("metrics with prefix %q (-want +have):\n%s", prefix, cmp.Diff(round(want), round(have)))
+	}
+}
+
+// AssertMetric fails the test unless the gatherer has the series, in the
+// format returned by Metrics, with the wanted value. Values are compared to 3
+// decimal places.
+func AssertMetric(t testing.TB, g prometheus.Gatherer, series string, want float64) {
+	t.Helper()
+
+	name := series
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		name = name[:i]
+	}
+
+	have, ok := Metrics(t, g, name)[series]
+	switch {
+	case !ok:
+		t.Errorf("%s: not found", series)
+	case roundValue(want) != roundValue(have):
+		t.Errorf("%s: want %v, have %v", series, want, have)
+	}
+}
+
+func round(m map[string]float64) map[string]float64 {
+	rounded := make(map[string]float64, len(m))
+	for k, v := range m {
+		rounded[k] = roundValue(v)
+	}
+	return rounded
+}
+
+func roundValue(v float64) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', 3, 64), 64)
+	return f
+}
@@ -0,0 +1,76 @@
+package fastlytest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registry  = prometheus.NewRegistry()
+		counter   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "x"}, []string{"service", "datacenter"})
+		histogram = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency_seconds", Help: "x", Buckets: []float64{1}})
+		other     = prometheus.NewGauge(prometheus.GaugeOpts{Name: "other", Help: "x"})
+	)
+	registry.MustRegister(counter, histogram, other)
+	counter.WithLabelValues("s1", "AMS").Add(1.0001)
+	histogram.Observe(0.5)
+	other.Set(1)
+
+	want := map[string]float64{
+		`test_requests_total{datacenter="AMS",service="s1"}`: 1.0001,
+		`test_latency_seconds_bucket{le="1"}`:                1,
+		`test_latency_seconds_bucket{le="+Inf"}`:             1,
+		`test_latency_seconds_sum`:                           0.5,
+		`test_latency_seconds_count`:                         1,
+	}
+	if have := fastlytest.Metrics(t, registry, "test_"); !cmp.Equal(want, have) {
+		t.Error(cmp.Diff(want, have))
+	}
+
+	fastlytest.AssertMetrics(t, registry, "test_requests_total", map[string]float64{`test_requests_total{datacenter="AMS",service="s1"}`: 1})
+	fastlytest.AssertMetric(t, registry, `test_latency_seconds_count`, 1)
+
+	for _, testcase := range []struct {
+		name   string
+		assert func(testing.TB)
+		want   string
+	}{
+		{
+			name: "missing series",
+			assert: func(t testing.TB) {
+				fastlytest.AssertMetric(t, registry, `test_requests_total{datacenter="SJC",service="s1"}`, 1)
+			},
+			want: `test_requests_total{datacenter="SJC",service="s1"}: not found`,
+		},
+		{
+			name:   "wrong value",
+			assert: func(t testing.TB) { fastlytest.AssertMetric(t, registry, `other`, 2) },
+			want:   `other: want 2, have 1`,
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			rec := &recordingTB{TB: t}
+			testcase.assert(rec)
+			if want, have := []string{testcase.want}, rec.errors; !cmp.Equal(want, have) {
+				t.Error(cmp.Diff(want, have))
+			}
+		})
+	}
+}
+
+// recordingTB records errors instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,44 @@
+package fastlytest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/fakefastly"
+)
+
+// The fake endpoints are implemented by the fakefastly package, whose server,
+// and the fake-fastly command, are made of the same endpoints. See there for
+// their documentation.
+type (
+	Endpoint       = fakefastly.Endpoint
+	Dictionary     = fakefastly.Dictionary
+	RealtimeScript = fakefastly.RealtimeScript
+	OriginScript   = fakefastly.OriginScript
+	DomainScript   = fakefastly.DomainScript
+)
+
+// The constructors of the fake endpoints, see fakefastly.
+var (
+	NewHandler   = fakefastly.NewHandler
+	Error        = fakefastly.Error
+	Services     = fakefastly.Services
+	Datacenters  = fakefastly.Datacenters
+	Products     = fakefastly.Products
+	Certificates = fakefastly.Certificates
+	Token        = fakefastly.Token
+	Dictionaries = fakefastly.Dictionaries
+	Realtime     = fakefastly.Realtime
+	Origins      = fakefastly.Origins
+	Domains      = fakefastly.Domains
+)
+
+// NewServer starts a server for the endpoints, which is closed when the test
+// and its subtests complete.
+func NewServer(t testing.TB, endpoints ...Endpoint) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(NewHandler(endpoints...))
+	t.Cleanup(server.Close)
+	return server
+}
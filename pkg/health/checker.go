@@ -0,0 +1,219 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/gorilla/mux"
+)
+
+// RefreshStatuser is a consumer contract for the checker. It models the Status
+// method of the caches in package api.
+type RefreshStatuser interface {
+	Status() api.RefreshStatus
+}
+
+// SubscriberStatuser is a consumer contract for the checker. It models the
+// Status method of an rt.Manager.
+type SubscriberStatuser interface {
+	Status() rt.ManagerStatus
+}
+
+// Criteria determine whether the exporter is ready.
+type Criteria struct {
+	// RequiredCaches names the caches which must have been refreshed
+	// successfully at least once. Caches which were never added are ignored.
+	RequiredCaches []string
+
+	// MaxStaleness, if non-zero, is the maximum time since the last successful
+	// refresh of each of the required caches.
+	MaxStaleness time.Duration
+
+	// MaxFailingRatio is the maximum fraction of subscribers, across all
+	// accounts, which may be failing or terminated. A value of 1 or more
+	// disables the check.
+	MaxFailingRatio float64
+}
+
+// Checker serves the liveness endpoint `/healthz`, which always succeeds as
+// long as the exporter can serve requests, and the readiness endpoint
+// `/readyz`, which fails with 503 Service Unavailable unless the criteria are
+// met. Both respond with a JSON report of the status of every component.
+type Checker struct {
+	criteria Criteria
+
+	mtx      sync.Mutex
+	caches   []cacheEntry
+	managers []managerEntry
+
+	http.Handler
+}
+
+type cacheEntry struct {
+	name    string
+	account string
+	cache   RefreshStatuser
+}
+
+type managerEntry struct {
+	account string
+	manager SubscriberStatuser
+}
+
+// NewChecker returns a checker without any components. Use AddCache and
+// AddManager to add them.
+func NewChecker(criteria Criteria) *Checker {
+	c := &Checker{criteria: criteria}
+
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/healthz").HandlerFunc(c.handleHealth)
+	router.Methods("GET").Path("/readyz").HandlerFunc(c.handleReady)
+	c.Handler = router
+
+	return c
+}
+
+// AddCache adds a cache to the report. The name identifies the kind of cache,
+// e.g. "services", and is matched against the required caches of the criteria.
+// The account may be empty.
+func (c *Checker) AddCache(name, account string, cache RefreshStatuser) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.caches = append(c.caches, cacheEntry{name, account, cache})
+}
+
+// AddManager adds the subscribers of a manager to the report. The account may
+// be empty.
+func (c *Checker) AddManager(account string, manager SubscriberStatuser) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.managers = append(c.managers, managerEntry{account, manager})
+}
+
+type report struct {
+	Status      string             `json:"status"`
+	Reasons     []string           `json:"reasons,omitempty"`
+	Caches      []cacheReport      `json:"caches"`
+	Subscribers []subscriberReport `json:"subscribers"`
+}
+
+type cacheReport struct {
+	Name                string     `json:"name"`
+	Account             string     `json:"account,omitempty"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+type subscriberReport struct {
+	Account    string `json:"account,omitempty"`
+	Running    int    `json:"running"`
+	Failing    int    `json:"failing"`
+	Terminated int    `json:"terminated"`
+}
+
+// report collects the status of every component, and evaluates the criteria.
+// The returned reasons explain why the exporter isn't ready, if it isn't.
+func (c *Checker) report(now time.Time) (r report, reasons []string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	required := make(map[string]bool, len(c.criteria.RequiredCaches))
+	for _, name := range c.criteria.RequiredCaches {
+		required[name] = true
+	}
+
+	r.Caches = make([]cacheReport, 0, len(c.caches))
+	for _, e := range c.caches {
+		status := e.cache.Status()
+
+		cr := cacheReport{Name: e.name, Account: e.account, ConsecutiveFailures: status.ConsecutiveFailures}
+		if !status.LastAttempt.IsZero() {
+			cr.LastAttempt = &status.LastAttempt
+		}
+		if !status.LastSuccess.IsZero() {
+			cr.LastSuccess = &status.LastSuccess
+		}
+		if !status.LastFailure.IsZero() {
+			cr.LastFailure = &status.LastFailure
+		}
+		if status.LastError != nil {
+			cr.LastError = status.LastError.Error()
+		}
+		r.Caches = append(r.Caches, cr)
+
+		if !required[e.name] {
+			continue
+		}
+		switch age := now.Sub(status.LastSuccess); {
+		case status.LastSuccess.IsZero():
+			reasons = append(reasons, fmt.Sprintf("%s has never been refreshed successfully", e.describe()))
+		case c.criteria.MaxStaleness > 0 && age > c.criteria.MaxStaleness:
+			reasons = append(reasons, fmt.Sprintf("%s was last refreshed successfully %s ago", e.describe(), age.Round(time.Second)))
+		}
+	}
+
+	var total, unhealthy int
+	r.Subscribers = make([]subscriberReport, 0, len(c.managers))
+	for _, e := range c.managers {
+		status := e.manager.Status()
+		r.Subscribers = append(r.Subscribers, subscriberReport{
+			Account:    e.account,
+			Running:    status.Running,
+			Failing:    status.Failing,
+			Terminated: status.Terminated,
+		})
+		total += status.Running + status.Terminated
+		unhealthy += status.Failing + status.Terminated
+	}
+	if c.criteria.MaxFailingRatio < 1 && total > 0 && float64(unhealthy)/float64(total) > c.criteria.MaxFailingRatio {
+		reasons = append(reasons, fmt.Sprintf("%d of %d subscribers are failing or terminated", unhealthy, total))
+	}
+
+	return r, reasons
+}
+
+func (e cacheEntry) describe() string {
+	if e.account == "" {
+		return e.name + " cache"
+	}
+	return fmt.Sprintf("%s cache of account %s", e.name, e.account)
+}
+
+func (c *Checker) handleHealth(w http.ResponseWriter, req *http.Request) {
+	r, _ := c.report(time.Now())
+	r.Status = "ok"
+	writeReport(w, http.StatusOK, r)
+}
+
+func (c *Checker) handleReady(w http.ResponseWriter, req *http.Request) {
+	r, reasons := c.report(time.Now())
+	if len(reasons) > 0 {
+		r.Status, r.Reasons = "not ready", reasons
+		writeReport(w, http.StatusServiceUnavailable, r)
+		return
+	}
+	r.Status = "ready"
+	writeReport(w, http.StatusOK, r)
+}
+
+func writeReport(w http.ResponseWriter, code int, r report) {
+	buf, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf)
+}
@@ -0,0 +1,108 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/health"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+)
+
+func TestChecker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		services = &mockCache{}
+		products = &mockCache{}
+		manager  = &mockManager{}
+		checker  = health.NewChecker(health.Criteria{
+			RequiredCaches:  []string{"services"},
+			MaxStaleness:    time.Hour,
+			MaxFailingRatio: 0.5,
+		})
+	)
+	checker.AddCache("services", "prod", services)
+	checker.AddCache("products", "prod", products)
+	checker.AddManager("prod", manager)
+
+	type response struct {
+		Status  string   `json:"status"`
+		Reasons []string `json:"reasons"`
+		Caches  []struct {
+			Name      string `json:"name"`
+			Account   string `json:"account"`
+			LastError string `json:"last_error"`
+		} `json:"caches"`
+		Subscribers []struct {
+			Running int `json:"running"`
+			Failing int `json:"failing"`
+		} `json:"subscribers"`
+	}
+
+	get := func(path string) (int, response) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		checker.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var r response
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return rec.Code, r
+	}
+
+	expectReady := func(want bool, reason string) {
+		t.Helper()
+		code, r := get("/readyz")
+		switch {
+		case want && code != http.StatusOK:
+			t.Errorf("want ready, have %d %v", code, r.Reasons)
+		case !want && code != http.StatusServiceUnavailable:
+			t.Errorf("want not ready, have %d", code)
+		case !want && !strings.Contains(strings.Join(r.Reasons, "; "), reason):
+			t.Errorf("want reason containing %q, have %v", reason, r.Reasons)
+		}
+	}
+
+	// The required cache has never been refreshed.
+	expectReady(false, "services cache of account prod has never been refreshed successfully")
+
+	// Liveness doesn't depend on the criteria.
+	if code, r := get("/healthz"); code != http.StatusOK || r.Status != "ok" {
+		t.Errorf("/healthz: want 200 ok, have %d %s", code, r.Status)
+	}
+
+	// Only required caches affect readiness.
+	services.status = api.RefreshStatus{LastAttempt: time.Now(), LastSuccess: time.Now()}
+	products.status = api.RefreshStatus{LastAttempt: time.Now(), LastError: errors.New("boom"), ConsecutiveFailures: 1}
+	expectReady(true, "")
+
+	_, r := get("/readyz")
+	if want, have := "boom", r.Caches[1].LastError; want != have {
+		t.Errorf("last error: want %q, have %q", want, have)
+	}
+
+	// Stale caches aren't ready.
+	services.status.LastSuccess = time.Now().Add(-2 * time.Hour)
+	expectReady(false, "services cache of account prod was last refreshed successfully 2h0m0s ago")
+	services.status.LastSuccess = time.Now()
+
+	// Too many failing subscribers aren't ready.
+	manager.status = rt.ManagerStatus{Running: 4, Failing: 2}
+	expectReady(true, "")
+	manager.status = rt.ManagerStatus{Running: 3, Failing: 2, Terminated: 1}
+	expectReady(false, "3 of 4 subscribers are failing or terminated")
+}
+
+type mockCache struct{ status api.RefreshStatus }
+
+func (c *mockCache) Status() api.RefreshStatus { return c.status }
+
+type mockManager struct{ status rt.ManagerStatus }
+
+func (m *mockManager) Status() rt.ManagerStatus { return m.status }
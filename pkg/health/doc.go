@@ -0,0 +1,3 @@
+// Package health reports the health of the caches and subscribers of the
+// exporter, via liveness and readiness endpoints.
+package health
@@ -12,6 +12,7 @@ type Response struct {
 type Data struct {
 	Datacenter ByDatacenter `json:"datacenter"`
 	Aggregated ByOrigin     `json:"aggregated"`
+	Recorded   uint64       `json:"recorded"`
 }
 
 // ByDatacenter groups origin inspector stats by datacenter.
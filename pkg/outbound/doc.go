@@ -0,0 +1,4 @@
+// Package outbound instruments the requests of the exporter to the Fastly
+// APIs, and tracks the rate limits of the Fastly API, so that requests can be
+// spread out before the budget of a token is exhausted.
+package outbound
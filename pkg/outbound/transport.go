@@ -0,0 +1,245 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultThrottleBelow is the remaining budget of a token below which requests
+// are throttled, by default. The Fastly API allows 1000 requests per hour.
+const DefaultThrottleBelow = 100
+
+// Transport is an http.RoundTripper which counts the requests to the Fastly
+// APIs by host, endpoint, and status code, and records their latency. It also
+// tracks the rate limit of each token, as reported by the Fastly API, and
+// implements api.Throttle, so that the caches slow down while the remaining
+// budget is low.
+type Transport struct {
+	next          http.RoundTripper
+	names         map[string]string // by token
+	throttleBelow int
+
+	mtx      sync.Mutex
+	requests *prometheus.CounterVec   // set by Gatherer
+	duration *prometheus.HistogramVec // set by Gatherer
+	budgets  map[string]*budget       // by token
+}
+
+// budget is the rate limit of a token.
+type budget struct {
+	remaining int
+	reset     time.Time
+	next      time.Time // earliest start of the next throttled request
+}
+
+// TransportOption provides some additional behavior to a transport.
+type TransportOption func(*Transport)
+
+// WithAccountNames sets the names of the accounts of the tokens, which label
+// their rate limit metrics. Rate limits are only reported for these tokens.
+// By default, none are reported.
+func WithAccountNames(names map[string]string) TransportOption {
+	return func(t *Transport) { t.names = names }
+}
+
+// WithThrottleBelow sets the remaining budget of a token below which its
+// requests are throttled: they're spread over the time until the rate limit
+// resets, and wait for the reset once the budget is exhausted. A value of 0
+// disables throttling. By default, DefaultThrottleBelow is used.
+func WithThrottleBelow(n int) TransportOption {
+	return func(t *Transport) { t.throttleBelow = n }
+}
+
+// NewTransport wraps the next transport.
+func NewTransport(next http.RoundTripper, options ...TransportOption) *Transport {
+	t := &Transport{
+		next:          next,
+		throttleBelow: DefaultThrottleBelow,
+		budgets:       map[string]*budget{},
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	begin := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	took := time.Since(begin)
+
+	t.mtx.Lock()
+	requests, duration := t.requests, t.duration
+	t.mtx.Unlock()
+
+	var (
+		host     = req.URL.Host
+		endpoint = endpointOf(req.URL.Path)
+		code     = "error"
+	)
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		t.observe(req.Header.Get("Fastly-Key"), resp.Header)
+	}
+	if requests != nil {
+		requests.WithLabelValues(host, endpoint, code).Inc()
+		duration.WithLabelValues(host, endpoint).Observe(took.Seconds())
+	}
+	return resp, err
+}
+
+// observe records the rate limit of the token, if the headers report it.
+func (t *Transport) observe(token string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("Fastly-RateLimit-Remaining"))
+	if err != nil || token == "" {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("Fastly-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b, ok := t.budgets[token]
+	if !ok {
+		b = &budget{}
+		t.budgets[token] = b
+	}
+	b.remaining, b.reset = remaining, time.Unix(reset, 0)
+}
+
+// Wait implements api.Throttle. While the remaining budget of the token is
+// below the threshold, it blocks until the next request of the token is due.
+func (t *Transport) Wait(ctx context.Context, token string) error {
+	delay := t.delay(token, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay returns how long a request of the token which starts now must wait,
+// and reserves the slot after it for the next request.
+func (t *Transport) delay(token string, now time.Time) time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b, ok := t.budgets[token]
+	if !ok || b.remaining >= t.throttleBelow || !b.reset.After(now) {
+		return 0
+	}
+
+	start := b.next
+	if start.Before(now) {
+		start = now
+	}
+	if b.remaining <= 0 && start.Before(b.reset) {
+		start = b.reset
+	}
+	b.next = start.Add(b.reset.Sub(now) / time.Duration(b.remaining+1))
+	return start.Sub(now)
+}
+
+// Gatherer returns a Prometheus gatherer which yields the requests and their
+// latency, and the rate limit of the tokens of the accounts.
+func (t *Transport) Gatherer(namespace, subsystem string) (prometheus.Gatherer, error) {
+	var (
+		registry = prometheus.NewRegistry()
+		requests = prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Subsystem: subsystem, Name: "outbound_requests_total", Help: "Count of requests to the Fastly APIs, by endpoint and status code."}, []string{"host", "endpoint", "code"})
+		duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "outbound_request_duration_seconds", Help: "Time requests to the Fastly APIs took until the response headers arrived, by endpoint.", Buckets: prometheus.ExponentialBuckets(0.05, 2, 11)}, []string{"host", "endpoint"})
+		limits   = &rateLimitCollector{transport: t, labels: accountLabels(t.names)}
+	)
+	limits.remainingDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "outbound_rate_limit_remaining"), "Requests to the Fastly API remaining in the current rate limit window.", limits.labels, nil)
+	limits.resetDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "outbound_rate_limit_reset_timestamp_seconds"), "Unix timestamp when the current rate limit window of the Fastly API resets.", limits.labels, nil)
+	for _, c := range []prometheus.Collector{requests, duration, limits} {
+		if err := registry.Register(c); err != nil {
+			return nil, fmt.Errorf("registering outbound collector: %w", err)
+		}
+	}
+	t.mtx.Lock()
+	t.requests, t.duration = requests, duration
+	t.mtx.Unlock()
+	return registry, nil
+}
+
+// accountLabels returns the account label, if any account has a name.
+func accountLabels(names map[string]string) []string {
+	for _, name := range names {
+		if name != "" {
+			return []string{"account"}
+		}
+	}
+	return nil
+}
+
+// rateLimitCollector yields the rate limits of the tokens of the accounts.
+type rateLimitCollector struct {
+	transport     *Transport
+	labels        []string
+	remainingDesc *prometheus.Desc
+	resetDesc     *prometheus.Desc
+}
+
+func (c *rateLimitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.remainingDesc
+	ch <- c.resetDesc
+}
+
+func (c *rateLimitCollector) Collect(ch chan<- prometheus.Metric) {
+	c.transport.mtx.Lock()
+	defer c.transport.mtx.Unlock()
+
+	for token, name := range c.transport.names {
+		b, ok := c.transport.budgets[token]
+		if !ok {
+			continue
+		}
+		var values []string
+		if len(c.labels) > 0 {
+			values = []string{name}
+		}
+		ch <- prometheus.MustNewConstMetric(c.remainingDesc, prometheus.GaugeValue, float64(b.remaining), values...)
+		ch <- prometheus.MustNewConstMetric(c.resetDesc, prometheus.GaugeValue, float64(b.reset.Unix()), values...)
+	}
+}
+
+// endpointOf returns the path with its IDs, versions, and timestamps replaced
+// by a placeholder, e.g. "/service/:id/version/:id/dictionary", so that it can
+// be used as a label. Only short lowercase words, like "v1" or
+// "entitled-products", are kept. Fastly IDs are longer, and mixed case.
+func endpointOf(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if s != "" && !isWord(s) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isWord(s string) bool {
+	if len(s) < 2 || len(s) > 20 || s[0] < 'a' || s[0] > 'z' {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
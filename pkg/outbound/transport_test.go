@@ -0,0 +1,124 @@
+package outbound_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/outbound"
+)
+
+func TestTransportMetrics(t *testing.T) {
+	t.Parallel()
+
+	var (
+		reset  = time.Now().Add(time.Hour).Unix()
+		server = rateLimitedServer(t, 42, reset)
+		tr     = outbound.NewTransport(http.DefaultTransport, outbound.WithAccountNames(map[string]string{"token-a": "a", "token-b": "b"}))
+		client = &http.Client{Transport: tr}
+	)
+	g, err := tr.Gatherer("fastly", "rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get(t, client, server.URL+"/service/AbcDef123ghiJKlmnOPsq/version/3/dictionary", "token-a")
+	get(t, client, server.URL+"/v1/channel/AbcDef123ghiJKlmnOPsq/ts/h", "token-b")
+	get(t, client, server.URL+"/v1/channel/AbcDef123ghiJKlmnOPsq/ts/1700000000", "token-b")
+
+	host := mustParse(t, server.URL).Host
+	fastlytest.AssertMetric(t, g, `fastly_rt_outbound_requests_total{code="200",endpoint="/service/:id/version/:id/dictionary",host="`+host+`"}`, 1)
+	fastlytest.AssertMetric(t, g, `fastly_rt_outbound_requests_total{code="200",endpoint="/v1/channel/:id/ts/:id",host="`+host+`"}`, 2)
+	fastlytest.AssertMetric(t, g, `fastly_rt_outbound_request_duration_seconds_count{endpoint="/v1/channel/:id/ts/:id",host="`+host+`"}`, 2)
+	fastlytest.AssertMetric(t, g, `fastly_rt_outbound_rate_limit_remaining{account="a"}`, 42)
+	fastlytest.AssertMetric(t, g, `fastly_rt_outbound_rate_limit_reset_timestamp_seconds{account="b"}`, float64(reset))
+}
+
+func TestTransportThrottle(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		server = rateLimitedServer(t, 3, time.Now().Add(2*time.Second).Unix())
+		tr     = outbound.NewTransport(http.DefaultTransport)
+		client = &http.Client{Transport: tr}
+	)
+
+	if took := wait(t, tr, "token"); took > 50*time.Millisecond {
+		t.Errorf("before any response: want no wait, took %s", took)
+	}
+
+	get(t, client, server.URL+"/datacenters", "token")
+	if took := wait(t, tr, "token"); took > 50*time.Millisecond {
+		t.Errorf("first request: want no wait, took %s", took)
+	}
+	if took := wait(t, tr, "token"); took < 100*time.Millisecond {
+		t.Errorf("second request: want it spread over the window, took %s", took)
+	}
+	if took := wait(t, tr, "other token"); took > 50*time.Millisecond {
+		t.Errorf("other token: want no wait, took %s", took)
+	}
+
+	exhausted := rateLimitedServer(t, 0, time.Now().Add(time.Hour).Unix())
+	get(t, client, exhausted.URL+"/datacenters", "token")
+	canceled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := tr.Wait(canceled, "token"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("exhausted budget: want %v, have %v", context.DeadlineExceeded, err)
+	}
+
+	disabled := outbound.NewTransport(http.DefaultTransport, outbound.WithThrottleBelow(0))
+	get(t, &http.Client{Transport: disabled}, exhausted.URL+"/datacenters", "token")
+	if took := wait(t, disabled, "token"); took > 50*time.Millisecond {
+		t.Errorf("throttling disabled: want no wait, took %s", took)
+	}
+}
+
+func rateLimitedServer(t *testing.T, remaining int, reset int64) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Fastly-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("Fastly-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, client *http.Client, uri, token string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Fastly-Key", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func wait(t *testing.T, tr *outbound.Transport, token string) time.Duration {
+	t.Helper()
+	begin := time.Now()
+	if err := tr.Wait(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(begin)
+}
+
+func mustParse(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,164 @@
+package product
+
+import (
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/domain"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// aggregateDC is the datacenter label value used by the Process functions
+// when only aggregated data is requested.
+const aggregateDC = "aggregate"
+
+var (
+	// Realtime is the standard real-time stats product, available to all
+	// services. Its metrics are a *realtime.Metrics.
+	Realtime Product = realtimeProduct{}
+
+	// Origin is the origin inspector product. Its metrics are an
+	// *origin.Metrics.
+	Origin Product = originProduct{}
+
+	// Domain is the domain inspector product. Its metrics are a
+	// *domain.Metrics.
+	Domain Product = domainProduct{}
+)
+
+type realtimeProduct struct{}
+
+func (realtimeProduct) Name() string              { return api.ProductDefault }
+func (realtimeProduct) Path() string              { return "/v1/channel" }
+func (realtimeProduct) Label() string             { return "" }
+func (realtimeProduct) ErrorDelay() time.Duration { return 5 * time.Second }
+func (realtimeProduct) NewResponse() Response     { return &realtimeResponse{} }
+
+func (realtimeProduct) NewMetrics(namespace, rtSubsystem string, nameFilter filter.Filter, r prometheus.Registerer) interface{} {
+	return realtime.NewMetrics(namespace, rtSubsystem, nameFilter, r) // TODO(pb): change this to "rt" or "realtime"
+}
+
+type realtimeResponse realtime.Response
+
+func (r *realtimeResponse) Next() uint64    { return r.Timestamp }
+func (r *realtimeResponse) Message() string { return r.Error }
+
+func (r *realtimeResponse) Delay() time.Duration {
+	return time.Duration(r.AggregateDelay) * time.Second
+}
+
+func (r *realtimeResponse) Trim(seen func(recorded uint64) bool) (dropped int) {
+	r.Data, dropped = trim(r.Data, func(d realtime.Data) uint64 { return d.Recorded }, seen)
+	return dropped
+}
+
+func (r *realtimeResponse) Process(metrics interface{}, serviceID, serviceName, serviceVersion string, aggregateOnly bool, track func(datacenter, value string)) {
+	realtime.Process((*realtime.Response)(r), serviceID, serviceName, serviceVersion, metrics.(*realtime.Metrics), aggregateOnly)
+	for _, d := range r.Data {
+		if aggregateOnly {
+			track(aggregateDC, "")
+			continue
+		}
+		for datacenter := range d.Datacenter {
+			track(datacenter, "")
+		}
+	}
+}
+
+type originProduct struct{}
+
+func (originProduct) Name() string              { return api.ProductOriginInspector }
+func (originProduct) Path() string              { return "/v1/origins" }
+func (originProduct) Label() string             { return "origin" }
+func (originProduct) ErrorDelay() time.Duration { return 30 * time.Second }
+func (originProduct) NewResponse() Response     { return &originResponse{} }
+
+func (originProduct) NewMetrics(namespace, _ string, nameFilter filter.Filter, r prometheus.Registerer) interface{} {
+	return origin.NewMetrics(namespace, "origin", nameFilter, r)
+}
+
+type originResponse origin.Response
+
+func (r *originResponse) Next() uint64    { return r.Timestamp }
+func (r *originResponse) Message() string { return r.Error }
+
+func (r *originResponse) Delay() time.Duration {
+	return time.Duration(r.AggregateDelay) * time.Second
+}
+
+func (r *originResponse) Trim(seen func(recorded uint64) bool) (dropped int) {
+	r.Data, dropped = trim(r.Data, func(d origin.Data) uint64 { return d.Recorded }, seen)
+	return dropped
+}
+
+func (r *originResponse) Process(metrics interface{}, serviceID, serviceName, serviceVersion string, aggregateOnly bool, track func(datacenter, value string)) {
+	origin.Process((*origin.Response)(r), serviceID, serviceName, serviceVersion, metrics.(*origin.Metrics), aggregateOnly)
+	for _, d := range r.Data {
+		trackLabeled(d.Datacenter, d.Aggregated, aggregateOnly, track)
+	}
+}
+
+type domainProduct struct{}
+
+func (domainProduct) Name() string              { return api.ProductDomainInspector }
+func (domainProduct) Path() string              { return "/v1/domains" }
+func (domainProduct) Label() string             { return "domain" }
+func (domainProduct) ErrorDelay() time.Duration { return 5 * time.Second }
+func (domainProduct) NewResponse() Response     { return &domainResponse{} }
+
+func (domainProduct) NewMetrics(namespace, _ string, nameFilter filter.Filter, r prometheus.Registerer) interface{} {
+	return domain.NewMetrics(namespace, "domain", nameFilter, r)
+}
+
+type domainResponse domain.Response
+
+func (r *domainResponse) Next() uint64    { return r.Timestamp }
+func (r *domainResponse) Message() string { return r.Error }
+
+func (r *domainResponse) Delay() time.Duration {
+	return time.Duration(r.AggregateDelay) * time.Second
+}
+
+func (r *domainResponse) Trim(seen func(recorded uint64) bool) (dropped int) {
+	r.Data, dropped = trim(r.Data, func(d domain.Data) uint64 { return d.Recorded }, seen)
+	return dropped
+}
+
+func (r *domainResponse) Process(metrics interface{}, serviceID, serviceName, serviceVersion string, aggregateOnly bool, track func(datacenter, value string)) {
+	domain.Process((*domain.Response)(r), serviceID, serviceName, serviceVersion, metrics.(*domain.Metrics), aggregateOnly)
+	for _, d := range r.Data {
+		trackLabeled(d.Datacenter, d.Aggregated, aggregateOnly, track)
+	}
+}
+
+// trim drops the elements of data whose recorded timestamps were seen, and
+// returns the remaining ones, reusing data, and the number of dropped ones.
+// Elements without a recorded timestamp are kept.
+func trim[T any](data []T, recorded func(T) uint64, seen func(recorded uint64) bool) ([]T, int) {
+	kept := data[:0]
+	for _, d := range data {
+		if ts := recorded(d); ts == 0 || !seen(ts) {
+			kept = append(kept, d)
+		}
+	}
+	return kept, len(data) - len(kept)
+}
+
+// trackLabeled tracks the label values of a product with a label, e.g. the
+// origins, of every datacenter, or of the aggregate if aggregateOnly is set.
+func trackLabeled[D ~map[string]V, V ~map[string]S, S any](byDatacenter D, aggregated V, aggregateOnly bool, track func(datacenter, value string)) {
+	if aggregateOnly {
+		for value := range aggregated {
+			track(aggregateDC, value)
+		}
+		return
+	}
+	for datacenter, byValue := range byDatacenter {
+		for value := range byValue {
+			track(datacenter, value)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package product describes the products of the Fastly real-time stats API,
+// e.g. the origin inspector, so that the exporter can poll any of them the
+// same way. The real-time, origin inspector, and domain inspector products are
+// registered by default.
+package product
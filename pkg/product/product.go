@@ -0,0 +1,114 @@
+package product
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Product is a product of the real-time stats API. The exporter polls the
+// product's endpoint for each service whose account is entitled to it, and
+// translates the responses to the product's metrics.
+type Product interface {
+	// Name of the product in the Fastly entitlement API, which is also how
+	// service configs refer to it, e.g. "origin_inspector".
+	Name() string
+
+	// Path of the product's endpoint, relative to the base URL of the
+	// real-time stats API, e.g. "/v1/origins". The service ID and timestamp
+	// are appended to it.
+	Path() string
+
+	// Label is the name of the label which, together with the datacenter,
+	// identifies the series of a service, e.g. "origin". It's empty if the
+	// datacenter alone identifies them.
+	Label() string
+
+	// ErrorDelay is how long to wait before polling the endpoint again after
+	// a response with an unexpected status code.
+	ErrorDelay() time.Duration
+
+	// NewMetrics returns fresh metrics for the product, and registers those
+	// permitted by the name filter with r. The rtSubsystem is the subsystem of
+	// the default real-time metrics; other products usually have their own.
+	NewMetrics(namespace, rtSubsystem string, nameFilter filter.Filter, r prometheus.Registerer) interface{}
+
+	// NewResponse returns an empty response, which the JSON body of the
+	// product's endpoint is decoded into.
+	NewResponse() Response
+}
+
+// Response is a decoded response from the endpoint of a product.
+type Response interface {
+	// Next returns the timestamp to request next.
+	Next() uint64
+
+	// Message returns the error message in the response, if any.
+	Message() string
+
+	// Delay returns the aggregate delay reported in the response, i.e. how long
+	// the endpoint waits for datacenters to report a second before it
+	// aggregates them.
+	Delay() time.Duration
+
+	// Trim drops the data whose recorded timestamp seen returns true for, e.g.
+	// seconds which were already processed, and returns the number of data
+	// dropped. Data without a recorded timestamp is kept, and not passed to
+	// seen.
+	Trim(seen func(recorded uint64) bool) (dropped int)
+
+	// Process updates the metrics, as returned by NewMetrics of the same
+	// product, with the data in the response. It calls track with the
+	// datacenter and label value of every series it updates.
+	Process(metrics interface{}, serviceID, serviceName, serviceVersion string, aggregateOnly bool, track func(datacenter, value string))
+}
+
+var (
+	mtx      sync.Mutex
+	products = []Product{Realtime, Origin, Domain}
+)
+
+// Register adds a product to the set of products polled by the exporter. It
+// panics if a product with the same name is already registered. Register
+// products before constructing any metrics or managers, e.g. in init.
+func Register(p Product) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, existing := range products {
+		if existing.Name() == p.Name() {
+			panic(fmt.Sprintf("product %q already registered", p.Name()))
+		}
+	}
+	products = append(products, p)
+}
+
+// All returns every registered product, in the order of registration.
+func All() []Product {
+	mtx.Lock()
+	defer mtx.Unlock()
+	return append([]Product(nil), products...)
+}
+
+// Names returns the names of every registered product, in the order of
+// registration.
+func Names() []string {
+	all := All()
+	names := make([]string, len(all))
+	for i, p := range all {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// Lookup returns the registered product with the given name, if any.
+func Lookup(name string) (Product, bool) {
+	for _, p := range All() {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
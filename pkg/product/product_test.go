@@ -0,0 +1,215 @@
+package product_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func init() {
+	product.Register(testProduct{})
+}
+
+func TestRegister(t *testing.T) {
+	if want, have := []string{"default", "origin_inspector", "domain_inspector", "test_inspector"}, product.Names(); !cmp.Equal(want, have) {
+		t.Fatal(cmp.Diff(want, have))
+	}
+
+	if p, ok := product.Lookup("test_inspector"); !ok || p != (testProduct{}) {
+		t.Errorf("Lookup(test_inspector): want test product, have %v, %v", p, ok)
+	}
+	if _, ok := product.Lookup("unknown"); ok {
+		t.Errorf("Lookup(unknown): want not found")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("registering a duplicate product: want panic")
+			}
+		}()
+		product.Register(testProduct{})
+	}()
+
+	t.Run("Subscriber", func(t *testing.T) {
+		var (
+			registry = prometheus.NewRegistry()
+			metrics  = prom.NewMetrics("fastly", "rt", filter.Filter{}, registry)
+			server   = fastlytest.NewServer(t, fastlytest.Endpoint{
+				Method: "GET",
+				Path:   "/v1/test/{service}/ts/{ts}",
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"Timestamp":2,"Data":{"NYC":{"a":2,"b":1}}}`))
+				}),
+			})
+			once        sync.Once
+			processed   = make(chan struct{})
+			postprocess = func() { once.Do(func() { close(processed) }) }
+			subscriber  = rt.NewSubscriber(http.DefaultClient, "token", "AAA", metrics, rt.WithBaseURL(server.URL), rt.WithPostprocess(postprocess))
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go subscriber.Run(ctx, testProduct{})
+		<-processed
+
+		if _, ok := metrics.Product("test_inspector").(*testMetrics); !ok {
+			t.Fatalf("product metrics: want *testMetrics, have %T", metrics.Product("test_inspector"))
+		}
+		fastlytest.AssertMetric(t, registry, `fastly_test_widgets_total{datacenter="NYC",service_id="AAA",service_name="AAA",widget="a"}`, 2)
+		fastlytest.AssertMetric(t, registry, `fastly_test_widgets_total{datacenter="NYC",service_id="AAA",service_name="AAA",widget="b"}`, 1)
+
+		cancel()
+		metrics.ExpireSeries(-time.Hour) // everything
+		if want, have := 0, testutil.CollectAndCount(metrics.Product("test_inspector").(*testMetrics).WidgetsTotal); want != have {
+			t.Errorf("series after expiry: want %d, have %d", want, have)
+		}
+	})
+}
+
+func TestTrim(t *testing.T) {
+	for _, p := range []product.Product{product.Realtime, product.Origin, product.Domain} {
+		t.Run(p.Name(), func(t *testing.T) {
+			response := p.NewResponse()
+			if err := json.Unmarshal([]byte(`{"Timestamp":103,"Data":[{"recorded":101},{"recorded":102},{},{"recorded":103}]}`), response); err != nil {
+				t.Fatal(err)
+			}
+
+			seen := map[uint64]bool{101: true, 103: true}
+			if want, have := 2, response.Trim(func(recorded uint64) bool { return seen[recorded] }); want != have {
+				t.Errorf("dropped: want %d, have %d", want, have)
+			}
+
+			buf, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var remaining struct{ Data []json.RawMessage }
+			if err := json.Unmarshal(buf, &remaining); err != nil {
+				t.Fatal(err)
+			}
+			if want, have := 2, len(remaining.Data); want != have { // 102, and unrecorded
+				t.Errorf("remaining data: want %d, have %d", want, have)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	for _, testcase := range []struct {
+		product       product.Product
+		response      string
+		aggregateOnly bool
+		want          []string
+	}{
+		{
+			product:  product.Realtime,
+			response: `{"Data":[{"datacenter":{"AMS":{"requests":1},"NYC":{"requests":2}},"aggregated":{"requests":3},"recorded":100}]}`,
+			want:     []string{"AMS/", "NYC/"},
+		},
+		{
+			product:       product.Realtime,
+			response:      `{"Data":[{"datacenter":{"AMS":{"requests":1},"NYC":{"requests":2}},"aggregated":{"requests":3},"recorded":100}]}`,
+			aggregateOnly: true,
+			want:          []string{"aggregate/"},
+		},
+		{
+			product:  product.Origin,
+			response: `{"Data":[{"datacenter":{"AMS":{"o1":{"resp_body_bytes":1}},"NYC":{"o1":{},"o2":{}}},"aggregated":{"o1":{},"o2":{}},"recorded":100}]}`,
+			want:     []string{"AMS/o1", "NYC/o1", "NYC/o2"},
+		},
+		{
+			product:       product.Origin,
+			response:      `{"Data":[{"datacenter":{"AMS":{"o1":{"resp_body_bytes":1}},"NYC":{"o1":{},"o2":{}}},"aggregated":{"o1":{},"o2":{}},"recorded":100}]}`,
+			aggregateOnly: true,
+			want:          []string{"aggregate/o1", "aggregate/o2"},
+		},
+		{
+			product:  product.Domain,
+			response: `{"Data":[{"datacenter":{"AMS":{"a.com":{}},"NYC":{"b.com":{}}},"aggregated":{"a.com":{},"b.com":{}},"recorded":100}]}`,
+			want:     []string{"AMS/a.com", "NYC/b.com"},
+		},
+		{
+			product:       product.Domain,
+			response:      `{"Data":[{"datacenter":{"AMS":{"a.com":{}},"NYC":{"b.com":{}}},"aggregated":{"a.com":{},"b.com":{}},"recorded":100}]}`,
+			aggregateOnly: true,
+			want:          []string{"aggregate/a.com", "aggregate/b.com"},
+		},
+	} {
+		t.Run(fmt.Sprintf("%s aggregateOnly=%v", testcase.product.Name(), testcase.aggregateOnly), func(t *testing.T) {
+			response := testcase.product.NewResponse()
+			if err := json.Unmarshal([]byte(testcase.response), response); err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				metrics = testcase.product.NewMetrics("fastly", "rt", filter.Filter{}, prometheus.NewRegistry())
+				have    []string
+			)
+			response.Process(metrics, "AAA", "Service One", "1", testcase.aggregateOnly, func(datacenter, value string) {
+				have = append(have, datacenter+"/"+value)
+			})
+			sort.Strings(have)
+			if !cmp.Equal(testcase.want, have) {
+				t.Error(cmp.Diff(testcase.want, have))
+			}
+		})
+	}
+}
+
+type testProduct struct{}
+
+func (testProduct) Name() string                  { return "test_inspector" }
+func (testProduct) Path() string                  { return "/v1/test" }
+func (testProduct) Label() string                 { return "widget" }
+func (testProduct) ErrorDelay() time.Duration     { return time.Second }
+func (testProduct) NewResponse() product.Response { return &testResponse{} }
+
+func (testProduct) NewMetrics(namespace, _ string, nameFilter filter.Filter, r prometheus.Registerer) interface{} {
+	m := &testMetrics{
+		WidgetsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Subsystem: "test", Name: "widgets_total", Help: "Widgets."}, []string{"service_id", "service_name", "datacenter", "widget"}),
+	}
+	if nameFilter.Permit(namespace + "_test_widgets_total") {
+		r.MustRegister(m.WidgetsTotal)
+	}
+	return m
+}
+
+type testMetrics struct {
+	WidgetsTotal *prometheus.CounterVec
+}
+
+type testResponse struct {
+	Timestamp uint64                       `json:"Timestamp"`
+	Data      map[string]map[string]uint64 `json:"Data"`
+	Error     string                       `json:"Error"`
+}
+
+func (r *testResponse) Next() uint64               { return r.Timestamp }
+func (r *testResponse) Message() string            { return r.Error }
+func (r *testResponse) Delay() time.Duration       { return 0 }
+func (r *testResponse) Trim(func(uint64) bool) int { return 0 }
+
+func (r *testResponse) Process(metrics interface{}, serviceID, serviceName, _ string, _ bool, track func(datacenter, value string)) {
+	m := metrics.(*testMetrics)
+	for datacenter, widgets := range r.Data {
+		for widget, n := range widgets {
+			m.WidgetsTotal.WithLabelValues(serviceID, serviceName, datacenter, widget).Add(float64(n))
+			track(datacenter, widget)
+		}
+	}
+}
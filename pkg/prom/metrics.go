@@ -1,11 +1,17 @@
 package prom
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/fastly/fastly-exporter/pkg/domain"
 	"github.com/fastly/fastly-exporter/pkg/filter"
 	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/product"
 	"github.com/fastly/fastly-exporter/pkg/realtime"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,12 +19,22 @@ import (
 
 // Metrics is the top-level collection of Prometheus metrics provided by the
 // exporter. Not all metrics may be updated, based on e.g. filter rules.
+//
+// Every registered product has its own metrics. Those of the built-in products
+// are also available as fields.
 type Metrics struct {
 	ServiceInfo            *prometheus.GaugeVec
 	LastSuccessfulResponse *prometheus.GaugeVec
+	CircuitState           *prometheus.GaugeVec
+	DuplicateSamplesTotal  *prometheus.CounterVec
 	Realtime               *realtime.Metrics
 	Origin                 *origin.Metrics
 	Domain                 *domain.Metrics
+
+	products map[string]interface{}
+	series   *seriesTracker
+	restored *restoredHistograms
+	deleters metricsDeleters
 }
 
 // NewMetrics returns a fresh Metrics with the provided parameters.
@@ -26,6 +42,8 @@ func NewMetrics(namespace, rtSubsystemWillBeDeprecated string, nameFilter filter
 	var (
 		serviceInfo            = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Subsystem: rtSubsystemWillBeDeprecated, Name: "service_info", Help: "Static gauge with service ID, name, and version information."}, []string{"service_id", "service_name", "service_version"})
 		lastSuccessfulResponse = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Subsystem: rtSubsystemWillBeDeprecated, Name: "last_successful_response", Help: "Unix timestamp of the last successful response received from the real-time stats API."}, []string{"service_id", "service_name"})
+		circuitState           = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Subsystem: rtSubsystemWillBeDeprecated, Name: "circuit_state", Help: "State of the circuit breaker of the real-time stats API endpoint of the product: 0 closed, 1 open, 2 half-open."}, []string{"service_id", "service_name", "product"})
+		duplicateSamplesTotal  = prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Subsystem: rtSubsystemWillBeDeprecated, Name: "duplicate_samples_total", Help: "Number of seconds of real-time stats which were served again after they were processed, other than by backfill, and skipped."}, []string{"service_id", "service_name", "product"})
 	)
 
 	if name := getName(serviceInfo); !nameFilter.Blocked(name) {
@@ -34,16 +52,86 @@ func NewMetrics(namespace, rtSubsystemWillBeDeprecated string, nameFilter filter
 	if name := getName(lastSuccessfulResponse); !nameFilter.Blocked(name) {
 		r.MustRegister(lastSuccessfulResponse)
 	}
+	if name := getName(circuitState); !nameFilter.Blocked(name) {
+		r.MustRegister(circuitState)
+	}
+	if name := getName(duplicateSamplesTotal); !nameFilter.Blocked(name) {
+		r.MustRegister(duplicateSamplesTotal)
+	}
+
+	products := map[string]interface{}{}
+	for _, p := range product.All() {
+		products[p.Name()] = p.NewMetrics(namespace, rtSubsystemWillBeDeprecated, nameFilter, r)
+	}
 
-	return &Metrics{
+	m := &Metrics{
 		ServiceInfo:            serviceInfo,
 		LastSuccessfulResponse: lastSuccessfulResponse,
-		Realtime:               realtime.NewMetrics(namespace, rtSubsystemWillBeDeprecated, nameFilter, r), // TODO(pb): change this to "rt" or "realtime"
-		Origin:                 origin.NewMetrics(namespace, "origin", nameFilter, r),
-		Domain:                 domain.NewMetrics(namespace, "domain", nameFilter, r),
+		CircuitState:           circuitState,
+		DuplicateSamplesTotal:  duplicateSamplesTotal,
+		Realtime:               products[product.Realtime.Name()].(*realtime.Metrics),
+		Origin:                 products[product.Origin.Name()].(*origin.Metrics),
+		Domain:                 products[product.Domain.Name()].(*domain.Metrics),
+		products:               products,
+		series:                 newSeriesTracker(),
+		restored:               newRestoredHistograms(),
+	}
+	m.deleters = newMetricsDeleters(m)
+
+	return m
+}
+
+// Product returns the metrics of the product with the given name, as returned
+// by its NewMetrics method, or nil if it wasn't registered when the metrics
+// were constructed.
+func (m *Metrics) Product(name string) interface{} {
+	return m.products[name]
+}
+
+// applyNameFilter makes the set of metrics registered to the registry reflect
+// the name filter, as if the metrics had been constructed with it. Metrics keep
+// their values when they're unregistered, and are exported again with those
+// values if a later filter permits them.
+func (m *Metrics) applyNameFilter(nameFilter filter.Filter, r prometheus.Registerer) {
+	apply := func(c prometheus.Collector, permit bool) {
+		if !permit {
+			r.Unregister(c)
+			return
+		}
+		if err := r.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				panic(fmt.Errorf("error registering metric %s: %w", getName(c), err))
+			}
+		}
+	}
+
+	for _, c := range []prometheus.Collector{m.ServiceInfo, m.LastSuccessfulResponse, m.CircuitState, m.DuplicateSamplesTotal} {
+		apply(c, !nameFilter.Blocked(getName(c)))
+	}
+
+	for _, v := range m.products {
+		for _, c := range collectorsOf(v) {
+			apply(c, nameFilter.Permit(getName(c)))
+		}
 	}
 }
 
+// collectorsOf returns every Prometheus collector in the fields of the struct
+// pointed to by v, e.g. a *realtime.Metrics.
+func collectorsOf(v interface{}) []prometheus.Collector {
+	var (
+		rv         = reflect.ValueOf(v).Elem()
+		collectors = make([]prometheus.Collector, 0, rv.NumField())
+	)
+	for i := 0; i < rv.NumField(); i++ {
+		if c, ok := rv.Field(i).Interface().(prometheus.Collector); ok {
+			collectors = append(collectors, c)
+		}
+	}
+	return collectors
+}
+
 var descNameRegex = regexp.MustCompile("fqName: \"([^\"]+)\"")
 
 func getName(c prometheus.Collector) string {
@@ -56,3 +144,42 @@ func getName(c prometheus.Collector) string {
 	}
 	return ""
 }
+
+var descVariableLabelsRegex = regexp.MustCompile(`variableLabels: \{([^}]*)\}\}$`)
+
+func getVariableLabels(c prometheus.Collector) []string {
+	d := make(chan *prometheus.Desc, 1)
+	c.Describe(d)
+	desc := (<-d).String()
+	matches := descVariableLabelsRegex.FindStringSubmatch(desc)
+	if len(matches) != 2 || matches[1] == "" {
+		return nil
+	}
+	return strings.Split(matches[1], ",")
+}
+
+// LabelNames returns the names of every label of the per-service metrics,
+// including the account label, sorted. Additional labels of services, e.g. via
+// WithServiceLabels, must not use these names.
+func LabelNames() []string {
+	var (
+		m          = NewMetrics("", "", filter.Filter{}, prometheus.NewRegistry())
+		collectors = []prometheus.Collector{m.ServiceInfo, m.LastSuccessfulResponse, m.CircuitState, m.DuplicateSamplesTotal}
+		names      = map[string]bool{accountLabel: true}
+	)
+	for _, v := range m.products {
+		collectors = append(collectors, collectorsOf(v)...)
+	}
+	for _, c := range collectors {
+		for _, name := range getVariableLabels(c) {
+			names[name] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
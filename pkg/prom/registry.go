@@ -9,11 +9,13 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fastly/fastly-exporter/pkg/filter"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 // Registry collects Prometheus metrics on a per-service basis.
@@ -23,6 +25,16 @@ import (
 // Prometheus) can scrape metrics for all services via the `/metrics` endpoint,
 // or a single service via `/metrics?target=<service ID>`.
 //
+// Services of different Fastly accounts can share a registry, via Account.
+// Their metrics carry an additional account label, and they can be scraped and
+// discovered per account via `/metrics?account=<name>` and `/sd?account=<name>`.
+//
+// An Authorizer can restrict the services visible to the caller of each request.
+// Only authorized services are exported, discovered, and listed on the index
+// page, and scrapes of an unauthorized target fail with 403 Forbidden. Metrics
+// of the default gatherers are restricted likewise, by their account and
+// service_id labels, see authorizedGatherer.
+//
 // https://prometheus.io/docs/prometheus/latest/configuration/configuration/#http_sd_config
 type Registry struct {
 	mtx                   sync.Mutex
@@ -30,8 +42,9 @@ type Registry struct {
 	namespace             string
 	rtSubsystemDeprecated string
 	metricNameFilter      filter.Filter
-	byServiceID           map[string]*metricsRegistry
+	byService             map[serviceKey]*metricsRegistry
 	defaultGatherers      []prometheus.Gatherer
+	authorizer            Authorizer
 
 	http.Handler
 }
@@ -49,7 +62,7 @@ func NewRegistry(version, namespace, rtSubsystemDeprecated string, metricNameFil
 		namespace:             namespace,
 		rtSubsystemDeprecated: rtSubsystemDeprecated,
 		metricNameFilter:      metricNameFilter,
-		byServiceID:           map[string]*metricsRegistry{},
+		byService:             map[serviceKey]*metricsRegistry{},
 		defaultGatherers:      defaultGatherers,
 	}
 
@@ -63,33 +76,200 @@ func NewRegistry(version, namespace, rtSubsystemDeprecated string, metricNameFil
 	return r
 }
 
+// Authorizer is a consumer contract for the registry. It decides whether the
+// caller of the request may see the service with the given ID in the given
+// account, which is empty for services not associated with a named account.
+type Authorizer interface {
+	Authorized(req *http.Request, account, serviceID string) bool
+}
+
+// SetAuthorizer restricts the services visible to the caller of each request.
+// By default, every caller can see every service.
+func (r *Registry) SetAuthorizer(authorizer Authorizer) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.authorizer = authorizer
+}
+
+// authorizedFor returns a function which decides whether the caller of the
+// request may see a service.
+func (r *Registry) authorizedFor(req *http.Request) func(serviceKey) bool {
+	r.mtx.Lock()
+	authorizer := r.authorizer
+	r.mtx.Unlock()
+
+	if authorizer == nil {
+		return func(serviceKey) bool { return true }
+	}
+	return func(key serviceKey) bool {
+		return authorizer.Authorized(req, key.account, key.serviceID)
+	}
+}
+
 // metricsRegistry combines a set of metrics for a single Fastly service with a
 // Prometheus registry that yields those metrics. The registry can be combined
 // with other registries and served as a single set of metrics via the
-// prometheus.Gatherers helper type.
+// prometheus.Gatherers helper type. The metrics are registered via the
+// registerer, which adds the account label, if any.
 type metricsRegistry struct {
-	metrics  *Metrics
-	registry *prometheus.Registry
+	metrics    *Metrics
+	registry   *prometheus.Registry
+	registerer prometheus.Registerer
+}
+
+// Gather implements prometheus.Gatherer. It adds the restored histograms of
+// the metrics to the gathered ones.
+func (mr *metricsRegistry) Gather() ([]*dto.MetricFamily, error) {
+	families, err := mr.registry.Gather()
+	mr.metrics.restored.addTo(families)
+	return families, err
+}
+
+// serviceKey identifies a service in the registry. The account is empty for
+// services which aren't associated with a named account.
+type serviceKey struct {
+	account   string
+	serviceID string
 }
 
+// accountLabel is the name of the label added to the metrics of services which
+// are associated with a named account.
+const accountLabel = "account"
+
 // MetricsFor returns a set of Prometheus metrics for a specific service, with
 // the expectation that callers will update those metrics with data retrieved
 // from the Fastly real-time stats API.
 func (r *Registry) MetricsFor(serviceID string) *Metrics {
+	return r.metricsFor(serviceKey{serviceID: serviceID}, nil)
+}
+
+// Remove drops the set of Prometheus metrics for a specific service, so that
+// it's no longer exported via `/metrics`, nor advertised via `/sd` or the index
+// page. Callers should make sure nothing is still updating those metrics. A
+// subsequent call to MetricsFor with the same service ID yields a fresh set of
+// metrics.
+func (r *Registry) Remove(serviceID string) {
+	r.remove(serviceKey{serviceID: serviceID})
+}
+
+// Account returns a view of the registry for the services of a single Fastly
+// account. Every metric yielded by the view carries an account label with the
+// given name. An empty name yields a view equivalent to the registry itself.
+func (r *Registry) Account(name string, options ...AccountOption) *Account {
+	a := &Account{registry: r, name: name}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
+// Account is a view of a Registry for the services of a single Fastly account.
+type Account struct {
+	registry      *Registry
+	name          string
+	serviceLabels func(serviceID string) prometheus.Labels
+}
+
+// AccountOption provides some additional behavior to an account view.
+type AccountOption func(*Account)
+
+// WithServiceLabels sets a function which returns additional labels for the
+// metrics of a specific service. It's called when the metrics for the service
+// are created, so changes are only picked up once the service is removed and
+// created again. The labels must not collide with those of any metric. By
+// default, no additional labels are added.
+func WithServiceLabels(f func(serviceID string) prometheus.Labels) AccountOption {
+	return func(a *Account) { a.serviceLabels = f }
+}
+
+// MetricsFor returns a set of Prometheus metrics for a specific service of the
+// account. See Registry.MetricsFor.
+func (a *Account) MetricsFor(serviceID string) *Metrics {
+	var labels prometheus.Labels
+	if a.serviceLabels != nil {
+		labels = a.serviceLabels(serviceID)
+	}
+	return a.registry.metricsFor(serviceKey{account: a.name, serviceID: serviceID}, labels)
+}
+
+// Remove drops the set of Prometheus metrics for a specific service of the
+// account. See Registry.Remove.
+func (a *Account) Remove(serviceID string) {
+	a.registry.remove(serviceKey{account: a.name, serviceID: serviceID})
+}
+
+// metricsFor returns the metrics for the service, creating them if necessary.
+// The labels are only used when creating the metrics.
+func (r *Registry) metricsFor(key serviceKey, labels prometheus.Labels) *Metrics {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	mr, ok := r.byServiceID[serviceID]
+	mr, ok := r.byService[key]
 	if !ok {
-		registry := prometheus.NewRegistry()
-		metrics := NewMetrics(r.namespace, r.rtSubsystemDeprecated, r.metricNameFilter, registry)
-		mr = &metricsRegistry{metrics, registry}
-		r.byServiceID[serviceID] = mr // TODO(pb): at some point, expire and remove?
+		constLabels := make(prometheus.Labels, len(labels)+1)
+		for name, value := range labels {
+			constLabels[name] = value
+		}
+		if key.account != "" {
+			constLabels[accountLabel] = key.account
+		}
+
+		var (
+			registry   = prometheus.NewRegistry()
+			registerer = prometheus.Registerer(registry)
+		)
+		if len(constLabels) > 0 {
+			registerer = prometheus.WrapRegistererWith(constLabels, registry)
+		}
+		metrics := NewMetrics(r.namespace, r.rtSubsystemDeprecated, r.metricNameFilter, registerer)
+		mr = &metricsRegistry{metrics, registry, registerer}
+		r.byService[key] = mr
 	}
 
 	return mr.metrics
 }
 
+func (r *Registry) remove(key serviceKey) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.byService, key)
+}
+
+// SetMetricNameFilter replaces the filter which restricts the metrics made
+// available for scrapes. It applies to the metrics of services already in the
+// registry, as well as those created afterwards. Default gatherers are not
+// affected.
+func (r *Registry) SetMetricNameFilter(metricNameFilter filter.Filter) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.metricNameFilter = metricNameFilter
+	for _, mr := range r.byService {
+		mr.metrics.applyNameFilter(metricNameFilter, mr.registerer)
+	}
+}
+
+// ExpireSeries deletes the series of every service whose label combinations
+// haven't been updated within the TTL, e.g. datacenters, origins, and domains
+// which stopped reporting, or previous service versions. It returns the total
+// number of expired label combinations.
+func (r *Registry) ExpireSeries(ttl time.Duration) int {
+	r.mtx.Lock()
+	metrics := make([]*Metrics, 0, len(r.byService))
+	for _, mr := range r.byService {
+		metrics = append(metrics, mr.metrics)
+	}
+	r.mtx.Unlock()
+
+	var expired int
+	for _, m := range metrics {
+		expired += m.ExpireSeries(ttl)
+	}
+	return expired
+}
+
 func (r *Registry) handleIndex(w http.ResponseWriter, req *http.Request) {
 	type link struct {
 		Path string `json:"path"`
@@ -101,10 +281,26 @@ func (r *Registry) handleIndex(w http.ResponseWriter, req *http.Request) {
 		{"/metrics", "Metrics for all services"},
 	}
 
-	for _, serviceID := range r.serviceIDs() {
-		query := url.Values{"target": []string{serviceID}}.Encode()
+	var (
+		authorized  = r.authorizedFor(req)
+		prevAccount string
+	)
+	for _, key := range r.serviceKeys() {
+		if !authorized(key) {
+			continue
+		}
+		if key.account != "" && key.account != prevAccount {
+			query := url.Values{"account": []string{key.account}}.Encode()
+			links = append(links, link{"/metrics?" + query, "Metrics for account " + key.account})
+			prevAccount = key.account
+		}
+
+		query := url.Values{"target": []string{key.serviceID}}.Encode()
 		path := "/metrics?" + query
-		name := "Metrics for service " + serviceID
+		name := "Metrics for service " + key.serviceID
+		if key.account != "" {
+			name += " in account " + key.account
+		}
 		links = append(links, link{path, name})
 	}
 
@@ -134,13 +330,41 @@ func (r *Registry) handleIndex(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (r *Registry) handleServiceDiscovery(w http.ResponseWriter, req *http.Request) {
-	targets := r.serviceIDs()
+// targetGroup is an element of the HTTP service discovery response. Services
+// of named accounts are grouped by account, and carry a meta label with the
+// account name, which can be used for relabeling.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+const accountMetaLabel = "__meta_fastly_account"
 
-	response := []struct {
-		Targets []string `json:"targets"`
-	}{
-		{Targets: targets},
+func (r *Registry) handleServiceDiscovery(w http.ResponseWriter, req *http.Request) {
+	var (
+		account    = req.URL.Query().Get("account") // empty account string means all accounts
+		authorized = r.authorizedFor(req)
+		response   []targetGroup
+	)
+	for _, key := range r.serviceKeys() {
+		if account != "" && key.account != account {
+			continue
+		}
+		if !authorized(key) {
+			continue
+		}
+		if n := len(response); n == 0 || response[n-1].Labels[accountMetaLabel] != key.account {
+			tg := targetGroup{Targets: []string{}}
+			if key.account != "" {
+				tg.Labels = map[string]string{accountMetaLabel: key.account}
+			}
+			response = append(response, tg)
+		}
+		tg := &response[len(response)-1]
+		tg.Targets = append(tg.Targets, key.serviceID)
+	}
+	if len(response) == 0 {
+		response = []targetGroup{{Targets: []string{}}}
 	}
 
 	buf, err := json.MarshalIndent(response, "", "    ")
@@ -153,51 +377,133 @@ func (r *Registry) handleServiceDiscovery(w http.ResponseWriter, req *http.Reque
 	w.Write(buf)
 }
 
+// Gather implements prometheus.Gatherer. It gathers the metrics of every
+// service in every account, along with the default gatherers, like a scrape of
+// `/metrics` without an Authorizer.
+func (r *Registry) Gather() ([]*dto.MetricFamily, error) {
+	all := func(serviceKey) bool { return true }
+	return prometheus.Gatherers(append(r.defaultGatherers, r.servicesGathererFor("", "", all))).Gather()
+}
+
 func (r *Registry) handleMetrics(w http.ResponseWriter, req *http.Request) {
 	var (
-		target    = req.URL.Query().Get("target") // empty target string means all targets
-		gatherers = prometheus.Gatherers(append(r.defaultGatherers, r.servicesGathererFor(target)))
+		target     = req.URL.Query().Get("target")  // empty target string means all targets
+		account    = req.URL.Query().Get("account") // empty account string means all accounts
+		authorized = r.authorizedFor(req)
+	)
+	if target != "" && !r.targetAuthorized(account, target, authorized) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var (
+		defaults  = authorizedGatherer{gatherer: prometheus.Gatherers(r.defaultGatherers), authorized: authorized}
+		gatherers = prometheus.Gatherers{defaults, r.servicesGathererFor(account, target, authorized)}
 		handler   = promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
 	)
 	handler.ServeHTTP(w, req)
 }
 
-func (r *Registry) serviceIDs() []string {
+// targetAuthorized returns true if the caller may see the target service in
+// at least one account, or in the given account if it's not empty. Targets
+// which aren't in the registry are checked directly, so that the response
+// doesn't reveal whether a forbidden service exists.
+func (r *Registry) targetAuthorized(account, target string, authorized func(serviceKey) bool) bool {
+	var found bool
+	for _, key := range r.serviceKeys() {
+		if key.serviceID != target || (account != "" && key.account != account) {
+			continue
+		}
+		if authorized(key) {
+			return true
+		}
+		found = true
+	}
+	return !found && authorized(serviceKey{account: account, serviceID: target})
+}
+
+// serviceKeys returns the keys of all services in the registry, sorted by
+// account and then service ID.
+func (r *Registry) serviceKeys() []serviceKey {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	serviceIDs := make([]string, 0, len(r.byServiceID))
-	for serviceID := range r.byServiceID {
-		serviceIDs = append(serviceIDs, serviceID)
+	keys := make([]serviceKey, 0, len(r.byService))
+	for key := range r.byService {
+		keys = append(keys, key)
 	}
 
-	sort.Strings(serviceIDs)
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+		return keys[i].serviceID < keys[j].serviceID
+	})
 
-	return serviceIDs
+	return keys
 }
 
-func (r *Registry) servicesGathererFor(target string) prometheus.Gatherer {
-	var allow func(candidate string) bool
-	switch target {
-	case "":
-		allow = func(candidate string) bool { return true }
-	default:
-		allow = func(candidate string) bool { return candidate == target }
+func (r *Registry) servicesGathererFor(account, target string, authorized func(serviceKey) bool) prometheus.Gatherer {
+	allow := func(candidate serviceKey) bool {
+		return (account == "" || candidate.account == account) && (target == "" || candidate.serviceID == target) && authorized(candidate)
 	}
 
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
 	var gatherers prometheus.Gatherers
-	for serviceID, mr := range r.byServiceID {
-		if allow(serviceID) {
-			gatherers = append(gatherers, mr.registry)
+	for key, mr := range r.byService {
+		if allow(key) {
+			gatherers = append(gatherers, mr)
 		}
 	}
 
 	return gatherers
 }
 
+// authorizedGatherer yields the metrics of the gatherer which the caller may
+// see. Metrics belong to the service and account in their service_id and
+// account labels, if any. Metrics without a service_id, e.g. of the token or
+// the caches of an account, belong to the whole account, and are only visible
+// to callers which may see every service of the account. Metrics without
+// either label belong to the exporter itself, i.e. the unnamed account.
+type authorizedGatherer struct {
+	gatherer   prometheus.Gatherer
+	authorized func(serviceKey) bool
+}
+
+// serviceIDLabel is the label which identifies the service of a metric.
+const serviceIDLabel = "service_id"
+
+// Gather implements prometheus.Gatherer.
+func (g authorizedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+
+	filtered := mfs[:0]
+	for _, mf := range mfs {
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			var key serviceKey
+			for _, lp := range m.GetLabel() {
+				switch lp.GetName() {
+				case accountLabel:
+					key.account = lp.GetValue()
+				case serviceIDLabel:
+					key.serviceID = lp.GetValue()
+				}
+			}
+			if g.authorized(key) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			filtered = append(filtered, mf)
+		}
+	}
+	return filtered, err
+}
+
 var indexTemplate = template.Must(template.New("").Parse(`
 <html>
 <head>
@@ -6,9 +6,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
 	"github.com/fastly/fastly-exporter/pkg/filter"
 	"github.com/fastly/fastly-exporter/pkg/prom"
 	"github.com/prometheus/client_golang/prometheus"
@@ -179,9 +181,346 @@ func TestRegistryEndpoints(t *testing.T) {
 	})
 }
 
+func TestRegistryRemove(t *testing.T) {
+	t.Parallel()
+
+	registry := prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+
+	registry.MetricsFor("AAA").Realtime.RequestsTotal.With(prometheus.Labels{
+		"service_id": "AAA", "service_name": "Service One", "datacenter": "NYC",
+	}).Add(1)
+
+	registry.MetricsFor("BBB").Realtime.RequestsTotal.With(prometheus.Labels{
+		"service_id": "BBB", "service_name": "Service Two", "datacenter": "NYC",
+	}).Add(2)
+
+	registry.Remove("AAA")
+
+	get := func(path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.String()
+	}
+
+	for _, path := range []string{"/", "/sd", "/metrics"} {
+		body := get(path)
+		if strings.Contains(body, "AAA") {
+			t.Errorf("%s: removed service AAA still present", path)
+		}
+		if !strings.Contains(body, "BBB") {
+			t.Errorf("%s: service BBB missing", path)
+		}
+	}
+
+	registry.MetricsFor("AAA") // fresh metrics, without the old counter values
+	if body := get("/metrics?target=AAA"); strings.Contains(body, `service_id="AAA"`) {
+		t.Errorf("recreated service AAA has stale metrics: %s", body)
+	}
+}
+
+func TestRegistryGather(t *testing.T) {
+	t.Parallel()
+
+	var (
+		extra    = prometheus.NewRegistry()
+		registry = prom.NewRegistry("dev", "fastly", "rt", filter.Filter{}, extra)
+	)
+	extra.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "extra", Help: "Extra."}))
+
+	registry.MetricsFor("AAA").Realtime.RequestsTotal.With(prometheus.Labels{
+		"service_id": "AAA", "service_name": "Service One", "datacenter": "NYC",
+	}).Add(1)
+	registry.Account("other").MetricsFor("BBB").Realtime.RequestsTotal.With(prometheus.Labels{
+		"service_id": "BBB", "service_name": "Service Two", "datacenter": "NYC",
+	}).Add(2)
+
+	fastlytest.AssertMetrics(t, registry, "fastly_rt_requests_total", map[string]float64{
+		`fastly_rt_requests_total{datacenter="NYC",service_id="AAA",service_name="Service One"}`:                 1,
+		`fastly_rt_requests_total{account="other",datacenter="NYC",service_id="BBB",service_name="Service Two"}`: 2,
+	})
+	fastlytest.AssertMetric(t, registry, "extra", 0)
+}
+
+func TestRegistrySetMetricNameFilter(t *testing.T) {
+	t.Parallel()
+
+	registry := prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+	registry.MetricsFor("AAA").Realtime.RequestsTotal.With(prometheus.Labels{
+		"service_id": "AAA", "service_name": "Service One", "datacenter": "NYC",
+	}).Add(1)
+
+	const series = `fastly_rt_requests_total{datacenter="NYC",service_id="AAA",service_name="Service One"} 1`
+
+	metrics := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	var blocked filter.Filter
+	blocked.Block("requests_total")
+	registry.SetMetricNameFilter(blocked)
+	if body := metrics(); strings.Contains(body, series) {
+		t.Errorf("blocked metric still exported")
+	}
+
+	registry.MetricsFor("BBB") // created with the new filter
+	registry.SetMetricNameFilter(filter.Filter{})
+	if body := metrics(); !strings.Contains(body, series) {
+		t.Errorf("unblocked metric missing, or lost its value")
+	}
+}
+
+func TestRegistryAccounts(t *testing.T) {
+	t.Parallel()
+
+	registry := prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+
+	for _, tc := range []struct{ account, serviceID, serviceName string }{
+		{"", "AAA", "Service One"},
+		{"prod", "BBB", "Service Two"},
+		{"prod", "CCC", "Service Three"},
+		{"staging", "DDD", "Service Four"},
+	} {
+		metrics := registry.Account(tc.account).MetricsFor(tc.serviceID)
+		metrics.ServiceInfo.WithLabelValues(tc.serviceID, tc.serviceName, "1").Set(1)
+		metrics.Realtime.RequestsTotal.With(prometheus.Labels{
+			"service_id": tc.serviceID, "service_name": tc.serviceName, "datacenter": "NYC",
+		}).Add(1)
+	}
+
+	get := func(path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.String()
+	}
+
+	t.Run("metrics", func(t *testing.T) {
+		body := get("/metrics")
+		for _, want := range []string{
+			`fastly_rt_service_info{service_id="AAA",service_name="Service One",service_version="1"} 1`,
+			`fastly_rt_service_info{account="prod",service_id="BBB",service_name="Service Two",service_version="1"} 1`,
+			`fastly_rt_requests_total{account="staging",datacenter="NYC",service_id="DDD",service_name="Service Four"} 1`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("missing: %s", want)
+			}
+		}
+	})
+
+	t.Run("metrics?account=prod", func(t *testing.T) {
+		body := get("/metrics?account=prod")
+		for serviceID, want := range map[string]bool{"AAA": false, "BBB": true, "CCC": true, "DDD": false} {
+			if have := strings.Contains(body, `service_id="`+serviceID+`"`); want != have {
+				t.Errorf("%s: want %v, have %v", serviceID, want, have)
+			}
+		}
+	})
+
+	type targetGroup struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+
+	sd := func(path string) (groups []targetGroup) {
+		t.Helper()
+		if err := json.Unmarshal([]byte(get(path)), &groups); err != nil {
+			t.Fatal(err)
+		}
+		return groups
+	}
+
+	t.Run("sd", func(t *testing.T) {
+		groups := sd("/sd")
+		if want, have := 3, len(groups); want != have {
+			t.Fatalf("target groups: want %d, have %d (%v)", want, have, groups)
+		}
+		if want, have := "[AAA]", fmt.Sprint(groups[0].Targets); want != have {
+			t.Errorf("targets: want %s, have %s", want, have)
+		}
+		if want, have := 0, len(groups[0].Labels); want != have {
+			t.Errorf("labels: want %d, have %d", want, have)
+		}
+		if want, have := "[BBB CCC]", fmt.Sprint(groups[1].Targets); want != have {
+			t.Errorf("targets: want %s, have %s", want, have)
+		}
+		if want, have := "prod", groups[1].Labels["__meta_fastly_account"]; want != have {
+			t.Errorf("account: want %q, have %q", want, have)
+		}
+	})
+
+	t.Run("sd?account=staging", func(t *testing.T) {
+		groups := sd("/sd?account=staging")
+		if want, have := "[{[DDD] map[__meta_fastly_account:staging]}]", fmt.Sprint(groups); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	})
+
+	t.Run("sd?account=unknown", func(t *testing.T) {
+		groups := sd("/sd?account=unknown")
+		if want, have := "[{[] map[]}]", fmt.Sprint(groups); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	})
+
+	registry.Account("prod").Remove("BBB")
+	if body := get("/metrics"); strings.Contains(body, `service_id="BBB"`) {
+		t.Errorf("removed service BBB still present")
+	}
+}
+
+func TestRegistryServiceLabels(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registry = prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+		labels   = func(serviceID string) prometheus.Labels {
+			if serviceID == "AAA" {
+				return prometheus.Labels{"team": "edge"}
+			}
+			return nil
+		}
+		account = registry.Account("prod", prom.WithServiceLabels(labels))
+	)
+
+	account.MetricsFor("AAA").ServiceInfo.WithLabelValues("AAA", "Service One", "1").Set(1)
+	account.MetricsFor("BBB").ServiceInfo.WithLabelValues("BBB", "Service Two", "1").Set(1)
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want, have := http.StatusOK, rec.Code; want != have {
+		t.Fatalf("code: want %d, have %d", want, have)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`fastly_rt_service_info{account="prod",service_id="AAA",service_name="Service One",service_version="1",team="edge"} 1`,
+		`fastly_rt_service_info{account="prod",service_id="BBB",service_name="Service Two",service_version="1"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing: %s", want)
+		}
+	}
+}
+
+func TestLabelNames(t *testing.T) {
+	t.Parallel()
+
+	names := prom.LabelNames()
+	for _, want := range []string{"account", "service_id", "datacenter", "product"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("label names: missing %q in %v", want, names)
+		}
+	}
+
+	// Every one of them but the account label, which the registry sets over
+	// the service labels, collides with a label of some metric of a service.
+	for _, name := range names {
+		if name == "account" {
+			continue
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("service label %q: want panic, have none", name)
+				}
+			}()
+			labels := func(string) prometheus.Labels { return prometheus.Labels{name: "x"} }
+			prom.NewRegistry("dev", "fastly", "rt", filter.Filter{}).Account("prod", prom.WithServiceLabels(labels)).MetricsFor("AAA")
+		}()
+	}
+}
+
 // https://stackoverflow.com/a/36922225
 func isValidJSON(s string) bool {
 	var js json.RawMessage
 
 	return json.Unmarshal([]byte(s), &js) == nil
 }
+
+func TestRegistryAuthorizer(t *testing.T) {
+	t.Parallel()
+
+	// Default gatherers yield metrics of services, e.g. of dictionaries, of
+	// whole accounts, e.g. of the token, and of the exporter itself.
+	defaults := prometheus.NewRegistry()
+	dictionaryItems := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "fastly_rt_dictionary_item_count"}, []string{"account", "service_id", "dictionary_name"})
+	dictionaryItems.WithLabelValues("prod", "AAA", "dict-a").Set(1)
+	dictionaryItems.WithLabelValues("prod", "BBB", "dict-b").Set(2)
+	tokenExpiration := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "fastly_rt_token_expiration"}, []string{"account", "token_id"})
+	tokenExpiration.WithLabelValues("prod", "token-prod").Set(1)
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{Name: "fastly_rt_build_info"})
+	defaults.MustRegister(dictionaryItems, tokenExpiration, buildInfo)
+
+	registry := prom.NewRegistry("dev", "fastly", "rt", filter.Filter{}, defaults)
+	for _, tc := range []struct{ account, serviceID string }{
+		{"prod", "AAA"},
+		{"prod", "BBB"},
+		{"staging", "BBB"},
+	} {
+		registry.Account(tc.account).MetricsFor(tc.serviceID).ServiceInfo.WithLabelValues(tc.serviceID, "Service", "1").Set(1)
+	}
+
+	// Callers named by the X-Caller header may only see services in the
+	// corresponding account and list; all other callers see everything.
+	registry.SetAuthorizer(mockAuthorizer{
+		"team-a": {"prod/AAA": true, "prod/ZZZ": true},
+		"team-b": {"staging/BBB": true},
+		"team-c": {"prod/": true, "prod/AAA": true, "prod/BBB": true}, // the whole prod account
+	})
+
+	get := func(caller, path string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Caller", caller)
+		rec := httptest.NewRecorder()
+		registry.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	for _, testcase := range []struct {
+		caller   string
+		path     string
+		wantCode int
+		want     []string
+		dontWant []string
+	}{
+		{"", "/metrics", 200, []string{`account="prod",service_id="AAA"`, `account="prod",service_id="BBB"`, `account="staging",service_id="BBB"`, "dict-b", "token-prod", "fastly_rt_build_info"}, nil},
+		{"team-a", "/metrics", 200, []string{`account="prod",service_id="AAA"`, "dict-a"}, []string{`service_id="BBB"`, "dict-b", "token-prod", "fastly_rt_build_info"}},
+		{"team-c", "/metrics", 200, []string{"dict-a", "dict-b", "token-prod"}, []string{"staging", "fastly_rt_build_info"}},
+		{"team-a", "/metrics?target=AAA", 200, []string{`service_id="AAA"`}, nil},
+		{"team-a", "/metrics?target=BBB", 403, nil, []string{`service_id="BBB"`}},
+		{"team-a", "/metrics?target=ZZZ&account=prod", 200, nil, nil},
+		{"team-a", "/metrics?target=YYY", 403, nil, nil},
+		{"team-b", "/metrics?target=BBB", 200, []string{`account="staging",service_id="BBB"`}, []string{`account="prod"`}},
+		{"team-b", "/metrics?target=BBB&account=prod", 403, nil, nil},
+		{"team-a", "/sd", 200, []string{`"AAA"`}, []string{`"BBB"`, "staging"}},
+		{"team-b", "/sd", 200, []string{`"BBB"`, "staging"}, []string{`"AAA"`}},
+		{"team-a", "/", 200, []string{"target=AAA", "account=prod"}, []string{"target=BBB", "account=staging"}},
+	} {
+		code, body := get(testcase.caller, testcase.path)
+		if want, have := testcase.wantCode, code; want != have {
+			t.Errorf("%s %s: code: want %d, have %d", testcase.caller, testcase.path, want, have)
+		}
+		for _, s := range testcase.want {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s %s: missing %s", testcase.caller, testcase.path, s)
+			}
+		}
+		for _, s := range testcase.dontWant {
+			if strings.Contains(body, s) {
+				t.Errorf("%s %s: unexpected %s", testcase.caller, testcase.path, s)
+			}
+		}
+	}
+}
+
+type mockAuthorizer map[string]map[string]bool
+
+func (a mockAuthorizer) Authorized(req *http.Request, account, serviceID string) bool {
+	services, ok := a[req.Header.Get("X-Caller")]
+	return !ok || services[account+"/"+serviceID]
+}
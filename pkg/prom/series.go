@@ -0,0 +1,170 @@
+package prom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TrackServiceInfo records that the service info series with the given labels
+// was just updated. Series for previous service versions or names stop being
+// tracked as updated, and are eventually deleted by ExpireSeries.
+func (m *Metrics) TrackServiceInfo(serviceID, serviceName, serviceVersion string) {
+	now := time.Now()
+	m.series.touch(now, seriesKey{kind: seriesService, serviceID: serviceID, serviceName: serviceName})
+	m.series.touch(now, seriesKey{kind: seriesServiceInfo, serviceID: serviceID, serviceName: serviceName, a: serviceVersion})
+}
+
+// Process updates the metrics of the product with the response, and records
+// that the series it touched were just updated. Responses of products which
+// weren't registered when the metrics were constructed are ignored.
+func (m *Metrics) Process(p product.Product, response product.Response, serviceID, serviceName, serviceVersion string, aggregateOnly bool) {
+	metrics, ok := m.products[p.Name()]
+	if !ok {
+		return
+	}
+	var (
+		now   = time.Now()
+		name  = p.Name()
+		label = p.Label()
+	)
+	response.Process(metrics, serviceID, serviceName, serviceVersion, aggregateOnly, func(datacenter, value string) {
+		m.series.touch(now, seriesKey{kind: seriesProduct, serviceID: serviceID, serviceName: serviceName, product: name, label: label, a: datacenter, b: value})
+	})
+}
+
+// ExpireSeries deletes all series whose label combinations haven't been
+// tracked as updated within the TTL. It returns the number of expired label
+// combinations, each of which may correspond to many series.
+func (m *Metrics) ExpireSeries(ttl time.Duration) int {
+	expired := m.series.expire(time.Now().Add(-ttl))
+	for _, key := range expired {
+		labels := key.labels()
+		for _, vec := range m.deletersFor(key.kind, key.product) {
+			vec.DeletePartialMatch(labels)
+		}
+		if key.kind != seriesServiceInfo {
+			m.restored.deletePartialMatch(key.product, labels)
+		}
+	}
+	return len(expired)
+}
+
+func (m *Metrics) deletersFor(kind seriesKind, product string) []labelDeleter {
+	switch kind {
+	case seriesService:
+		return m.deleters.all
+	case seriesServiceInfo:
+		return []labelDeleter{m.ServiceInfo}
+	case seriesProduct:
+		return m.deleters.products[product]
+	default:
+		return nil
+	}
+}
+
+//
+//
+//
+
+type seriesKind uint8
+
+const (
+	seriesService     seriesKind = iota // service_id, service_name
+	seriesServiceInfo                   // service_id, service_name, service_version
+	seriesProduct                       // service_id, service_name, datacenter, and the product's label, if any
+)
+
+// seriesKey identifies a label combination. It's comparable, so that tracking
+// updates on the hot path doesn't allocate.
+type seriesKey struct {
+	kind        seriesKind
+	serviceID   string
+	serviceName string
+	product     string
+	label       string
+	a, b        string
+}
+
+func (k seriesKey) labels() prometheus.Labels {
+	labels := prometheus.Labels{"service_id": k.serviceID, "service_name": k.serviceName}
+	switch k.kind {
+	case seriesServiceInfo:
+		labels["service_version"] = k.a
+	case seriesProduct:
+		labels["datacenter"] = k.a
+		if k.label != "" {
+			labels[k.label] = k.b
+		}
+	}
+	return labels
+}
+
+// seriesTracker records the last time each label combination was updated.
+type seriesTracker struct {
+	mtx     sync.Mutex
+	updated map[seriesKey]time.Time
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{updated: map[seriesKey]time.Time{}}
+}
+
+func (t *seriesTracker) touch(now time.Time, key seriesKey) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.updated[key] = now
+}
+
+func (t *seriesTracker) expire(cutoff time.Time) (expired []seriesKey) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for key, updated := range t.updated {
+		if updated.Before(cutoff) {
+			expired = append(expired, key)
+			delete(t.updated, key)
+		}
+	}
+	return expired
+}
+
+// labelDeleter models the method of the Prometheus metric vector types which
+// deletes all series matching a subset of labels.
+type labelDeleter interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// metricsDeleters groups the metric vectors of a Metrics by product.
+type metricsDeleters struct {
+	all      []labelDeleter
+	products map[string][]labelDeleter
+}
+
+func newMetricsDeleters(m *Metrics) metricsDeleters {
+	d := metricsDeleters{
+		all:      []labelDeleter{m.ServiceInfo, m.LastSuccessfulResponse, m.CircuitState, m.DuplicateSamplesTotal},
+		products: make(map[string][]labelDeleter, len(m.products)),
+	}
+	for name, v := range m.products {
+		d.products[name] = deletersOf(v)
+		d.all = append(d.all, d.products[name]...)
+	}
+	return d
+}
+
+// deletersOf returns every metric vector in the fields of the struct pointed to
+// by v, e.g. a *realtime.Metrics.
+func deletersOf(v interface{}) []labelDeleter {
+	var (
+		collectors = collectorsOf(v)
+		deleters   = make([]labelDeleter, 0, len(collectors))
+	)
+	for _, c := range collectors {
+		if d, ok := c.(labelDeleter); ok {
+			deleters = append(deleters, d)
+		}
+	}
+	return deleters
+}
@@ -0,0 +1,85 @@
+package prom_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsExpireSeries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		registry = prometheus.NewRegistry()
+		metrics  = prom.NewMetrics("fastly", "rt", filter.Filter{}, registry)
+	)
+
+	update := func(version string, datacenters []string, origins map[string][]string) {
+		metrics.ServiceInfo.WithLabelValues("AAA", "Service One", version).Set(1)
+		metrics.TrackServiceInfo("AAA", "Service One", version)
+
+		byDatacenter := map[string]realtime.Datacenter{}
+		for _, dc := range datacenters {
+			byDatacenter[dc] = realtime.Datacenter{Requests: 1}
+		}
+		buf, _ := json.Marshal(map[string]interface{}{"Data": []interface{}{map[string]interface{}{"datacenter": byDatacenter}}})
+		rtResponse := product.Realtime.NewResponse()
+		if err := json.Unmarshal(buf, rtResponse); err != nil {
+			t.Fatal(err)
+		}
+		metrics.Process(product.Realtime, rtResponse, "AAA", "Service One", version, false)
+
+		originResponse := origin.Response{Data: []origin.Data{{Datacenter: origin.ByDatacenter{}}}}
+		for dc, names := range origins {
+			byOrigin := origin.ByOrigin{}
+			for _, name := range names {
+				byOrigin[name] = origin.Stats{Responses: 1}
+			}
+			originResponse.Data[0].Datacenter[dc] = byOrigin
+		}
+		buf, _ = json.Marshal(originResponse)
+		originProductResponse := product.Origin.NewResponse()
+		if err := json.Unmarshal(buf, originProductResponse); err != nil {
+			t.Fatal(err)
+		}
+		metrics.Process(product.Origin, originProductResponse, "AAA", "Service One", version, false)
+	}
+
+	update("1", []string{"NYC", "LHR"}, map[string][]string{"NYC": {"old-origin", "new-origin"}})
+	time.Sleep(50 * time.Millisecond)
+	update("2", []string{"NYC"}, map[string][]string{"NYC": {"new-origin"}})
+
+	if want, have := 2+6+2, testutil.CollectAndCount(metrics.Realtime.RequestsTotal)+testutil.CollectAndCount(metrics.Origin.ResponsesTotal)+testutil.CollectAndCount(metrics.ServiceInfo); want != have {
+		t.Fatalf("series before expiry: want %d, have %d", want, have)
+	}
+
+	if want, have := 3, metrics.ExpireSeries(25*time.Millisecond); want != have {
+		t.Errorf("expired label combinations: want %d, have %d", want, have) // version 1, LHR, NYC/old-origin
+	}
+
+	for _, testcase := range []struct {
+		name      string
+		collector prometheus.Collector
+		want      int
+	}{
+		{"service_info", metrics.ServiceInfo, 1},
+		{"requests_total", metrics.Realtime.RequestsTotal, 1},
+		{"origin responses_total", metrics.Origin.ResponsesTotal, 3}, // delivery, compute, waf
+	} {
+		if want, have := testcase.want, testutil.CollectAndCount(testcase.collector); want != have {
+			t.Errorf("%s: want %d series, have %d", testcase.name, want, have)
+		}
+	}
+
+	if want, have := 1.0, testutil.ToFloat64(metrics.ServiceInfo.WithLabelValues("AAA", "Service One", "2")); want != have {
+		t.Errorf("current service_info: want %v, have %v", want, have)
+	}
+}
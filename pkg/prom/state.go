@@ -0,0 +1,321 @@
+package prom
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/state"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Snapshot returns the value of every counter and histogram in the metrics of
+// the products of every service, so that they can be persisted, and restored
+// by a later exporter via Account.Restore.
+func (r *Registry) Snapshot() []state.Series {
+	r.mtx.Lock()
+	byService := make(map[serviceKey]*Metrics, len(r.byService))
+	for key, mr := range r.byService {
+		byService[key] = mr.metrics
+	}
+	r.mtx.Unlock()
+
+	var series []state.Series
+	for key, m := range byService {
+		series = append(series, m.snapshot(key.account)...)
+	}
+	return series
+}
+
+// Restore adds the values of the series of the account, as returned by
+// Snapshot, to the metrics of their services, creating them if necessary. The
+// series are tracked as just updated. Series of services for which keep
+// returns false are skipped, e.g. services which are no longer exported, as are
+// series which no longer match a metric. Restore returns the number of restored
+// series.
+//
+// Restored histograms are kept apart from the live histograms, and added to
+// them when they're gathered, so histograms whose buckets changed since the
+// snapshot are skipped.
+func (a *Account) Restore(series []state.Series, keep func(serviceID string) bool) int {
+	byService := map[string][]state.Series{}
+	for _, s := range series {
+		serviceID := s.Labels["service_id"]
+		if s.Account != a.name || serviceID == "" || !keep(serviceID) {
+			continue
+		}
+		byService[serviceID] = append(byService[serviceID], s)
+	}
+
+	var n int
+	for serviceID, series := range byService {
+		n += a.MetricsFor(serviceID).restore(series)
+	}
+	return n
+}
+
+func (m *Metrics) snapshot(account string) []state.Series {
+	var series []state.Series
+	for name, v := range m.products {
+		for _, c := range collectorsOf(v) {
+			switch c.(type) {
+			case *prometheus.CounterVec, *prometheus.HistogramVec:
+			default:
+				continue
+			}
+
+			var (
+				metricName = getName(c)
+				ch         = make(chan prometheus.Metric)
+			)
+			go func() { c.Collect(ch); close(ch) }()
+			for metric := range ch {
+				var pb dto.Metric
+				if err := metric.Write(&pb); err != nil {
+					continue
+				}
+				s := state.Series{Account: account, Product: name, Name: metricName, Labels: map[string]string{}}
+				for _, pair := range pb.GetLabel() {
+					s.Labels[pair.GetName()] = pair.GetValue()
+				}
+				switch {
+				case pb.Counter != nil:
+					s.Value = pb.Counter.GetValue()
+				case pb.Histogram != nil:
+					m.restored.addToMetric(metricName, &pb)
+					s.Histogram = histogramOf(pb.Histogram)
+				default:
+					continue
+				}
+				series = append(series, s)
+			}
+		}
+	}
+	return series
+}
+
+func (m *Metrics) restore(series []state.Series) int {
+	byName := map[string]prometheus.Collector{}
+	for _, v := range m.products {
+		for _, c := range collectorsOf(v) {
+			byName[getName(c)] = c
+		}
+	}
+
+	var (
+		now = time.Now()
+		n   int
+	)
+	for _, s := range series {
+		p, ok := product.Lookup(s.Product)
+		if !ok {
+			continue
+		}
+
+		switch vec := byName[s.Name].(type) {
+		case *prometheus.CounterVec:
+			counter, err := vec.GetMetricWith(s.Labels)
+			if err != nil || s.Value < 0 {
+				continue
+			}
+			counter.Add(s.Value)
+
+		case *prometheus.HistogramVec:
+			obs, err := vec.GetMetricWith(s.Labels)
+			if err != nil || s.Histogram == nil {
+				continue
+			}
+			var live dto.Metric
+			if err := obs.(prometheus.Metric).Write(&live); err != nil {
+				continue
+			}
+			if !m.restored.add(p.Name(), s.Name, s.Labels, *s.Histogram, live.GetHistogram()) {
+				continue
+			}
+
+		default:
+			continue
+		}
+
+		m.series.touch(now, seriesKeyOf(p, s.Labels))
+		n++
+	}
+	return n
+}
+
+// seriesKeyOf returns the key which tracks the series of the product with the
+// labels when it's updated: series of a datacenter are tracked like by Process,
+// and all others, e.g. those of real-time API requests, like the service by
+// TrackServiceInfo.
+func seriesKeyOf(p product.Product, labels map[string]string) seriesKey {
+	key := seriesKey{kind: seriesService, serviceID: labels["service_id"], serviceName: labels["service_name"]}
+	datacenter, ok := labels["datacenter"]
+	if !ok {
+		return key
+	}
+	key.kind, key.product, key.label, key.a = seriesProduct, p.Name(), p.Label(), datacenter
+	if key.label != "" {
+		key.b = labels[key.label]
+	}
+	return key
+}
+
+func histogramOf(pb *dto.Histogram) *state.Histogram {
+	h := &state.Histogram{Count: pb.GetSampleCount(), Sum: pb.GetSampleSum()}
+	for _, b := range pb.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			continue
+		}
+		h.Bounds = append(h.Bounds, b.GetUpperBound())
+		h.Buckets = append(h.Buckets, b.GetCumulativeCount())
+	}
+	return h
+}
+
+// restoredHistograms holds the values of histograms restored from a snapshot.
+// They're added to the values of the live histograms whenever those are
+// gathered or snapshotted, rather than observed again, so that restoring takes
+// no time proportional to the number of original observations.
+type restoredHistograms struct {
+	mtx    sync.Mutex
+	byName map[string]*restoredFamily
+}
+
+// restoredFamily holds the restored histograms of a single metric, by the
+// signature of their labels.
+type restoredFamily struct {
+	product    string
+	labelNames []string
+	byLabels   map[string]restoredHistogram
+}
+
+type restoredHistogram struct {
+	labels map[string]string
+	state.Histogram
+}
+
+func newRestoredHistograms() *restoredHistograms {
+	return &restoredHistograms{byName: map[string]*restoredFamily{}}
+}
+
+// add records the histogram of the product's metric with the name and labels,
+// so that it's added to the live histogram, which must have the same buckets.
+// It returns false if the histogram doesn't fit the live one.
+func (r *restoredHistograms) add(product, name string, labels map[string]string, h state.Histogram, live *dto.Histogram) bool {
+	if len(h.Bounds) != len(h.Buckets) || len(h.Bounds) != len(live.GetBucket()) {
+		return false
+	}
+	var prev uint64
+	for i, b := range live.GetBucket() {
+		if h.Bounds[i] != b.GetUpperBound() || h.Buckets[i] < prev {
+			return false // other buckets, or not cumulative
+		}
+		prev = h.Buckets[i]
+	}
+	if h.Count < prev {
+		return false
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	f, ok := r.byName[name]
+	if !ok {
+		f = &restoredFamily{product: product, byLabels: map[string]restoredHistogram{}}
+		for name := range labels {
+			f.labelNames = append(f.labelNames, name)
+		}
+		sort.Strings(f.labelNames)
+		r.byName[name] = f
+	}
+
+	sig := signature(f.labelNames, labels)
+	if prev, ok := f.byLabels[sig]; ok {
+		h.Count += prev.Count
+		h.Sum += prev.Sum
+		h.Buckets = append([]uint64(nil), h.Buckets...)
+		for i := range h.Buckets {
+			h.Buckets[i] += prev.Buckets[i]
+		}
+	}
+	f.byLabels[sig] = restoredHistogram{labels: labels, Histogram: h}
+	return true
+}
+
+// addTo adds the restored histograms to the matching metrics of the families.
+// The metrics may carry additional labels, e.g. the account label.
+func (r *restoredHistograms) addTo(families []*dto.MetricFamily) {
+	for _, mf := range families {
+		for _, pb := range mf.GetMetric() {
+			r.addToMetric(mf.GetName(), pb)
+		}
+	}
+}
+
+func (r *restoredHistograms) addToMetric(name string, pb *dto.Metric) {
+	if pb.Histogram == nil {
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	f, ok := r.byName[name]
+	if !ok {
+		return
+	}
+	labels := make(map[string]string, len(pb.GetLabel()))
+	for _, pair := range pb.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	h, ok := f.byLabels[signature(f.labelNames, labels)]
+	if !ok || len(h.Buckets) != len(pb.Histogram.GetBucket()) {
+		return
+	}
+
+	var (
+		count = pb.Histogram.GetSampleCount() + h.Count
+		sum   = pb.Histogram.GetSampleSum() + h.Sum
+	)
+	pb.Histogram.SampleCount, pb.Histogram.SampleSum = &count, &sum
+	for i, b := range pb.Histogram.GetBucket() {
+		cumulative := b.GetCumulativeCount() + h.Buckets[i]
+		b.CumulativeCount = &cumulative
+	}
+}
+
+// deletePartialMatch drops the restored histograms whose labels match the
+// given labels, like the DeletePartialMatch method of the metric vectors. An
+// empty product matches the histograms of every product.
+func (r *restoredHistograms) deletePartialMatch(product string, labels prometheus.Labels) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, f := range r.byName {
+		if product != "" && f.product != product {
+			continue
+		}
+	histograms:
+		for sig, h := range f.byLabels {
+			for name, value := range labels {
+				if v, ok := h.labels[name]; !ok || v != value {
+					continue histograms
+				}
+			}
+			delete(f.byLabels, sig)
+		}
+	}
+}
+
+// signature identifies the values of the named labels.
+func signature(names []string, labels map[string]string) string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, "\xff")
+}
@@ -0,0 +1,138 @@
+package prom_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/product"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/state"
+)
+
+func TestRegistrySnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	var (
+		before = prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+		m      = before.Account("acct").MetricsFor("AAA")
+		miss   = m.Realtime.MissDurationSeconds.WithLabelValues("AAA", "Service One", "NYC")
+	)
+	m.Realtime.RequestsTotal.WithLabelValues("AAA", "Service One", "NYC").Add(5)
+	m.Origin.RespBodyBytesTotal.WithLabelValues("AAA", "Service One", "NYC", "origin-1", "edge").Add(1024)
+	for _, v := range []float64{0.003, 0.003, 0.2, 100} {
+		miss.Observe(v)
+	}
+	before.Account("other").MetricsFor("BBB").Realtime.RequestsTotal.WithLabelValues("BBB", "Service Two", "NYC").Add(1)
+	before.Account("acct").MetricsFor("CCC").Realtime.RequestsTotal.WithLabelValues("CCC", "Service Three", "NYC").Add(1)
+
+	buf, err := json.Marshal(before.Snapshot()) // as in the state file
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series []state.Series
+	if err := json.Unmarshal(buf, &series); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		after = prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+		keep  = func(serviceID string) bool { return serviceID != "CCC" }
+	)
+	if want, have := 3, after.Account("acct").Restore(series, keep); want != have {
+		t.Errorf("restored series: want %d, have %d", want, have)
+	}
+
+	fastlytest.AssertMetrics(t, after, "fastly_rt_requests_total", map[string]float64{
+		`fastly_rt_requests_total{account="acct",datacenter="NYC",service_id="AAA",service_name="Service One"}`: 5,
+	})
+	fastlytest.AssertMetric(t, after, `fastly_origin_resp_body_bytes_total{account="acct",datacenter="NYC",origin="origin-1",service_id="AAA",service_name="Service One",source="edge"}`, 1024)
+	fastlytest.AssertMetrics(t, after, "fastly_rt_miss_duration_seconds", fastlytest.Metrics(t, before, "fastly_rt_miss_duration_seconds"))
+}
+
+func TestRegistryRestoreHistogram(t *testing.T) {
+	t.Parallel()
+
+	var (
+		bounds  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 60}
+		buckets = make([]uint64, len(bounds))
+		labels  = map[string]string{"service_id": "AAA", "service_name": "Service One", "datacenter": "NYC"}
+		series  = func(buckets []uint64) []state.Series {
+			return []state.Series{{Account: "acct", Product: product.Realtime.Name(), Name: "fastly_rt_miss_duration_seconds", Labels: labels, Histogram: &state.Histogram{Count: 3e12, Sum: 1.5e12, Bounds: bounds, Buckets: buckets}}}
+		}
+	)
+	for i := range buckets {
+		buckets[i] = 1e12
+	}
+	buckets[len(buckets)-1] = 2e12
+
+	// Far too many observations to be replayed.
+	registry := prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+	if want, have := 1, registry.Account("acct").Restore(series(buckets), func(string) bool { return true }); want != have {
+		t.Fatalf("restored series: want %d, have %d", want, have)
+	}
+	registry.Account("acct").MetricsFor("AAA").Realtime.MissDurationSeconds.WithLabelValues("AAA", "Service One", "NYC").Observe(0.5)
+
+	var (
+		prefix = `fastly_rt_miss_duration_seconds`
+		suffix = `account="acct",datacenter="NYC",service_id="AAA",service_name="Service One"`
+	)
+	fastlytest.AssertMetric(t, registry, prefix+`_count{`+suffix+`}`, 3e12+1)
+	fastlytest.AssertMetric(t, registry, prefix+`_sum{`+suffix+`}`, 1.5e12+0.5)
+	fastlytest.AssertMetric(t, registry, prefix+`_bucket{`+suffix+`,le="0.005"}`, 1e12)
+	fastlytest.AssertMetric(t, registry, prefix+`_bucket{`+suffix+`,le="0.5"}`, 1e12+1)
+	fastlytest.AssertMetric(t, registry, prefix+`_bucket{`+suffix+`,le="60"}`, 2e12+1)
+
+	snapshot := registry.Snapshot()
+	if want, have := 1, len(snapshot); want != have {
+		t.Fatalf("snapshot series: want %d, have %d", want, have)
+	}
+	if h := snapshot[0].Histogram; h == nil || h.Count != 3e12+1 || h.Buckets[len(h.Buckets)-1] != 2e12+1 {
+		t.Errorf("snapshot histogram: %+v", h)
+	}
+
+	// Histograms whose buckets changed since the snapshot are skipped.
+	if want, have := 0, prom.NewRegistry("dev", "fastly", "rt", filter.Filter{}).Account("acct").Restore(series(buckets[1:]), func(string) bool { return true }); want != have {
+		t.Errorf("restored series with other buckets: want %d, have %d", want, have)
+	}
+
+	// Restored histograms expire with their series.
+	time.Sleep(50 * time.Millisecond)
+	registry.ExpireSeries(25 * time.Millisecond)
+	registry.Account("acct").MetricsFor("AAA").Realtime.MissDurationSeconds.WithLabelValues("AAA", "Service One", "NYC").Observe(0.5)
+	fastlytest.AssertMetric(t, registry, prefix+`_count{`+suffix+`}`, 1)
+}
+
+func TestRegistryRestoreExpireSeries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		before = prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+		m      = before.Account("acct").MetricsFor("AAA")
+	)
+	m.Realtime.RequestsTotal.WithLabelValues("AAA", "Service One", "NYC").Add(5)
+	m.Realtime.RealtimeAPIRequestsTotal.WithLabelValues("AAA", "Service One", "success").Add(3)
+
+	after := prom.NewRegistry("dev", "fastly", "rt", filter.Filter{})
+	if want, have := 2, after.Account("acct").Restore(before.Snapshot(), func(string) bool { return true }); want != have {
+		t.Fatalf("restored series: want %d, have %d", want, have)
+	}
+
+	apiRequests := `fastly_rt_realtime_api_requests_total{account="acct",result="success",service_id="AAA",service_name="Service One"}`
+
+	// The series of the datacenter expire on their own, but the series of the
+	// service live as long as the service is updated, like live series.
+	time.Sleep(50 * time.Millisecond)
+	after.Account("acct").MetricsFor("AAA").TrackServiceInfo("AAA", "Service One", "1")
+	if want, have := 1, after.ExpireSeries(25*time.Millisecond); want != have {
+		t.Errorf("expired: want %d, have %d", want, have)
+	}
+	fastlytest.AssertMetrics(t, after, "fastly_rt_requests_total", map[string]float64{})
+	fastlytest.AssertMetric(t, after, apiRequests, 3)
+
+	time.Sleep(50 * time.Millisecond)
+	after.ExpireSeries(25 * time.Millisecond)
+	fastlytest.AssertMetrics(t, after, "fastly_rt_realtime_api_requests_total", map[string]float64{})
+}
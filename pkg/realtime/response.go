@@ -6,12 +6,15 @@ package realtime
 type Response struct {
 	Timestamp      uint64 `json:"Timestamp"`
 	AggregateDelay int64  `json:"AggregateDelay"`
-	Data           []struct {
-		Datacenter map[string]Datacenter `json:"datacenter"`
-		Aggregated Datacenter            `json:"aggregated"`
-		Recorded   uint64                `json:"recorded"`
-	} `json:"Data"`
-	Error string `json:"error"`
+	Data           []Data `json:"Data"`
+	Error          string `json:"error"`
+}
+
+// Data is the top-level grouping of real-time stats.
+type Data struct {
+	Datacenter map[string]Datacenter `json:"datacenter"`
+	Aggregated Datacenter            `json:"aggregated"`
+	Recorded   uint64                `json:"recorded"`
 }
 
 // Datacenter models the per-datacenter portion of the rt.fastly.com response.
@@ -0,0 +1,4 @@
+// Package recording records the requests the exporter sends to the Fastly APIs,
+// and their responses, and replays them, so that problems can be reproduced
+// offline.
+package recording
@@ -0,0 +1,59 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPClient is a consumer contract for the recorder, and the contract
+// implemented by the replayer. It models a concrete http.Client, and is
+// equivalent to the HTTPClient interfaces of packages api and rt.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// redacted replaces the values of the request headers which carry tokens.
+const redacted = "REDACTED"
+
+// sensitiveHeaders are the request headers which are redacted in recordings.
+var sensitiveHeaders = []string{"Fastly-Key", "Authorization"}
+
+// Exchange is a recorded request and its response. Each exchange is stored as
+// a separate JSON file in the recording directory, named after its sequence
+// number. Bodies which are compact JSON, like those of the Fastly APIs, are
+// stored as is, which makes them easy to copy into test fixtures; other bodies
+// are stored as text, so that they're replayed byte for byte.
+type Exchange struct {
+	Seq      uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Request  http.Header     `json:"request_header,omitempty"`
+	Status   int             `json:"status"`
+	Header   http.Header     `json:"header,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	BodyText string          `json:"body_text,omitempty"`
+}
+
+func (e *Exchange) setBody(body []byte) {
+	var compact bytes.Buffer
+	if len(body) > 0 && json.Compact(&compact, body) == nil && bytes.Equal(compact.Bytes(), body) {
+		e.Body = json.RawMessage(body)
+		return
+	}
+	e.BodyText = string(body)
+}
+
+func (e *Exchange) body() []byte {
+	if len(e.Body) > 0 {
+		return e.Body
+	}
+	return []byte(e.BodyText)
+}
+
+func filename(seq uint64) string {
+	return fmt.Sprintf("%08d.json", seq)
+}
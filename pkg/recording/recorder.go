@@ -0,0 +1,119 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Recorder writes requests executed via its clients, along with their
+// responses, to a directory. Tokens in request headers are redacted. Requests
+// which fail without a response aren't recorded.
+type Recorder struct {
+	dir    string
+	logger log.Logger
+	seq    atomic.Uint64
+}
+
+// NewRecorder returns a recorder which writes to dir, which is created if
+// necessary. If dir already contains a recording, it's continued.
+func NewRecorder(dir string, logger log.Logger) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	existing, err := load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{dir: dir, logger: logger}
+	if n := len(existing); n > 0 {
+		r.seq.Store(existing[n-1].Seq)
+	}
+	return r, nil
+}
+
+// Client returns a client which executes requests via the next client, and
+// records them.
+func (r *Recorder) Client(next HTTPClient) HTTPClient {
+	return &recordingClient{recorder: r, next: next}
+}
+
+type recordingClient struct {
+	recorder *Recorder
+	next     HTTPClient
+}
+
+// Do implements HTTPClient.
+func (c *recordingClient) Do(req *http.Request) (*http.Response, error) {
+	begin := time.Now()
+
+	resp, err := c.next.Do(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+
+	e := Exchange{
+		Seq:     c.recorder.seq.Add(1),
+		Time:    begin.UTC(),
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Request: redact(req.Header),
+		Status:  resp.StatusCode,
+		Header:  resp.Header,
+	}
+	e.setBody(body)
+
+	if err := c.recorder.write(e); err != nil {
+		level.Warn(c.recorder.logger).Log("during", "record exchange", "url", e.URL, "err", err)
+	}
+
+	return resp, nil
+}
+
+func (r *Recorder) write(e Exchange) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	// Write via a temporary file, so that a replayer never sees partial files.
+	var (
+		path = filepath.Join(r.dir, filename(e.Seq))
+		tmp  = path + ".tmp"
+	)
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func redact(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range sensitiveHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+	return header
+}
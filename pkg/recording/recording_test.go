@@ -0,0 +1,163 @@
+package recording_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/recording"
+	"github.com/go-kit/log"
+)
+
+func TestRecordReplay(t *testing.T) {
+	t.Parallel()
+
+	// A stand-in for the Fastly APIs, whose service list changes with every
+	// request, and whose real-time timestamps increase.
+	var services int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/service":
+			services++
+			w.Header().Set("X-Services", fmt.Sprint(services))
+			fmt.Fprintf(w, `{"count":%d}`, services)
+		case strings.HasPrefix(r.URL.Path, "/v1/channel/AAA/ts/"):
+			var ts int
+			fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/v1/channel/AAA/ts/"), "%d", &ts)
+			fmt.Fprintf(w, `{"Timestamp":%d}`, ts+10)
+		default:
+			http.Error(w, "oops", http.StatusTeapot)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := recording.NewRecorder(dir, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		apiClient = recorder.Client(http.DefaultClient)
+		rtClient  = recorder.Client(http.DefaultClient)
+	)
+	for _, tc := range []struct {
+		client recording.HTTPClient
+		path   string
+	}{
+		{apiClient, "/service"},
+		{rtClient, "/v1/channel/AAA/ts/0"},
+		{apiClient, "/service"},
+		{rtClient, "/v1/channel/AAA/ts/10"},
+		{apiClient, "/other"},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.path, nil)
+		req.Header.Set("Fastly-Key", "s3cret")
+		resp, err := tc.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if want, have := 5, len(files); want != have {
+		t.Fatalf("recorded files: want %d, have %d", want, have)
+	}
+	for _, file := range files {
+		buf, _ := os.ReadFile(file)
+		if strings.Contains(string(buf), "s3cret") {
+			t.Errorf("%s: token isn't redacted", file)
+		}
+	}
+	var first recording.Exchange
+	buf, _ := os.ReadFile(files[0])
+	if err := json.Unmarshal(buf, &first); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `{"count":1}`, string(first.Body); want != have {
+		t.Errorf("first body: want %s, have %s", want, have)
+	}
+
+	replayer, err := recording.NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(ctx context.Context, path string) (int, string, error) {
+		t.Helper()
+		// The base URL differs from the recording.
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://replay.invalid"+path, nil)
+		resp, err := replayer.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), nil
+	}
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/service", 200, `{"count":1}`},
+		{"/service", 200, `{"count":2}`},
+		{"/service", 200, `{"count":2}`}, // last response is repeated
+		{"/v1/channel/AAA/ts/0", 200, `{"Timestamp":10}`},
+		{"/v1/channel/AAA/ts/10", 200, `{"Timestamp":20}`},
+		{"/other", http.StatusTeapot, "oops\n"},
+		{"/unknown", http.StatusNotFound, `{"msg":"no recorded response"}`},
+	} {
+		code, body, err := get(ctx, tc.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		if code != tc.wantCode || body != tc.wantBody {
+			t.Errorf("%s: want %d %q, have %d %q", tc.path, tc.wantCode, tc.wantBody, code, body)
+		}
+	}
+
+	// After the last recorded timestamp, real-time requests block like long
+	// polls without new data, until they're canceled.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := get(ctx, "/v1/channel/AAA/ts/20"); err != context.DeadlineExceeded {
+		t.Errorf("after last timestamp: want %v, have %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRecorderContinues(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		recorder, err := recording.NewRecorder(dir, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if _, err := recorder.Client(http.DefaultClient).Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"00000001.json", "00000002.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Replayer is an HTTP client which responds to requests with the responses of
+// a recording, without any network access.
+//
+// Requests are matched by method, path, and query, so the recording can be
+// replayed with different base URLs. Requests which match several exchanges
+// get their responses in recorded order, and the last response is repeated
+// once they're exhausted. For real-time stats requests, the path contains the
+// timestamp of the previous response, so each subscriber follows the recorded
+// timestamp sequence deterministically.
+//
+// Like rt.fastly.com when there's no new data, real-time stats requests which
+// aren't in the recording, i.e. those following the last recorded response,
+// block until their context is canceled. Other requests which aren't in the
+// recording get a 404 Not Found.
+type Replayer struct {
+	mtx       sync.Mutex
+	exchanges map[string][]*Exchange
+	served    map[string]int
+}
+
+// NewReplayer returns a replayer for the recording in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	exchanges, err := load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(exchanges) == 0 {
+		return nil, fmt.Errorf("%s: no recorded exchanges", dir)
+	}
+
+	r := &Replayer{
+		exchanges: map[string][]*Exchange{},
+		served:    map[string]int{},
+	}
+	for _, e := range exchanges {
+		k, err := keyOf(e.Method, e.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(dir, filename(e.Seq)), err)
+		}
+		r.exchanges[k] = append(r.exchanges[k], e)
+	}
+	return r, nil
+}
+
+// Do implements HTTPClient.
+func (r *Replayer) Do(req *http.Request) (*http.Response, error) {
+	k, err := keyOf(req.Method, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r.mtx.Lock()
+	var (
+		exchanges = r.exchanges[k]
+		i         = r.served[k]
+	)
+	if i < len(exchanges) {
+		r.served[k]++
+	} else {
+		i = len(exchanges) - 1
+	}
+	r.mtx.Unlock()
+
+	if i < 0 {
+		if isRealtime(req.URL.Path) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		return response(req, http.StatusNotFound, nil, []byte(`{"msg":"no recorded response"}`)), nil
+	}
+
+	e := exchanges[i]
+	return response(req, e.Status, e.Header, e.body()), nil
+}
+
+func response(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// isRealtime returns true for the paths of the real-time stats API.
+func isRealtime(path string) bool {
+	return strings.HasPrefix(path, "/v1/channel/") || strings.HasPrefix(path, "/v1/origins/") || strings.HasPrefix(path, "/v1/domains/")
+}
+
+func keyOf(method, rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	return method + " " + u.RequestURI(), nil
+}
+
+// load reads all exchanges in dir, sorted by sequence number.
+func load(dir string) ([]*Exchange, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := make([]*Exchange, 0, len(paths))
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var e Exchange
+		if err := json.Unmarshal(buf, &e); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		exchanges = append(exchanges, &e)
+	}
+
+	sort.Slice(exchanges, func(i, j int) bool { return exchanges[i].Seq < exchanges[j].Seq })
+	return exchanges, nil
+}
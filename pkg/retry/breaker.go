@@ -0,0 +1,88 @@
+package retry
+
+import "time"
+
+// State of a circuit breaker. The values are exported as metrics.
+type State int
+
+const (
+	// Closed circuits permit requests.
+	Closed State = 0
+
+	// Open circuits permit no requests until their cooldown has passed.
+	Open State = 1
+
+	// HalfOpen circuits permit a probe, which closes the circuit if it
+	// succeeds, and opens it again if it fails.
+	HalfOpen State = 2
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is a circuit breaker for a single endpoint, e.g. the origin
+// inspector of one service. It opens after the policy's threshold of
+// consecutive failures. It isn't safe for concurrent use.
+type Breaker struct {
+	policy   Policy
+	failures int
+	open     bool
+	until    time.Time
+}
+
+// NewBreaker returns a closed breaker with the policy.
+func NewBreaker(policy Policy) *Breaker {
+	return &Breaker{policy: policy.withDefaults()}
+}
+
+// Success records a successful request, which closes the circuit.
+func (b *Breaker) Success() {
+	b.failures = 0
+	b.open = false
+}
+
+// Failure records a failed request. It opens the circuit at the threshold of
+// consecutive failures, or at any failure of a probe.
+func (b *Breaker) Failure(now time.Time) {
+	b.failures++
+	if b.open || b.failures >= b.policy.Threshold {
+		b.open = true
+		b.until = now.Add(b.policy.Cooldown)
+	}
+}
+
+// Failures returns the number of consecutive failures.
+func (b *Breaker) Failures() int {
+	return b.failures
+}
+
+// State returns the state of the circuit at the given time.
+func (b *Breaker) State(now time.Time) State {
+	switch {
+	case !b.open:
+		return Closed
+	case now.Before(b.until):
+		return Open
+	default:
+		return HalfOpen
+	}
+}
+
+// Wait returns how long until the circuit permits a request. It's 0 unless
+// the circuit is open.
+func (b *Breaker) Wait(now time.Time) time.Duration {
+	if b.State(now) != Open {
+		return 0
+	}
+	return b.until.Sub(now)
+}
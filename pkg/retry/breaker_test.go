@@ -0,0 +1,48 @@
+package retry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/retry"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		now     = time.Now()
+		breaker = retry.NewBreaker(retry.Policy{Threshold: 2, Cooldown: time.Minute})
+	)
+
+	assert := func(want retry.State, wait time.Duration) {
+		t.Helper()
+		if have := breaker.State(now); want != have {
+			t.Errorf("state: want %s, have %s", want, have)
+		}
+		if have := breaker.Wait(now); wait != have {
+			t.Errorf("wait: want %s, have %s", wait, have)
+		}
+	}
+
+	assert(retry.Closed, 0)
+
+	breaker.Failure(now)
+	assert(retry.Closed, 0)
+
+	breaker.Failure(now)
+	assert(retry.Open, time.Minute)
+
+	now = now.Add(time.Minute)
+	assert(retry.HalfOpen, 0)
+
+	breaker.Failure(now) // failed probe
+	assert(retry.Open, time.Minute)
+
+	now = now.Add(time.Minute)
+	breaker.Success() // successful probe
+	assert(retry.Closed, 0)
+
+	breaker.Failure(now)
+	assert(retry.Closed, 0)
+}
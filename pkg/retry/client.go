@@ -0,0 +1,57 @@
+package retry
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPClient is a consumer contract for the client. It models a concrete
+// http.Client.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Client sends requests with a retry policy. Requests which fail in a
+// retryable way are sent again after a backoff, or the wait requested by the
+// response if it's longer, until the policy's attempts are exhausted. Waits
+// longer than the policy's maximum delay aren't worth blocking for, so the
+// response is returned instead. Only requests without a body are retried.
+type Client struct {
+	client HTTPClient
+	policy Policy
+}
+
+// NewClient wraps the client with the policy.
+func NewClient(client HTTPClient, policy Policy) *Client {
+	return &Client{client: client, policy: policy.withDefaults()}
+}
+
+// Do implements HTTPClient.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if attempt >= c.policy.Attempts || req.Body != nil || !Retryable(resp, err) {
+			return resp, err
+		}
+
+		delay := c.policy.Backoff(attempt)
+		if after := After(resp, time.Now()); after > delay {
+			delay = after
+		}
+		if delay > c.policy.Max {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package retry_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/retry"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	policy := retry.Policy{Base: time.Millisecond, Max: 10 * time.Millisecond, Attempts: 3}
+	for _, testcase := range []struct {
+		name      string
+		codes     []int
+		header    http.Header
+		wantCode  int
+		wantCalls int64
+	}{
+		{"success", []int{200}, nil, 200, 1},
+		{"not found", []int{404}, nil, 404, 1},
+		{"recovers", []int{503, 429, 200}, nil, 200, 3},
+		{"gives up", []int{503, 503, 503, 200}, nil, 503, 3},
+		{"long retry after", []int{429, 200}, http.Header{"Retry-After": {"60"}}, 429, 1},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var calls int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt64(&calls, 1)
+				for k, v := range testcase.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(testcase.codes[n-1])
+			}))
+			defer server.Close()
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			resp, err := retry.NewClient(http.DefaultClient, policy).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if want, have := testcase.wantCode, resp.StatusCode; want != have {
+				t.Errorf("code: want %d, have %d", want, have)
+			}
+			if want, have := testcase.wantCalls, atomic.LoadInt64(&calls); want != have {
+				t.Errorf("calls: want %d, have %d", want, have)
+			}
+		})
+	}
+}
+
+func TestClientCanceled(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	policy := retry.Policy{Base: time.Minute, Max: time.Hour, Attempts: 3}
+	if _, err := retry.NewClient(http.DefaultClient, policy).Do(req); err != context.DeadlineExceeded {
+		t.Errorf("want %v, have %v", context.DeadlineExceeded, err)
+	}
+}
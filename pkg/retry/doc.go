@@ -0,0 +1,5 @@
+// Package retry is the retry policy shared by the clients of the Fastly APIs:
+// exponential backoff with jitter, delays requested by the API via Retry-After
+// and rate limit headers, and circuit breakers which stop polling endpoints
+// that keep failing.
+package retry
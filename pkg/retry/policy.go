@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy configures retries. Zero fields take the value of DefaultPolicy.
+type Policy struct {
+	// Base is the delay after the first failure. It doubles with each further
+	// consecutive failure, up to Max.
+	Base time.Duration
+	Max  time.Duration
+
+	// Attempts is the number of times Client sends a request, including the
+	// first one.
+	Attempts int
+
+	// Threshold is the number of consecutive failures which opens a Breaker,
+	// and Cooldown how long the breaker stays open before it permits a probe.
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// DefaultPolicy is the policy used where none is set.
+var DefaultPolicy = Policy{
+	Base:      time.Second,
+	Max:       2 * time.Minute,
+	Attempts:  3,
+	Threshold: 5,
+	Cooldown:  time.Minute,
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.Base <= 0 {
+		p.Base = DefaultPolicy.Base
+	}
+	if p.Max <= 0 {
+		p.Max = DefaultPolicy.Max
+	}
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultPolicy.Attempts
+	}
+	if p.Threshold <= 0 {
+		p.Threshold = DefaultPolicy.Threshold
+	}
+	if p.Cooldown <= 0 {
+		p.Cooldown = DefaultPolicy.Cooldown
+	}
+	return p
+}
+
+// Backoff returns the delay after the given number of consecutive failures.
+// It's jittered to between half and all of the exponential delay, so that
+// clients which failed together don't retry together. It's 0 if there were no
+// failures.
+func (p Policy) Backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	p = p.withDefaults()
+	d := p.Base
+	for i := 1; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// After returns how long the response asks clients to wait before their next
+// request, via the Retry-After header, or the Fastly-RateLimit-Remaining and
+// Fastly-RateLimit-Reset headers once the rate limit is exhausted. It's 0 if
+// the response doesn't ask for a wait.
+func After(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+
+	if resp.Header.Get("Fastly-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("Fastly-RateLimit-Reset"), 10, 64); err == nil {
+			if t := time.Unix(reset, 0); t.After(now) {
+				return t.Sub(now)
+			}
+		}
+	}
+
+	return 0
+}
+
+// Retryable returns true if a request which got the response or error may
+// succeed when sent again: after transport errors other than cancellation,
+// 429 Too Many Requests, and 5xx server errors.
+func Retryable(resp *http.Response, err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case err != nil:
+		return true
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,87 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/retry"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := retry.Policy{Base: time.Second, Max: 10 * time.Second}
+	for _, testcase := range []struct {
+		failures int
+		min, max time.Duration
+	}{
+		{0, 0, 0},
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{5, 5 * time.Second, 10 * time.Second},
+		{100, 5 * time.Second, 10 * time.Second},
+	} {
+		for i := 0; i < 100; i++ {
+			if have := policy.Backoff(testcase.failures); have < testcase.min || have > testcase.max {
+				t.Fatalf("%d failures: want %s–%s, have %s", testcase.failures, testcase.min, testcase.max, have)
+			}
+		}
+	}
+}
+
+func TestAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, testcase := range []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": {"30"}}, 30 * time.Second},
+		{"date", http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute},
+		{"past date", http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0},
+		{"garbage", http.Header{"Retry-After": {"soon"}}, 0},
+		{"rate limit exhausted", http.Header{"Fastly-Ratelimit-Remaining": {"0"}, "Fastly-Ratelimit-Reset": {"1714564920"}}, 2 * time.Minute},
+		{"rate limit remaining", http.Header{"Fastly-Ratelimit-Remaining": {"10"}, "Fastly-Ratelimit-Reset": {"1714564920"}}, 0},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			if want, have := testcase.want, retry.After(&http.Response{Header: testcase.header}, now); want != have {
+				t.Errorf("want %s, have %s", want, have)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		name string
+		code int
+		err  error
+		want bool
+	}{
+		{"transport error", 0, errors.New("connection refused"), true},
+		{"canceled", 0, context.Canceled, false},
+		{"OK", http.StatusOK, nil, false},
+		{"not found", http.StatusNotFound, nil, false},
+		{"too many requests", http.StatusTooManyRequests, nil, true},
+		{"service unavailable", http.StatusServiceUnavailable, nil, true},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var resp *http.Response
+			if testcase.err == nil {
+				resp = &http.Response{StatusCode: testcase.code}
+			}
+			if want, have := testcase.want, retry.Retryable(resp, testcase.err); want != have {
+				t.Errorf("want %v, have %v", want, have)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package rt_test
 
 import (
-	"bufio"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -13,9 +12,9 @@ import (
 	"testing"
 
 	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
 	"github.com/google/go-cmp/cmp"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func assertNoErr(t *testing.T, err error) {
@@ -44,7 +43,7 @@ func assertMetricOutput(t *testing.T, want, have map[string]float64) {
 		have,
 	} {
 		for k, v := range m {
-			if strings.Contains(k, "_last_successful_response{") {
+			if strings.Contains(k, "_last_successful_response{") || strings.Contains(k, "_circuit_state{") {
 				delete(m, k)
 				continue
 			}
@@ -191,6 +190,42 @@ func (c *countingRealtimeClient) Do(req *http.Request) (*http.Response, error) {
 	return fixedResponseClient{c.code, c.response}.Do(req)
 }
 
+type rateLimitedClient struct {
+	retryAfter string
+	served     uint64
+}
+
+func (c *rateLimitedClient) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddUint64(&(c.served), 1)
+	rec := httptest.NewRecorder()
+	if c.retryAfter != "" {
+		rec.Header().Set("Retry-After", c.retryAfter)
+	}
+	rec.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprint(rec, `{"msg":"Too Many Requests"}`)
+	return rec.Result(), nil
+}
+
+// sequenceClient serves its responses in order, and then blocks until the
+// request is canceled.
+type sequenceClient struct {
+	mtx       sync.Mutex
+	responses []fixedResponseClient
+}
+
+func (c *sequenceClient) Do(req *http.Request) (*http.Response, error) {
+	c.mtx.Lock()
+	if len(c.responses) == 0 {
+		c.mtx.Unlock()
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	next := c.responses[0]
+	c.responses = c.responses[1:]
+	c.mtx.Unlock()
+	return next.Do(req)
+}
+
 //
 //
 //
@@ -215,36 +250,7 @@ func (c fixedResponseClient) Do(req *http.Request) (*http.Response, error) {
 
 func prometheusOutput(t *testing.T, registry *prometheus.Registry, prefix string) map[string]float64 {
 	t.Helper()
-
-	server := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
-	defer server.Close()
-	resp, err := http.Get(server.URL)
-	assertNoErr(t, err)
-
-	selected := map[string]float64{}
-
-	s := bufio.NewScanner(resp.Body)
-	for s.Scan() {
-		line := s.Text()
-		if !strings.HasPrefix(line, prefix) {
-			continue
-		}
-
-		var (
-			fields = strings.Fields(line)
-			last   = len(fields) - 1
-			metric = strings.Join(fields[:last], " ")
-			value  = fields[len(fields)-1]
-			f, err = strconv.ParseFloat(value, 64)
-		)
-		if err != nil {
-			t.Fatalf("%s: %v", line, err)
-		}
-
-		selected[metric] = f
-	}
-
-	return selected
+	return fastlytest.Metrics(t, registry, prefix)
 }
 
 //
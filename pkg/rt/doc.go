@@ -1,4 +1,4 @@
 // Package rt provides an opinionated interface to rt.fastly.com. It consumes
-// the realtime and origin inspector APIs, and emits the data to Prometheus
-// metrics.
+// the endpoints of every registered product, e.g. the real-time and origin
+// inspector APIs, and emits the data to Prometheus metrics.
 package rt
@@ -0,0 +1,201 @@
+package rt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Limiter is a budget of requests to rt.fastly.com shared by subscribers, e.g.
+// all subscribers of all managers. It caps the number of requests in flight,
+// i.e. long-polls which haven't returned yet, and the rate at which requests
+// are started. Requests over budget wait in a queue per service, and the
+// queues are served round-robin, so that no service can starve the others.
+type Limiter struct {
+	maxInFlight int           // 0 is unlimited
+	interval    time.Duration // between request starts, 0 is unlimited
+
+	mtx         sync.Mutex
+	inFlight    int
+	next        time.Time                 // earliest start of the next request
+	queues      map[string][]*limitWaiter // by service ID
+	order       []string                  // service IDs with waiters, in round-robin order
+	timer       *time.Timer               // pending dispatch, once the rate permits
+	waitSeconds prometheus.Observer
+}
+
+type limitWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewLimiter returns a limiter which permits at most maxInFlight requests in
+// flight, and starts at most maxRate requests per second. A value of 0 for
+// either disables that limit.
+func NewLimiter(maxInFlight int, maxRate float64) *Limiter {
+	l := &Limiter{
+		maxInFlight: maxInFlight,
+		queues:      map[string][]*limitWaiter{},
+	}
+	if maxRate > 0 {
+		l.interval = time.Duration(float64(time.Second) / maxRate)
+	}
+	return l
+}
+
+// Acquire blocks until the limiter permits a request for the service, or the
+// context is canceled. Callers must invoke the returned release function once
+// the request has completed, i.e. its response body has been read.
+func (l *Limiter) Acquire(ctx context.Context, serviceID string) (release func(), err error) {
+	begin := time.Now()
+	w := &limitWaiter{ready: make(chan struct{})}
+
+	l.mtx.Lock()
+	if len(l.queues[serviceID]) == 0 {
+		l.order = append(l.order, serviceID)
+	}
+	l.queues[serviceID] = append(l.queues[serviceID], w)
+	l.dispatch(begin)
+	l.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		l.observe(time.Since(begin))
+		return l.release, nil
+
+	case <-ctx.Done():
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if w.granted { // raced with dispatch
+			l.inFlight--
+			l.dispatch(time.Now())
+		} else {
+			l.remove(serviceID, w)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.inFlight--
+	l.dispatch(time.Now())
+}
+
+// dispatch grants as many queued requests as the budget permits, taking one
+// from each service in turn. If only the rate prevents further grants, it
+// schedules itself for when the rate permits them. It must be called with the
+// mutex held.
+func (l *Limiter) dispatch(now time.Time) {
+	for len(l.order) > 0 {
+		if l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+			return
+		}
+		if l.interval > 0 && now.Before(l.next) {
+			if l.timer == nil {
+				l.timer = time.AfterFunc(l.next.Sub(now), func() {
+					l.mtx.Lock()
+					defer l.mtx.Unlock()
+					l.timer = nil
+					l.dispatch(time.Now())
+				})
+			}
+			return
+		}
+
+		serviceID := l.order[0]
+		queue := l.queues[serviceID]
+		w := queue[0]
+		l.order = l.order[1:]
+		if len(queue) > 1 {
+			l.queues[serviceID] = queue[1:]
+			l.order = append(l.order, serviceID) // back of the line
+		} else {
+			delete(l.queues, serviceID)
+		}
+
+		w.granted = true
+		close(w.ready)
+		l.inFlight++
+		if l.interval > 0 {
+			if l.next.Before(now) {
+				l.next = now
+			}
+			l.next = l.next.Add(l.interval)
+		}
+	}
+}
+
+// remove drops a waiter which gave up. It must be called with the mutex held.
+func (l *Limiter) remove(serviceID string, w *limitWaiter) {
+	queue := l.queues[serviceID]
+	for i, candidate := range queue {
+		if candidate == w {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		l.queues[serviceID] = queue
+		return
+	}
+	delete(l.queues, serviceID)
+	for i, candidate := range l.order {
+		if candidate == serviceID {
+			l.order = append(l.order[:i:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (l *Limiter) observe(wait time.Duration) {
+	l.mtx.Lock()
+	obs := l.waitSeconds
+	l.mtx.Unlock()
+	if obs != nil {
+		obs.Observe(wait.Seconds())
+	}
+}
+
+// Queued returns the number of requests waiting for the limiter.
+func (l *Limiter) Queued() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	var n int
+	for _, queue := range l.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// InFlight returns the number of requests permitted by the limiter which
+// haven't been released yet.
+func (l *Limiter) InFlight() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.inFlight
+}
+
+// Gatherer returns a Prometheus gatherer which yields the queue depth, the
+// requests in flight, and the time requests waited for the limiter.
+func (l *Limiter) Gatherer(namespace, subsystem string) (prometheus.Gatherer, error) {
+	var (
+		registry = prometheus.NewRegistry()
+		queued   = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Subsystem: subsystem, Name: "limiter_queued_requests", Help: "Number of real-time API requests waiting for the shared limiter."}, func() float64 { return float64(l.Queued()) })
+		inFlight = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Subsystem: subsystem, Name: "limiter_in_flight_requests", Help: "Number of real-time API requests in flight, as permitted by the shared limiter."}, func() float64 { return float64(l.InFlight()) })
+		wait     = prometheus.NewHistogram(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "limiter_wait_seconds", Help: "Time real-time API requests waited for the shared limiter.", Buckets: prometheus.ExponentialBuckets(0.01, 2, 12)})
+	)
+	for _, c := range []prometheus.Collector{queued, inFlight, wait} {
+		if err := registry.Register(c); err != nil {
+			return nil, fmt.Errorf("registering limiter collector: %w", err)
+		}
+	}
+	l.mtx.Lock()
+	l.waitSeconds = wait
+	l.mtx.Unlock()
+	return registry, nil
+}
@@ -0,0 +1,164 @@
+package rt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLimiterInFlight(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		limiter = rt.NewLimiter(1, 0)
+	)
+
+	release, err := limiter.Acquire(ctx, "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		release, _ := limiter.Acquire(ctx, "BBB")
+		acquired <- release
+	}()
+	waitForQueued(t, limiter, 1)
+
+	select {
+	case <-acquired:
+		t.Fatal("second request acquired while the first is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	(<-acquired)()
+
+	if want, have := 0, limiter.InFlight(); want != have {
+		t.Errorf("in flight: want %d, have %d", want, have)
+	}
+}
+
+func TestLimiterFairness(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		limiter = rt.NewLimiter(1, 0)
+		granted = make(chan string, 4)
+	)
+
+	release, err := limiter.Acquire(ctx, "busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, serviceID := range []string{"AAA", "AAA", "AAA", "BBB"} {
+		go func(serviceID string) {
+			release, err := limiter.Acquire(ctx, serviceID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			granted <- serviceID
+			release()
+		}(serviceID)
+		waitForQueued(t, limiter, i+1)
+	}
+
+	release()
+
+	var have []string
+	for i := 0; i < 4; i++ {
+		have = append(have, <-granted)
+	}
+	if want := []string{"AAA", "BBB", "AAA", "AAA"}; !cmp.Equal(want, have) {
+		t.Error(cmp.Diff(want, have))
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		limiter = rt.NewLimiter(0, 20) // every 50ms
+		begin   = time.Now()
+	)
+
+	for i := 0; i < 3; i++ {
+		release, err := limiter.Acquire(ctx, "AAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	if min, have := 100*time.Millisecond, time.Since(begin); have < min {
+		t.Errorf("3 requests at 20/s: want at least %s, took %s", min, have)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	t.Parallel()
+
+	limiter := rt.NewLimiter(1, 0)
+
+	release, err := limiter.Acquire(context.Background(), "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx, "BBB"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, have %v", context.DeadlineExceeded, err)
+	}
+
+	if want, have := 0, limiter.Queued(); want != have {
+		t.Errorf("queued: want %d, have %d", want, have)
+	}
+}
+
+func TestLimiterGatherer(t *testing.T) {
+	t.Parallel()
+
+	limiter := rt.NewLimiter(1, 0)
+	g, err := limiter.Gatherer("fastly", "rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := limiter.Acquire(context.Background(), "AAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		release, _ := limiter.Acquire(context.Background(), "BBB")
+		release()
+	}()
+	waitForQueued(t, limiter, 1)
+
+	fastlytest.AssertMetric(t, g, "fastly_rt_limiter_queued_requests", 1)
+	fastlytest.AssertMetric(t, g, "fastly_rt_limiter_in_flight_requests", 1)
+	fastlytest.AssertMetric(t, g, "fastly_rt_limiter_wait_seconds_count", 1)
+
+	release()
+}
+
+func waitForQueued(t *testing.T, limiter *rt.Limiter, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); limiter.Queued() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued: want %d, have %d", n, limiter.Queued())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -3,12 +3,18 @@ package rt
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/product"
 	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/retry"
+	"github.com/fastly/fastly-exporter/pkg/state"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // ServiceIdentifier is a consumer contract for a subscriber manager.
@@ -23,12 +29,34 @@ type HasAccesser interface {
 }
 
 // MetricsProvider is a consumer contract for a subscriber manager. It models
-// the method of the prom.Registry which yields a set of Prometheus metrics for
-// a specific service ID.
+// the methods of the prom.Registry which yield and remove a set of Prometheus
+// metrics for a specific service ID.
 type MetricsProvider interface {
 	MetricsFor(serviceID string) *prom.Metrics
+	Remove(serviceID string)
 }
 
+// ServiceConfig overrides the defaults of a manager for a single service.
+// The zero value keeps all of the defaults.
+type ServiceConfig struct {
+	// AggregateOnly, if set, overrides the WithAggregateOnly subscriber option.
+	AggregateOnly *bool
+
+	// Products, if set, restricts the products polled for the service. Products
+	// which the account isn't entitled to are never polled.
+	Products []string
+}
+
+// ServiceConfigProvider is a consumer contract for a subscriber manager. It
+// yields the configuration of a specific service.
+type ServiceConfigProvider interface {
+	ServiceConfig(serviceID string) ServiceConfig
+}
+
+type nopServiceConfigProvider struct{}
+
+func (nopServiceConfigProvider) ServiceConfig(string) ServiceConfig { return ServiceConfig{} }
+
 type subscriberKey struct {
 	serviceID string
 	product   string
@@ -36,7 +64,12 @@ type subscriberKey struct {
 
 // Manager owns a set of subscribers. On refresh, it asks the ServiceIdentifier
 // for a set of service IDs that should be active, and manages the lifecycles of
-// the corresponding subscribers.
+// the corresponding subscribers. Subscribers which terminate prematurely are
+// restarted right away, with backoff, rather than on the next refresh.
+//
+// Operators can override the ServiceIdentifier at runtime: services can be
+// added, and the products of services paused. Overrides are kept in memory,
+// and take effect on the next refresh.
 type Manager struct {
 	ids               ServiceIdentifier
 	client            HTTPClient
@@ -45,17 +78,87 @@ type Manager struct {
 	subscriberOptions []SubscriberOption
 	productCache      HasAccesser
 	logger            log.Logger
+	metricsExpiry     time.Duration
+	serviceConfig     ServiceConfigProvider
+	restartPolicy     retry.Policy
+
+	mtx      sync.RWMutex
+	managed  map[subscriberKey]interrupt
+	services map[string]struct{}        // service IDs seen in the latest refresh
+	removed  map[string]time.Time       // service IDs that have disappeared, and when
+	progress map[subscriberKey]uint64   // latest second processed by subscribers which aren't running
+	added    map[string]struct{}        // service IDs added via AddService
+	paused   map[subscriberKey]struct{} // via Pause; an empty product pauses every product of the service
+	idle     map[subscriberKey]struct{} // subscribers not running since the latest refresh, because they're paused
+
+	stallWindow time.Duration
+
+	statsMtx sync.Mutex
+	restarts map[string]int // by product
+	stalls   map[string]int // by product
+}
+
+// ManagerStatus summarizes the state of the subscribers of a manager.
+type ManagerStatus struct {
+	// Running is the number of subscribers which are running.
+	Running int
+
+	// Failing is the number of running subscribers whose most recent request
+	// to rt.fastly.com failed.
+	Failing int
+
+	// Terminated is the number of subscribers which terminated prematurely,
+	// and are waiting to be restarted.
+	Terminated int
+
+	// Restarts is the number of times subscribers were restarted after
+	// terminating prematurely, over the lifetime of the manager.
+	Restarts int
+}
+
+// ManagerOption provides some additional behavior to a manager.
+type ManagerOption func(*Manager)
+
+// WithMetricsExpiry sets how long the manager keeps the metrics of a service
+// after that service disappears from the ServiceIdentifier. Once the period has
+// elapsed, the metrics are removed from the MetricsProvider, and are no longer
+// exported. Expiry is evaluated on each Refresh, so the effective period is
+// rounded up to the refresh interval. By default, metrics are removed as soon
+// as the service disappears.
+func WithMetricsExpiry(d time.Duration) ManagerOption {
+	return func(m *Manager) { m.metricsExpiry = d }
+}
+
+// WithServiceConfig sets the provider of per-service configuration, which is
+// consulted whenever subscribers are created. By default, every service uses
+// the defaults of the manager.
+func WithServiceConfig(p ServiceConfigProvider) ManagerOption {
+	return func(m *Manager) { m.serviceConfig = p }
+}
+
+// WithRestartPolicy sets the backoff between restarts of a subscriber which
+// terminated prematurely. Consecutive restarts back off further, unless the
+// subscriber ran for at least a minute in between. By default,
+// retry.DefaultPolicy is used.
+func WithRestartPolicy(p retry.Policy) ManagerOption {
+	return func(m *Manager) { m.restartPolicy = p }
+}
 
-	mtx     sync.RWMutex
-	managed map[subscriberKey]interrupt
+// WithStallWindow enables the watchdog, see RestartStalled, and sets how long
+// a subscriber may go without advancing before it's considered stalled. The
+// window should be comfortably longer than the timeout of the HTTP client, and
+// than the delays after responses without data. By default, the watchdog is
+// disabled.
+func WithStallWindow(d time.Duration) ManagerOption {
+	return func(m *Manager) { m.stallWindow = d }
 }
 
 // NewManager returns a usable manager. Callers should invoke Refresh on a
 // regular schedule to keep the set of managed subscribers up-to-date. The HTTP
 // client, token, metrics, and subscriber options parameters are passed thru to
 // constructed subscribers.
-func NewManager(ids ServiceIdentifier, client HTTPClient, token string, metrics MetricsProvider, subscriberOptions []SubscriberOption, productCache HasAccesser, logger log.Logger) *Manager {
-	return &Manager{
+func NewManager(ids ServiceIdentifier, client HTTPClient, token string, metrics MetricsProvider, subscriberOptions []SubscriberOption, productCache HasAccesser, logger log.Logger, options ...ManagerOption) *Manager {
+	m := &Manager{
 		ids:               ids,
 		client:            client,
 		token:             token,
@@ -63,9 +166,22 @@ func NewManager(ids ServiceIdentifier, client HTTPClient, token string, metrics
 		subscriberOptions: subscriberOptions,
 		productCache:      productCache,
 		logger:            logger,
+		serviceConfig:     nopServiceConfigProvider{},
 
-		managed: map[subscriberKey]interrupt{},
+		managed:  map[subscriberKey]interrupt{},
+		services: map[string]struct{}{},
+		removed:  map[string]time.Time{},
+		progress: map[subscriberKey]uint64{},
+		added:    map[string]struct{}{},
+		paused:   map[subscriberKey]struct{}{},
+		idle:     map[subscriberKey]struct{}{},
+		restarts: map[string]int{},
+		stalls:   map[string]int{},
 	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
 }
 
 // Refresh the set of subscribers managed by the manager, by asking the
@@ -75,54 +191,239 @@ func NewManager(ids ServiceIdentifier, client HTTPClient, token string, metrics
 // previously managed but isn't in the latest set of IDs, terminate the
 // subscriber. Finally, if a service ID was both previously managed and is in
 // the latest set of IDs, simply keep the existing subscriber.
+//
+// Services added via AddService are managed as if the authority provided
+// them, and paused subscribers are stopped, or not started.
+//
+// Services that are no longer provided by the authority have their metrics
+// removed from the MetricsProvider, after the expiry period set by
+// WithMetricsExpiry. The metrics of paused services are kept.
 func (m *Manager) Refresh() {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 
+	ids := m.serviceIDs()
+
+	configs := make(map[string]ServiceConfig, len(ids))
+	for _, id := range ids {
+		configs[id] = m.serviceConfig.ServiceConfig(id)
+	}
+
 	nextgen := map[subscriberKey]interrupt{}
-	for _, product := range api.Products {
-		if m.productCache.HasAccess(product) {
-			for _, id := range m.ids.ServiceIDs() {
-				key := subscriberKey{serviceID: id, product: product}
+	m.idle = map[subscriberKey]struct{}{}
+	for _, p := range product.All() {
+		name := p.Name()
+		if m.productCache.HasAccess(name) {
+			for _, id := range ids {
+				config := configs[id]
+				if config.Products != nil && !contains(config.Products, name) {
+					continue
+				}
+
+				key := subscriberKey{serviceID: id, product: name}
+				if m.isPaused(key) {
+					m.idle[key] = struct{}{}
+					continue // stopped below, if it's running
+				}
 
 				if irq, ok := m.managed[key]; ok {
-					level.Debug(m.logger).Log("service_id", id, "type", product, "subscriber", "maintain")
+					level.Debug(m.logger).Log("service_id", id, "type", name, "subscriber", "maintain")
 					nextgen[key] = irq // move
 					delete(m.managed, key)
 				} else {
-					level.Info(m.logger).Log("service_id", id, "type", product, "subscriber", "create")
-					nextgen[key] = m.spawn(id, product)
+					level.Info(m.logger).Log("service_id", id, "type", name, "subscriber", "create")
+					nextgen[key] = m.spawn(id, p, config)
 				}
 			}
 		}
 
 		for key, irq := range m.managed {
-			if key.product != product {
+			if key.product != name {
 				continue
 			}
 
 			level.Info(m.logger).Log("service_id", key.serviceID, "type", key.product, "subscriber", "stop")
 			irq.cancel()
 			err := <-irq.done
+			m.keepProgress(key, irq.subscriber)
 			delete(m.managed, key)
 			level.Debug(m.logger).Log("service_id", key.serviceID, "type", key.product, "interrupt", err)
 		}
+	}
 
-		for key, irq := range nextgen {
-			if key.product != product {
-				continue
-			}
+	m.managed = nextgen
+	m.expire(ids)
+}
 
-			select {
-			default: // still running (good)
-			case err := <-irq.done: // exited (bad)
-				level.Error(m.logger).Log("service_id", key.serviceID, "type", key.product, "interrupt", err, "err", "premature termination", "msg", "will attempt to reconnect on next refresh")
-				delete(nextgen, key)
+// serviceIDs returns the service IDs of the authority, and those added via
+// AddService. It must be called with the mutex held.
+func (m *Manager) serviceIDs() []string {
+	ids := m.ids.ServiceIDs()
+	if len(m.added) == 0 {
+		return ids
+	}
+
+	all := make(map[string]struct{}, len(ids)+len(m.added))
+	for _, id := range ids {
+		all[id] = struct{}{}
+	}
+	for id := range m.added {
+		all[id] = struct{}{}
+	}
+	ids = make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// isPaused returns true if the subscriber is paused, by product or for the
+// whole service. It must be called with the mutex held.
+func (m *Manager) isPaused(key subscriberKey) bool {
+	_, byProduct := m.paused[key]
+	_, byService := m.paused[subscriberKey{serviceID: key.serviceID}]
+	return byProduct || byService
+}
+
+// AddService adds the service ID to those provided by the authority, e.g. to
+// poll a service which is excluded by the service filters. It returns false if
+// the service was already added.
+func (m *Manager) AddService(serviceID string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.added[serviceID]; ok {
+		return false
+	}
+	m.added[serviceID] = struct{}{}
+	return true
+}
+
+// RemoveService removes a service ID added via AddService. The service keeps
+// being managed if the authority provides it. It returns false if the service
+// wasn't added.
+func (m *Manager) RemoveService(serviceID string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.added[serviceID]; !ok {
+		return false
+	}
+	delete(m.added, serviceID)
+	return true
+}
+
+// Pause stops polling the product of the service until it's resumed. An empty
+// product pauses every product of the service.
+func (m *Manager) Pause(serviceID, product string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.paused[subscriberKey{serviceID: serviceID, product: product}] = struct{}{}
+}
+
+// Resume undoes Pause of the product of the service. An empty product resumes
+// every product of the service, including those paused individually. It
+// returns false if nothing was paused.
+func (m *Manager) Resume(serviceID, product string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var resumed bool
+	for key := range m.paused {
+		if key.serviceID == serviceID && (product == "" || key.product == product) {
+			delete(m.paused, key)
+			resumed = true
+		}
+	}
+	return resumed
+}
+
+// SubscriberInfo describes a subscriber of a manager.
+type SubscriberInfo struct {
+	ServiceID string
+	Product   string
+
+	// State is running, failing, restarting, or paused.
+	State string
+
+	// Added is true if the service was added via AddService.
+	Added bool
+
+	// LastAdvance is when the subscriber last processed newer data. It's zero
+	// for paused subscribers.
+	LastAdvance time.Time
+}
+
+// Subscribers returns the managed subscribers, and the paused ones, as of the
+// latest refresh, ordered by service ID and product.
+func (m *Manager) Subscribers() []SubscriberInfo {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	subscribers := make([]SubscriberInfo, 0, len(m.managed)+len(m.idle))
+	for key, irq := range m.managed {
+		_, added := m.added[key.serviceID]
+		subscribers = append(subscribers, SubscriberInfo{
+			ServiceID:   key.serviceID,
+			Product:     key.product,
+			State:       stateOf(irq),
+			Added:       added,
+			LastAdvance: irq.subscriber.LastAdvance(),
+		})
+	}
+	for key := range m.idle {
+		_, added := m.added[key.serviceID]
+		subscribers = append(subscribers, SubscriberInfo{
+			ServiceID: key.serviceID,
+			Product:   key.product,
+			State:     subscriberPaused,
+			Added:     added,
+		})
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		if subscribers[i].ServiceID != subscribers[j].ServiceID {
+			return subscribers[i].ServiceID < subscribers[j].ServiceID
+		}
+		return subscribers[i].Product < subscribers[j].Product
+	})
+	return subscribers
+}
+
+// expire tracks services which have disappeared from the authority, and
+// removes their metrics once they've been gone for longer than the expiry
+// period. Subscribers for those services must already have been stopped.
+func (m *Manager) expire(ids []string) {
+	now := time.Now()
+
+	current := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		current[id] = struct{}{}
+		delete(m.removed, id) // came back before expiry
+	}
+
+	for id := range m.services {
+		if _, ok := current[id]; !ok {
+			m.removed[id] = now
+		}
+	}
+
+	for id, since := range m.removed {
+		if now.Sub(since) < m.metricsExpiry {
+			continue
+		}
+		level.Info(m.logger).Log("service_id", id, "metrics", "remove")
+		m.metrics.Remove(id)
+		delete(m.removed, id)
+		for key := range m.progress {
+			if key.serviceID == id {
+				delete(m.progress, key)
 			}
 		}
 	}
 
-	m.managed = nextgen
+	m.services = current
 }
 
 // Active returns the set of service IDs currently being managed.
@@ -137,6 +438,26 @@ func (m *Manager) Active() []string {
 	return serviceIDs
 }
 
+// Status returns a summary of the state of the managed subscribers.
+func (m *Manager) Status() ManagerStatus {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	status := ManagerStatus{Restarts: m.restartCount()}
+	for _, irq := range m.managed {
+		switch {
+		case irq.supervision.restarting.Load():
+			status.Terminated++
+		case irq.subscriber.Failing():
+			status.Running++
+			status.Failing++
+		default:
+			status.Running++
+		}
+	}
+	return status
+}
+
 // StopAll terminates and cleans up all active subscribers.
 func (m *Manager) StopAll() {
 	m.mtx.Lock()
@@ -149,29 +470,299 @@ func (m *Manager) StopAll() {
 			err := <-irq.done
 			level.Debug(m.logger).Log("service_id", key.serviceID, "goroutine", i+1, "of", cap(irq.done), "interrupt", err)
 		}
+		m.keepProgress(key, irq.subscriber)
 		delete(m.managed, key)
 	}
 }
 
-func (m *Manager) spawn(serviceID string, product string) interrupt {
+// Progress returns the latest second of real-time data processed for every
+// product of every service, including those of subscribers which were stopped,
+// as long as the metrics of their service haven't been removed. Stop all
+// subscribers first for the progress to be consistent with the metrics.
+func (m *Manager) Progress() []state.Progress {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	progress := make([]state.Progress, 0, len(m.progress)+len(m.managed))
+	for key, recorded := range m.progress {
+		if _, ok := m.managed[key]; !ok {
+			progress = append(progress, state.Progress{ServiceID: key.serviceID, Product: key.product, Recorded: recorded})
+		}
+	}
+	for key, irq := range m.managed {
+		if recorded := irq.subscriber.Processed(key.product); recorded > 0 {
+			progress = append(progress, state.Progress{ServiceID: key.serviceID, Product: key.product, Recorded: recorded})
+		}
+	}
+	return progress
+}
+
+// RestoreProgress marks the seconds of real-time data up to the given progress
+// as processed, e.g. as persisted by a previous exporter, so that subscribers
+// created afterwards don't count them again. The account of the progress is
+// ignored. Call it before the first refresh.
+func (m *Manager) RestoreProgress(progress []state.Progress) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, p := range progress {
+		key := subscriberKey{serviceID: p.ServiceID, product: p.Product}
+		if p.Recorded > m.progress[key] {
+			m.progress[key] = p.Recorded
+		}
+	}
+}
+
+// keepProgress records the progress of a subscriber which was stopped. It must
+// be called with the mutex held.
+func (m *Manager) keepProgress(key subscriberKey, subscriber *Subscriber) {
+	if recorded := subscriber.Processed(key.product); recorded > 0 {
+		m.progress[key] = recorded
+	}
+}
+
+func (m *Manager) spawn(serviceID string, p product.Product, config ServiceConfig) interrupt {
+	options := m.subscriberOptions[:len(m.subscriberOptions):len(m.subscriberOptions)]
+	if config.AggregateOnly != nil {
+		options = append(options, WithAggregateOnly(*config.AggregateOnly))
+	}
+	if recorded, ok := m.progress[subscriberKey{serviceID: serviceID, product: p.Name()}]; ok {
+		options = append(options, WithProcessed(p.Name(), recorded))
+	}
+
 	var (
-		subscriber  = NewSubscriber(m.client, m.token, serviceID, m.metrics.MetricsFor(serviceID), m.subscriberOptions...)
+		subscriber  = NewSubscriber(m.client, m.token, serviceID, m.metrics.MetricsFor(serviceID), options...)
 		ctx, cancel = context.WithCancel(context.Background())
 		done        = make(chan error, 1)
+		sv          = &supervision{}
 	)
-	switch product {
-	case api.ProductOriginInspector:
-		go func() { done <- fmt.Errorf("origins: %w", subscriber.RunOrigins(ctx)) }()
-	case api.ProductDomainInspector:
-		go func() { done <- fmt.Errorf("domains: %w", subscriber.RunDomains(ctx)) }()
-	default:
-		go func() { done <- fmt.Errorf("realtime: %w", subscriber.RunRealtime(ctx)) }()
+	go func() { done <- fmt.Errorf("%s: %w", p.Name(), m.supervise(ctx, serviceID, p, subscriber, sv)) }()
+
+	return interrupt{cancel, done, subscriber, sv}
+}
+
+// healthyRun is how long a subscriber must run before it terminates for its
+// restart not to back off further.
+const healthyRun = time.Minute
+
+// supervise runs the subscriber until the context is canceled. Whenever the
+// subscriber terminates prematurely, it's restarted after a backoff, during
+// which it's marked as restarting. Runs stopped by the watchdog are restarted
+// right away.
+func (m *Manager) supervise(ctx context.Context, serviceID string, p product.Product, subscriber *Subscriber, sv *supervision) error {
+	var failures int
+	for {
+		begin := time.Now()
+		runCtx, stop := context.WithCancel(ctx)
+		sv.started(stop)
+		err := subscriber.Run(runCtx, p)
+		stop()
+		if ctx.Err() != nil {
+			return err
+		}
+
+		m.countRestart(p.Name())
+		if sv.stalled() {
+			level.Info(m.logger).Log("service_id", serviceID, "type", p.Name(), "subscriber", "restart", "reason", "stalled")
+			continue
+		}
+
+		if time.Since(begin) >= healthyRun {
+			failures = 0
+		}
+		failures++
+		delay := m.restartPolicy.Backoff(failures)
+		level.Error(m.logger).Log("service_id", serviceID, "type", p.Name(), "err", err, "msg", "premature termination, will restart", "delay", delay)
+
+		sv.restarting.Store(true)
+		contextSleep(ctx, delay)
+		sv.restarting.Store(false)
+		level.Info(m.logger).Log("service_id", serviceID, "type", p.Name(), "subscriber", "restart")
 	}
+}
+
+// RestartStalled is the watchdog of the manager. It stops the current run of
+// every subscriber which hasn't advanced within the stall window, so that its
+// supervisor restarts it. Subscribers which are failing or already restarting
+// are left to their backoff. Call it regularly, e.g. a few times per window.
+// It returns the number of stalled subscribers, and does nothing unless a
+// window was set via WithStallWindow.
+func (m *Manager) RestartStalled() int {
+	if m.stallWindow <= 0 {
+		return 0
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	var (
+		now = time.Now()
+		n   int
+	)
+	for key, irq := range m.managed {
+		if irq.supervision.restarting.Load() || irq.subscriber.Failing() {
+			continue
+		}
+		since := now.Sub(irq.subscriber.LastAdvance())
+		if since < m.stallWindow || !irq.supervision.stall() {
+			continue
+		}
+		level.Warn(m.logger).Log("service_id", key.serviceID, "type", key.product, "subscriber", "stalled", "since", since.Round(time.Second), "msg", "restarting")
+		m.statsMtx.Lock()
+		m.stalls[key.product]++
+		m.statsMtx.Unlock()
+		n++
+	}
+	return n
+}
 
-	return interrupt{cancel, done}
+func (m *Manager) countRestart(product string) {
+	m.statsMtx.Lock()
+	defer m.statsMtx.Unlock()
+	m.restarts[product]++
+}
+
+func (m *Manager) restartCount() int {
+	m.statsMtx.Lock()
+	defer m.statsMtx.Unlock()
+	var n int
+	for _, count := range m.restarts {
+		n += count
+	}
+	return n
 }
 
 type interrupt struct {
-	cancel func()
-	done   <-chan error
+	cancel      func()
+	done        <-chan error
+	subscriber  *Subscriber
+	supervision *supervision
+}
+
+// supervision is the state of a supervised subscriber, shared by its
+// supervisor and the watchdog.
+type supervision struct {
+	restarting atomic.Bool
+
+	mtx       sync.Mutex
+	stop      context.CancelFunc // of the current run
+	isStalled bool               // the current run was stopped by the watchdog
+}
+
+func (sv *supervision) started(stop context.CancelFunc) {
+	sv.mtx.Lock()
+	defer sv.mtx.Unlock()
+	sv.stop, sv.isStalled = stop, false
+}
+
+// stall stops the current run, unless it was already stopped as stalled.
+func (sv *supervision) stall() bool {
+	sv.mtx.Lock()
+	defer sv.mtx.Unlock()
+	if sv.isStalled || sv.stop == nil {
+		return false
+	}
+	sv.isStalled = true
+	sv.stop()
+	return true
+}
+
+func (sv *supervision) stalled() bool {
+	sv.mtx.Lock()
+	defer sv.mtx.Unlock()
+	return sv.isStalled
+}
+
+func contains(list []string, s string) bool {
+	for _, candidate := range list {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
+
+//
+//
+//
+
+// Subscriber states, as exported by the collector of a manager.
+const (
+	subscriberRunning    = "running"
+	subscriberFailing    = "failing"
+	subscriberRestarting = "restarting"
+	subscriberPaused     = "paused"
+)
+
+// stateOf returns the state of a managed subscriber.
+func stateOf(irq interrupt) string {
+	switch {
+	case irq.supervision.restarting.Load():
+		return subscriberRestarting
+	case irq.subscriber.Failing():
+		return subscriberFailing
+	default:
+		return subscriberRunning
+	}
+}
+
+// Collector returns a Prometheus collector of the restarts and stalls of the
+// manager's subscribers, and of the number of subscribers in each state, by product.
+// Wrap the registerer with e.g. an account label to register the collectors
+// of several managers.
+func (m *Manager) Collector(namespace, subsystem string) prometheus.Collector {
+	return &managerCollector{
+		manager:         m,
+		restartsDesc:    prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "subscriber_restarts_total"), "Number of times real-time subscribers were restarted after terminating prematurely, or stalling.", []string{"product"}, nil),
+		stallsDesc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "subscriber_stalls_total"), "Number of times the watchdog found real-time subscribers which hadn't advanced within the stall window.", []string{"product"}, nil),
+		subscribersDesc: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "subscribers"), "Number of real-time subscribers in each state: running, failing, restarting, or paused.", []string{"product", "state"}, nil),
+	}
+}
+
+type managerCollector struct {
+	manager         *Manager
+	restartsDesc    *prometheus.Desc
+	stallsDesc      *prometheus.Desc
+	subscribersDesc *prometheus.Desc
+}
+
+func (c *managerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.restartsDesc
+	ch <- c.stallsDesc
+	ch <- c.subscribersDesc
+}
+
+func (c *managerCollector) Collect(ch chan<- prometheus.Metric) {
+	m := c.manager
+
+	m.statsMtx.Lock()
+	for name, n := range m.restarts {
+		ch <- prometheus.MustNewConstMetric(c.restartsDesc, prometheus.CounterValue, float64(n), name)
+	}
+	for name, n := range m.stalls {
+		ch <- prometheus.MustNewConstMetric(c.stallsDesc, prometheus.CounterValue, float64(n), name)
+	}
+	m.statsMtx.Unlock()
+
+	m.mtx.RLock()
+	states := map[string]map[string]int{}
+	count := func(product, state string) {
+		if states[product] == nil {
+			states[product] = map[string]int{subscriberRunning: 0, subscriberFailing: 0, subscriberRestarting: 0, subscriberPaused: 0}
+		}
+		states[product][state]++
+	}
+	for key, irq := range m.managed {
+		count(key.product, stateOf(irq))
+	}
+	for key := range m.idle {
+		count(key.product, subscriberPaused)
+	}
+	m.mtx.RUnlock()
+
+	for name, byState := range states {
+		for state, n := range byState {
+			ch <- prometheus.MustNewConstMetric(c.subscribersDesc, prometheus.GaugeValue, float64(n), name, state)
+		}
+	}
 }
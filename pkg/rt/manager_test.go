@@ -2,17 +2,25 @@ package rt_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
 	"github.com/fastly/fastly-exporter/pkg/filter"
 	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/retry"
 	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/fastly/fastly-exporter/pkg/state"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestManager(t *testing.T) {
@@ -86,12 +94,17 @@ func TestManager(t *testing.T) {
 		`level=info service_id=2f2f2f type=default subscriber=create`,
 		`level=info service_id=3a3b3c type=default subscriber=create`,
 		`level=info service_id=101010 type=default subscriber=stop`,
+		`level=info service_id=101010 metrics=remove`,
 		`level=info service_id=3a3b3c type=default subscriber=stop`,
+		`level=info service_id=3a3b3c metrics=remove`,
 		`level=info service_id=2f2f2f type=default subscriber=stop`,
+		`level=info service_id=2f2f2f metrics=remove`,
 		`level=info service_id=2f2f2f type=default subscriber=create`,
 		`level=info service_id=3a3b3c type=default subscriber=create`,
 		`level=info service_id=2f2f2f type=default subscriber=stop`,
 		`level=info service_id=3a3b3c type=default subscriber=stop`,
+		`level=info service_id=2f2f2f metrics=remove`,
+		`level=info service_id=3a3b3c metrics=remove`,
 		`level=info service_id=101010 type=default subscriber=create`,
 		`level=info service_id=101010 type=origin_inspector subscriber=create`,
 		`level=info service_id=101010 type=domain_inspector subscriber=create`,
@@ -108,8 +121,329 @@ func TestManager(t *testing.T) {
 	}
 }
 
+func TestManagerMetricsExpiry(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		s2       = api.Service{ID: "2f2f2f", Name: "service 2", Version: 2}
+		client   = newMockRealtimeClient(`{}`)
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache)}
+		products = newMockProductCache()
+		expiry   = 50 * time.Millisecond
+		manager  = rt.NewManager(cache, client, "irrelevant-token", registry, options, products, log.NewNopLogger(), rt.WithMetricsExpiry(expiry))
+	)
+
+	products.update(api.ProductOriginInspector, false)
+	products.update(api.ProductDomainInspector, false)
+
+	cache.update([]api.Service{s1, s2})
+	manager.Refresh() // create s1, create s2
+	assertStringSliceEqual(t, []string{s1.ID, s2.ID}, serviceDiscoveryTargets(t, registry))
+
+	cache.update([]api.Service{s2})
+	manager.Refresh() // stop s1, but keep its metrics
+	assertStringSliceEqual(t, []string{s2.ID}, sortedServiceIDs(manager))
+	assertStringSliceEqual(t, []string{s1.ID, s2.ID}, serviceDiscoveryTargets(t, registry))
+
+	time.Sleep(2 * expiry)
+	manager.Refresh() // remove s1 metrics
+	assertStringSliceEqual(t, []string{s2.ID}, serviceDiscoveryTargets(t, registry))
+
+	cache.update([]api.Service{})
+	manager.Refresh() // stop s2, but keep its metrics
+	cache.update([]api.Service{s2})
+	time.Sleep(2 * expiry)
+	manager.Refresh() // create s2 again, before its metrics were removed
+	assertStringSliceEqual(t, []string{s2.ID}, serviceDiscoveryTargets(t, registry))
+
+	manager.StopAll()
+}
+
+func TestManagerServiceConfig(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		s2       = api.Service{ID: "2f2f2f", Name: "service 2", Version: 2}
+		client   = newMockRealtimeClient(`{}`)
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache)}
+		products = newMockProductCache()
+		configs  = serviceConfigs{s1.ID: {Products: []string{api.ProductDefault, api.ProductDomainInspector}}}
+		manager  = rt.NewManager(cache, client, "irrelevant-token", registry, options, products, log.NewNopLogger(), rt.WithServiceConfig(configs))
+	)
+
+	products.update(api.ProductOriginInspector, true)
+	products.update(api.ProductDomainInspector, false)
+
+	cache.update([]api.Service{s1, s2})
+	manager.Refresh() // create s1 default, create s2 default and origins
+	assertStringSliceEqual(t, []string{s1.ID, s2.ID, s2.ID}, sortedServiceIDs(manager))
+
+	products.update(api.ProductDomainInspector, true)
+	manager.Refresh() // create s1 domains, create s2 domains
+	assertStringSliceEqual(t, []string{s1.ID, s1.ID, s2.ID, s2.ID, s2.ID}, sortedServiceIDs(manager))
+
+	manager.StopAll()
+}
+
+func TestManagerStatus(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		client   = fixedResponseClient{code: http.StatusUnauthorized, response: `{}`}
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache)}
+		products = newMockProductCache()
+		manager  = rt.NewManager(cache, client, "invalid-token", registry, options, products, log.NewNopLogger())
+	)
+
+	products.update(api.ProductOriginInspector, false)
+	products.update(api.ProductDomainInspector, false)
+
+	if want, have := (rt.ManagerStatus{}), manager.Status(); want != have {
+		t.Fatalf("want %+v, have %+v", want, have)
+	}
+
+	cache.update([]api.Service{s1})
+	manager.Refresh() // create s1, which fails with 401
+
+	want := rt.ManagerStatus{Running: 1, Failing: 1}
+	deadline := time.Now().Add(time.Second)
+	for manager.Status() != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if have := manager.Status(); want != have {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	manager.StopAll()
+}
+
+func TestManagerRestart(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		client   = fixedResponseClient{code: http.StatusOK, response: `{}`}
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache), rt.WithBaseURL("http://bad host")} // every request fails to construct
+		products = newMockProductCache()
+		policy   = retry.Policy{Base: 100 * time.Millisecond, Max: 100 * time.Millisecond}
+		manager  = rt.NewManager(cache, client, "token", registry, options, products, log.NewNopLogger(), rt.WithRestartPolicy(policy))
+	)
+	defer manager.StopAll()
+
+	products.update(api.ProductOriginInspector, false)
+	products.update(api.ProductDomainInspector, false)
+	cache.update([]api.Service{s1})
+	manager.Refresh()
+
+	deadline := time.Now().Add(time.Second)
+	for manager.Status().Restarts < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := manager.Status()
+	if status.Restarts < 2 {
+		t.Fatalf("restarts: want at least 2 without a refresh, have %d", status.Restarts)
+	}
+	if want, have := 1, status.Running+status.Terminated; want != have {
+		t.Errorf("subscribers: want %d, have %+v", want, status)
+	}
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(manager.Collector("fastly", "rt"))
+	have := fastlytest.Metrics(t, metrics, "fastly_rt_")
+	if restarts := have[`fastly_rt_subscriber_restarts_total{product="default"}`]; restarts < 2 {
+		t.Errorf("restarts metric: want at least 2, have %v", restarts)
+	}
+	var subscribers float64
+	for _, state := range []string{"running", "failing", "restarting"} {
+		subscribers += have[`fastly_rt_subscribers{product="default",state="`+state+`"}`]
+	}
+	if want := 1.0; want != subscribers {
+		t.Errorf("subscribers metric: want %v, have %v (%v)", want, subscribers, have)
+	}
+}
+
+func TestManagerOverrides(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		s2       = api.Service{ID: "2f2f2f", Name: "service 2", Version: 2}
+		client   = newMockRealtimeClient(`{}`)
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache)}
+		products = newMockProductCache()
+		manager  = rt.NewManager(cache, client, "irrelevant-token", registry, options, products, log.NewNopLogger())
+	)
+	defer manager.StopAll()
+
+	products.update(api.ProductOriginInspector, true)
+	products.update(api.ProductDomainInspector, false)
+
+	cache.update([]api.Service{s1})
+	if !manager.AddService(s2.ID) {
+		t.Errorf("AddService: want true, have false")
+	}
+	manager.Refresh() // create s1 and s2, default and origins
+	assertStringSliceEqual(t, []string{s1.ID, s1.ID, s2.ID, s2.ID}, sortedServiceIDs(manager))
+	assertStringSliceEqual(t, []string{s1.ID, s2.ID}, serviceDiscoveryTargets(t, registry))
+
+	manager.Pause(s1.ID, api.ProductOriginInspector)
+	manager.Pause(s2.ID, "")
+	manager.Refresh() // stop s1 origins, stop s2, but keep their metrics
+	assertStringSliceEqual(t, []string{s1.ID}, sortedServiceIDs(manager))
+	assertStringSliceEqual(t, []string{s1.ID, s2.ID}, serviceDiscoveryTargets(t, registry))
+
+	states := map[string]string{}
+	for _, s := range manager.Subscribers() {
+		states[s.ServiceID+" "+s.Product] = s.State
+		if want, have := s.ServiceID == s2.ID, s.Added; want != have {
+			t.Errorf("%s %s: added: want %v, have %v", s.ServiceID, s.Product, want, have)
+		}
+	}
+	want := map[string]string{
+		s1.ID + " " + api.ProductDefault:         "running",
+		s1.ID + " " + api.ProductOriginInspector: "paused",
+		s2.ID + " " + api.ProductDefault:         "paused",
+		s2.ID + " " + api.ProductOriginInspector: "paused",
+	}
+	if !cmp.Equal(want, states) {
+		t.Error(cmp.Diff(want, states))
+	}
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(manager.Collector("fastly", "rt"))
+	have := fastlytest.Metrics(t, metrics, "fastly_rt_subscribers")
+	if want, have := 2.0, have[`fastly_rt_subscribers{product="origin_inspector",state="paused"}`]; want != have {
+		t.Errorf("paused origin inspector subscribers: want %v, have %v", want, have)
+	}
+
+	if manager.Resume(s2.ID, api.ProductDefault) {
+		t.Errorf("Resume of a product of a paused service: want false, have true")
+	}
+	if !manager.Resume(s2.ID, "") {
+		t.Errorf("Resume: want true, have false")
+	}
+	if manager.Resume(s2.ID, "") {
+		t.Errorf("Resume after resume: want false, have true")
+	}
+	manager.Resume(s1.ID, "")
+	manager.Refresh() // create s1 origins, and s2 default and origins
+	assertStringSliceEqual(t, []string{s1.ID, s1.ID, s2.ID, s2.ID}, sortedServiceIDs(manager))
+
+	if !manager.RemoveService(s2.ID) {
+		t.Errorf("RemoveService: want true, have false")
+	}
+	if manager.RemoveService(s1.ID) {
+		t.Errorf("RemoveService of a service which wasn't added: want false, have true")
+	}
+	manager.Refresh() // stop s2, and remove its metrics
+	assertStringSliceEqual(t, []string{s1.ID, s1.ID}, sortedServiceIDs(manager))
+	assertStringSliceEqual(t, []string{s1.ID}, serviceDiscoveryTargets(t, registry))
+}
+
+type serviceConfigs map[string]rt.ServiceConfig
+
+func (c serviceConfigs) ServiceConfig(serviceID string) rt.ServiceConfig { return c[serviceID] }
+
+func serviceDiscoveryTargets(t *testing.T, registry *prom.Registry) []string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/sd", nil))
+
+	var response []struct {
+		Targets []string `json:"targets"`
+	}
+	assertNoErr(t, json.NewDecoder(rec.Body).Decode(&response))
+
+	targets := []string{}
+	for _, group := range response {
+		targets = append(targets, group.Targets...)
+	}
+	return targets
+}
+
 func sortedServiceIDs(m *rt.Manager) []string {
 	serviceIDs := m.Active()
 	sort.Strings(serviceIDs)
 	return serviceIDs
 }
+
+func TestManagerStall(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		client   = fixedResponseClient{code: http.StatusOK, response: `{"Timestamp":0}`} // never advances
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache)}
+		products = newMockProductCache()
+		manager  = rt.NewManager(cache, client, "token", registry, options, products, log.NewNopLogger(), rt.WithStallWindow(250*time.Millisecond))
+	)
+	defer manager.StopAll()
+
+	products.update(api.ProductOriginInspector, false)
+	products.update(api.ProductDomainInspector, false)
+	cache.update([]api.Service{s1})
+	manager.Refresh()
+
+	if want, have := 0, manager.RestartStalled(); want != have {
+		t.Errorf("stalled right away: want %d, have %d", want, have)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if want, have := 1, manager.RestartStalled(); want != have {
+		t.Fatalf("stalled after the window: want %d, have %d", want, have)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for manager.Status().Restarts < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want, have := 1, manager.Status().Restarts; want != have {
+		t.Errorf("restarts: want %d, have %d", want, have)
+	}
+	if want, have := 0, manager.RestartStalled(); want != have {
+		t.Errorf("stalled after the restart: want %d, have %d", want, have)
+	}
+
+	metrics := prometheus.NewRegistry()
+	metrics.MustRegister(manager.Collector("fastly", "rt"))
+	fastlytest.AssertMetric(t, metrics, `fastly_rt_subscriber_stalls_total{product="default"}`, 1)
+}
+
+func TestManagerProgress(t *testing.T) {
+	var (
+		cache    = &mockCache{}
+		s1       = api.Service{ID: "101010", Name: "service 1", Version: 1}
+		client   = &sequenceClient{responses: []fixedResponseClient{{200, `{"Timestamp":101,"Data":[{"recorded":100,"aggregated":{"requests":1}},{"recorded":101,"aggregated":{"requests":2}}]}`}}}
+		registry = prom.NewRegistry("v0.0.0-DEV", "namespace", "subsystem", filter.Filter{})
+		options  = []rt.SubscriberOption{rt.WithMetadataProvider(cache), rt.WithAggregateOnly(true)}
+		products = newMockProductCache()
+		manager  = rt.NewManager(cache, client, "token", registry, options, products, log.NewNopLogger())
+		want     = []state.Progress{{ServiceID: s1.ID, Product: api.ProductDefault, Recorded: 101}}
+	)
+	defer manager.StopAll()
+
+	products.update(api.ProductOriginInspector, false)
+	products.update(api.ProductDomainInspector, false)
+	cache.update([]api.Service{s1})
+	manager.RestoreProgress([]state.Progress{{ServiceID: s1.ID, Product: api.ProductDefault, Recorded: 100}}) // e.g. by a previous exporter
+	manager.Refresh()
+
+	series := `namespace_subsystem_requests_total{datacenter="aggregate",service_id="101010",service_name="service 1"}`
+	deadline := time.Now().Add(time.Second)
+	for fastlytest.Metrics(t, registry, "namespace_subsystem_requests_total")[series] == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	fastlytest.AssertMetric(t, registry, series, 2) // only second 101
+	if have := manager.Progress(); !cmp.Equal(want, have) {
+		t.Fatal(cmp.Diff(want, have))
+	}
+
+	manager.StopAll()
+	if have := manager.Progress(); !cmp.Equal(want, have) {
+		t.Errorf("after stopping: %s", cmp.Diff(want, have))
+	}
+}
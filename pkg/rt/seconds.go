@@ -0,0 +1,47 @@
+package rt
+
+// dedupWindow is how many seconds back processed seconds are remembered. It's
+// comfortably longer than the history rt.fastly.com serves. Older seconds are
+// always considered processed.
+const dedupWindow = 600
+
+// processedSeconds tracks the seconds of real-time data a subscriber processed
+// for a product, so that seconds which are served again, e.g. by a backfill, a
+// retried request, or a misbehaving proxy, aren't counted twice.
+type processedSeconds struct {
+	seen   map[uint64]struct{}
+	floor  uint64 // every second up to and including it counts as processed
+	latest uint64
+}
+
+func newProcessedSeconds(floor uint64) *processedSeconds {
+	return &processedSeconds{seen: map[uint64]struct{}{}, floor: floor, latest: floor}
+}
+
+// check returns true if the second was already processed. Otherwise, it marks
+// the second as processed, and returns false.
+func (p *processedSeconds) check(recorded uint64) bool {
+	if recorded <= p.floor || (p.latest > dedupWindow && recorded <= p.latest-dedupWindow) {
+		return true
+	}
+	if _, ok := p.seen[recorded]; ok {
+		return true
+	}
+	p.seen[recorded] = struct{}{}
+	if recorded > p.latest {
+		p.latest = recorded
+	}
+	return false
+}
+
+// prune forgets the seconds which are out of the window.
+func (p *processedSeconds) prune() {
+	if p.latest <= dedupWindow {
+		return
+	}
+	for recorded := range p.seen {
+		if recorded <= p.latest-dedupWindow {
+			delete(p.seen, recorded)
+		}
+	}
+}
@@ -9,12 +9,13 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/fastly/fastly-exporter/pkg/domain"
-	"github.com/fastly/fastly-exporter/pkg/origin"
+	"github.com/fastly/fastly-exporter/pkg/product"
 	"github.com/fastly/fastly-exporter/pkg/prom"
-	"github.com/fastly/fastly-exporter/pkg/realtime"
+	"github.com/fastly/fastly-exporter/pkg/retry"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	jsoniter "github.com/json-iterator/go"
@@ -36,16 +37,23 @@ type MetadataProvider interface {
 // the received stats data to Prometheus metrics.
 type Subscriber struct {
 	client        HTTPClient
+	baseURL       string
 	token         string
 	serviceID     string
 	provider      MetadataProvider
 	metrics       *prom.Metrics
 	postprocess   func()
 	logger        log.Logger
-	rtDelayCount  int
-	oiDelayCount  int
-	diDelayCount  int
+	limiter       *Limiter
+	retryPolicy   retry.Policy
 	aggregateOnly bool
+	backfill      bool
+	selfMetrics   *SubscriberMetrics
+	failing       atomic.Bool
+	lastAdvance   atomic.Int64 // unix nanos
+
+	processedMtx sync.Mutex
+	processed    map[string]*processedSeconds // by product, kept across runs
 }
 
 // SubscriberOption provides some additional behavior to a subscriber.
@@ -79,18 +87,69 @@ func WithAggregateOnly(aggregateOnly bool) SubscriberOption {
 	return func(s *Subscriber) { s.aggregateOnly = aggregateOnly }
 }
 
+// WithLimiter sets a limiter which the subscriber acquires before each request,
+// and releases once the response has been read. Share a limiter between
+// subscribers to give them a common budget. By default, requests aren't
+// limited.
+func WithLimiter(l *Limiter) SubscriberOption {
+	return func(s *Subscriber) { s.limiter = l }
+}
+
+// WithRetryPolicy sets the backoff after failed requests, and the circuit
+// breaker which stops polling an endpoint that keeps failing. Delays requested
+// by rt.fastly.com via Retry-After or rate limit headers are always honored.
+// By default, retry.DefaultPolicy is used.
+func WithRetryPolicy(p retry.Policy) SubscriberOption {
+	return func(s *Subscriber) { s.retryPolicy = p }
+}
+
+// WithBackfill sets whether the subscriber requests the recent history of the
+// endpoint, about the last 120 seconds, when it starts running and after failed
+// requests, so that data isn't lost across restarts and outages. Data which was
+// already processed is skipped. By default, backfill is enabled.
+func WithBackfill(enabled bool) SubscriberOption {
+	return func(s *Subscriber) { s.backfill = enabled }
+}
+
+// WithSubscriberMetrics sets the metrics which instrument the requests of the
+// subscriber, and the processing of their responses. By default, the
+// subscriber isn't instrumented.
+func WithSubscriberMetrics(m *SubscriberMetrics) SubscriberOption {
+	return func(s *Subscriber) { s.selfMetrics = m }
+}
+
+// WithProcessed marks the seconds of real-time data of the product up to and
+// including recorded as processed, e.g. as persisted by a previous exporter, so
+// that they aren't counted again by a backfill. By default, no seconds are
+// marked as processed.
+func WithProcessed(productName string, recorded uint64) SubscriberOption {
+	return func(s *Subscriber) { s.processed[productName] = newProcessedSeconds(recorded) }
+}
+
+// DefaultBaseURL is the base URL of the Fastly real-time stats API.
+const DefaultBaseURL = "https://rt.fastly.com"
+
+// WithBaseURL sets the base URL of the real-time stats API, e.g. to send
+// requests to a local stand-in. By default, DefaultBaseURL is used.
+func WithBaseURL(baseURL string) SubscriberOption {
+	return func(s *Subscriber) { s.baseURL = strings.TrimRight(baseURL, "/") }
+}
+
 // NewSubscriber returns a ready-to-use subscriber. Callers must be sure to
 // invoke the Run method of the returned subscriber in order to actually update
 // any metrics.
 func NewSubscriber(client HTTPClient, token, serviceID string, metrics *prom.Metrics, options ...SubscriberOption) *Subscriber {
 	s := &Subscriber{
 		client:      client,
+		baseURL:     DefaultBaseURL,
 		token:       token,
 		serviceID:   serviceID,
 		metrics:     metrics,
 		provider:    nopMetadataProvider{},
 		postprocess: func() {},
 		logger:      log.NewNopLogger(),
+		backfill:    true,
+		processed:   map[string]*processedSeconds{},
 	}
 	for _, option := range options {
 		option(s)
@@ -98,72 +157,48 @@ func NewSubscriber(client HTTPClient, token, serviceID string, metrics *prom.Met
 	return s
 }
 
-// RunRealtime polls rt.fastly.com in a hot loop, collecting real-time stats and
-// emitting them to the Prometheus metrics provided to the constructor. The
-// method returns when the context is canceled, or a non-recoverable error
-// occurs.
-func (s *Subscriber) RunRealtime(ctx context.Context) error {
-	var ts uint64
+// Run polls the endpoint of the product in a hot loop, collecting its
+// real-time stats and emitting them to the Prometheus metrics provided to the
+// constructor. The method returns when the context is canceled, or a
+// non-recoverable error occurs.
+func (s *Subscriber) Run(ctx context.Context, p product.Product) error {
+	var (
+		ts         uint64
+		delayCount int
+		name       string
+		breaker    = retry.NewBreaker(s.retryPolicy)
+		backfill   = s.backfill // on startup
+	)
+	s.lastAdvance.Store(time.Now().UnixNano())
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 
 		default:
-			name, result, delay, newts, fatal := s.queryRealtime(ctx, ts)
-			s.metrics.Realtime.RealtimeAPIRequestsTotal.WithLabelValues(s.serviceID, name, string(result)).Inc()
-			if fatal != nil {
-				return fatal
+			previousName := name
+			if previousName != "" { // so that probes of a half-open circuit are visible while in flight
+				s.metrics.CircuitState.WithLabelValues(s.serviceID, previousName, p.Name()).Set(float64(breaker.State(time.Now())))
 			}
-			s.metrics.LastSuccessfulResponse.WithLabelValues(s.serviceID, name).Set(float64(time.Now().Unix()))
-			if delay > 0 {
-				contextSleep(ctx, delay)
+			currentName, result, delay, newts, fatal := s.query(ctx, p, ts, backfill, &delayCount)
+			name = currentName
+			backfill = s.backfill && result.failed() // after reconnecting
+			if p.Name() == product.Realtime.Name() { // the only product with a requests counter
+				s.metrics.Realtime.RealtimeAPIRequestsTotal.WithLabelValues(s.serviceID, name, string(result)).Inc()
 			}
-			ts = newts
-		}
-	}
-}
-
-// RunOrigins polls rt.fastly.com, collecting real-time origin stats and
-// emits them to the Prometheus metrics provided to the constructor. The
-// method returns when the context is canceled, or a non-recoverable error
-// occurs.
-func (s *Subscriber) RunOrigins(ctx context.Context) error {
-	var ts uint64
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			name, _, delay, newts, fatal := s.queryOrigins(ctx, ts)
+			s.failing.Store(result.failed())
 			if fatal != nil {
 				return fatal
 			}
 			s.metrics.LastSuccessfulResponse.WithLabelValues(s.serviceID, name).Set(float64(time.Now().Unix()))
-			if delay > 0 {
-				contextSleep(ctx, delay)
+			delay = s.backoff(breaker, result, delay)
+			if previousName != "" && previousName != name {
+				s.metrics.CircuitState.DeleteLabelValues(s.serviceID, previousName, p.Name())
 			}
-			ts = newts
-		}
-	}
-}
-
-// RunDomains polls rt.fastly.com, collecting real-time domain stats and
-// emits them to the Prometheus metrics provided to the constructor. The
-// method returns when the context is canceled, or a non-recoverable error
-// occurs.
-func (s *Subscriber) RunDomains(ctx context.Context) error {
-	var ts uint64
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			name, _, delay, newts, fatal := s.queryDomains(ctx, ts)
-			if fatal != nil {
-				return fatal
+			s.metrics.CircuitState.WithLabelValues(s.serviceID, name, p.Name()).Set(float64(breaker.State(time.Now())))
+			if newts > ts {
+				s.lastAdvance.Store(time.Now().UnixNano())
 			}
-			s.metrics.LastSuccessfulResponse.WithLabelValues(s.serviceID, name).Set(float64(time.Now().Unix()))
 			if delay > 0 {
 				contextSleep(ctx, delay)
 			}
@@ -172,208 +207,213 @@ func (s *Subscriber) RunDomains(ctx context.Context) error {
 	}
 }
 
-// queryRealtime fetches real-time stats from rt.fastly.com for the service ID
-// represented by the subscriber, and with the provided starting timestamp. The
-// function may block for several seconds; cancel the context to provoke early
-// termination. On success, the received real-time data is processed, and the
-// Prometheus metrics related to the Fastly service are updated.
-//
-// Returns the current name of the service, the broad class of result of the API
-// request, any delay that should pass before queryRealtime is invoked again,
-// the new timestamp that should be provided to the next call to queryRealtime,
-// and an error. Recoverable errors are logged internally and not returned, so
-// any non-nil error returned by this method should be considered fatal to the
-// subscriber.
-func (s *Subscriber) queryRealtime(ctx context.Context, ts uint64) (currentName string, result apiResult, delay time.Duration, newts uint64, fatal error) {
-	name, ver, found := s.provider.Metadata(s.serviceID)
-	version := strconv.Itoa(ver)
-	if !found {
-		name, version = s.serviceID, "unknown"
+// backoff records the result of a query with the breaker, and returns how long
+// to wait before the next one: at least the delay of the query, and, after
+// failures, the backoff of the retry policy, or the cooldown of the breaker if
+// the failure opened it.
+func (s *Subscriber) backoff(breaker *retry.Breaker, result apiResult, delay time.Duration) time.Duration {
+	if !result.failed() {
+		breaker.Success()
+		return delay
 	}
-	s.metrics.ServiceInfo.WithLabelValues(s.serviceID, name, version).Set(1)
 
-	// rt.fastly.com blocks until it has data to return.
-	// It's safe to call in a (single-threaded!) hot loop.
-	u := fmt.Sprintf("https://rt.fastly.com/v1/channel/%s/ts/%d", url.QueryEscape(s.serviceID), ts)
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		return name, apiResultError, 0, ts, fmt.Errorf("error constructing real-time stats API request: %w", err)
+	now := time.Now()
+	wasOpen := breaker.State(now) != retry.Closed
+	breaker.Failure(now)
+	if wait := breaker.Wait(now); wait > 0 {
+		if !wasOpen {
+			level.Warn(s.logger).Log("circuit", retry.Open, "failures", breaker.Failures(), "cooldown", wait)
+		}
+		if wait > delay {
+			delay = wait
+		}
 	}
-
-	req.Header.Set("Fastly-Key", s.token)
-	req.Header.Set("Accept", "application/json")
-	resp, err := s.client.Do(req.WithContext(ctx))
-	if err != nil {
-		levelForError(s.logger, err).Log("during", "execute request", "err", err)
-		return name, apiResultError, time.Second, ts, nil
+	if backoff := s.retryPolicy.Backoff(breaker.Failures()); backoff > delay {
+		delay = backoff
 	}
+	return delay
+}
 
-	var response realtime.Response
-	if err := jsoniterAPI.NewDecoder(resp.Body).Decode(&response); err != nil {
-		resp.Body.Close()
-		level.Error(s.logger).Log("during", "decode response", "err", err)
-		return name, apiResultError, time.Second, ts, nil
-	}
-	resp.Body.Close()
+// RunRealtime polls the real-time stats endpoint. See Run.
+func (s *Subscriber) RunRealtime(ctx context.Context) error {
+	return s.Run(ctx, product.Realtime)
+}
 
-	apiErr := response.Error
-	if apiErr == "" {
-		apiErr = "<none>"
-	}
+// RunOrigins polls the origin inspector endpoint. See Run.
+func (s *Subscriber) RunOrigins(ctx context.Context) error {
+	return s.Run(ctx, product.Origin)
+}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		level.Debug(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr)
-		if strings.Contains(apiErr, "No data available") {
-			delay = s.rtDelay()
-			result = apiResultNoData
-		} else {
-			s.rtDelayCount = 0
-			result = apiResultSuccess
-		}
-		realtime.Process(&response, s.serviceID, name, version, s.metrics.Realtime, s.aggregateOnly)
-		s.postprocess()
+// RunDomains polls the domain inspector endpoint. See Run.
+func (s *Subscriber) RunDomains(ctx context.Context) error {
+	return s.Run(ctx, product.Domain)
+}
 
-	case http.StatusUnauthorized, http.StatusForbidden:
-		result = apiResultError
-		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr, "msg", "token may be invalid")
-		delay = 120 * time.Second
+// LastAdvance returns when the subscriber last received a response with a newer
+// timestamp than the one it requested, or when it started running, if later.
+// Subscribers which don't advance are stalled, e.g. blocked in a request, or
+// polling a server which keeps returning the same timestamp.
+func (s *Subscriber) LastAdvance() time.Time {
+	return time.Unix(0, s.lastAdvance.Load())
+}
 
-	default:
-		result = apiResultUnknown
-		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr)
-		delay = 5 * time.Second
+// Processed returns the latest second of real-time data of the product which
+// the subscriber processed, or which was marked as processed via WithProcessed.
+// It's zero if there is none.
+func (s *Subscriber) Processed(productName string) uint64 {
+	s.processedMtx.Lock()
+	defer s.processedMtx.Unlock()
+	if processed, ok := s.processed[productName]; ok {
+		return processed.latest
 	}
+	return 0
+}
 
-	return name, result, delay, response.Timestamp, nil
+// Failing returns true if the most recent request to rt.fastly.com failed, e.g.
+// because the token is invalid. Requests which returned no data don't count as
+// failures.
+func (s *Subscriber) Failing() bool {
+	return s.failing.Load()
 }
 
-func (s *Subscriber) queryOrigins(ctx context.Context, ts uint64) (currentName string, result apiResult, delay time.Duration, newts uint64, fatal error) {
+// query fetches the real-time stats of the product from rt.fastly.com for the
+// service ID represented by the subscriber, and with the provided starting
+// timestamp, or the recent history of the endpoint if backfill is set. Data
+// which was already processed is skipped. The function may block for several seconds; cancel the context to
+// provoke early termination. On success, the received data is processed, and
+// the Prometheus metrics related to the Fastly service are updated. The delay
+// count tracks consecutive responses without data, to back off between them.
+//
+// Returns the current name of the service, the broad class of result of the API
+// request, the minimum delay that should pass before query is invoked again,
+// which Run extends with backoff after failures, the new timestamp that should
+// be provided to the next call to query, and an error. Recoverable errors are
+// logged internally and not returned, so any non-nil error returned by this
+// method should be considered fatal to the subscriber.
+func (s *Subscriber) query(ctx context.Context, p product.Product, ts uint64, backfill bool, delayCount *int) (currentName string, result apiResult, delay time.Duration, newts uint64, fatal error) {
 	name, ver, found := s.provider.Metadata(s.serviceID)
 	version := strconv.Itoa(ver)
 	if !found {
 		name, version = s.serviceID, "unknown"
 	}
 	s.metrics.ServiceInfo.WithLabelValues(s.serviceID, name, version).Set(1)
+	s.metrics.TrackServiceInfo(s.serviceID, name, version)
 
 	// rt.fastly.com blocks until it has data to return.
 	// It's safe to call in a (single-threaded!) hot loop.
-	u := fmt.Sprintf("https://rt.fastly.com/v1/origins/%s/ts/%d", url.QueryEscape(s.serviceID), ts)
+	since := strconv.FormatUint(ts, 10)
+	if backfill {
+		since = "h" // the last 120 seconds
+	}
+	u := fmt.Sprintf("%s%s/%s/ts/%s", s.baseURL, p.Path(), url.QueryEscape(s.serviceID), since)
 	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
-		return name, apiResultError, 0, ts, fmt.Errorf("error constructing origins API request: %w", err)
+		return name, apiResultError, 0, ts, fmt.Errorf("error constructing %s API request: %w", p.Name(), err)
+	}
+
+	release := func() {}
+	if s.limiter != nil {
+		if release, err = s.limiter.Acquire(ctx, s.serviceID); err != nil {
+			levelForError(s.logger, err).Log("during", "wait for limiter", "err", err)
+			return name, apiResultError, 0, ts, nil
+		}
 	}
 
 	req.Header.Set("Fastly-Key", s.token)
 	req.Header.Set("Accept", "application/json")
+	begin := time.Now()
 	resp, err := s.client.Do(req.WithContext(ctx))
 	if err != nil {
+		release()
 		levelForError(s.logger, err).Log("during", "execute request", "err", err)
-		return name, apiResultError, time.Second, ts, nil
+		return name, apiResultError, 0, ts, nil
 	}
+	s.selfMetrics.observeRequest(p.Name(), time.Since(begin))
 
-	var response origin.Response
-	if err := jsoniterAPI.NewDecoder(resp.Body).Decode(&response); err != nil {
+	after := retry.After(resp, time.Now())
+	if resp.StatusCode == http.StatusTooManyRequests {
 		resp.Body.Close()
-		level.Error(s.logger).Log("during", "decode response", "err", err)
-		return name, apiResultError, time.Second, ts, nil
+		release()
+		level.Warn(s.logger).Log("status_code", resp.StatusCode, "msg", "rate limited", "retry_after", after)
+		return name, apiResultError, after, ts, nil
 	}
+
+	var (
+		response = p.NewResponse()
+		body     = &countingReader{r: resp.Body}
+	)
+	begin = time.Now()
+	err = jsoniterAPI.NewDecoder(body).Decode(response)
 	resp.Body.Close()
+	release()
+	s.selfMetrics.observeDecode(p.Name(), time.Since(begin), body.n)
+	if err != nil {
+		level.Error(s.logger).Log("during", "decode response", "err", err)
+		return name, apiResultError, after, ts, nil
+	}
 
-	apiErr := response.Error
+	apiErr := response.Message()
 	if apiErr == "" {
 		apiErr = "<none>"
 	}
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		level.Debug(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr)
+		level.Debug(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Next(), "err", apiErr)
 		if strings.Contains(apiErr, "No data available") {
-			delay = s.oiDelay()
+			delay = noDataDelay(delayCount)
 			result = apiResultNoData
 		} else {
-			s.oiDelayCount = 0
+			*delayCount = 0
 			result = apiResultSuccess
 		}
-		origin.Process(&response, s.serviceID, name, version, s.metrics.Origin, s.aggregateOnly)
+		s.trim(p, response, name, backfill)
+		begin = time.Now()
+		s.metrics.Process(p, response, s.serviceID, name, version, s.aggregateOnly)
+		if result == apiResultSuccess {
+			s.selfMetrics.observeProcess(p.Name(), time.Since(begin), response.Next(), response.Delay())
+		}
 		s.postprocess()
 
 	case http.StatusUnauthorized, http.StatusForbidden:
 		result = apiResultError
-		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr, "msg", "token may be invalid")
+		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Next(), "err", apiErr, "msg", "token may be invalid")
 		delay = 120 * time.Second
 
 	default:
 		result = apiResultUnknown
-		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr)
-		delay = 30 * time.Second
+		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Next(), "err", apiErr)
+		delay = p.ErrorDelay()
+		if after > delay {
+			delay = after
+		}
 	}
 
-	return name, result, delay, response.Timestamp, nil
+	return name, result, delay, response.Next(), nil
 }
 
-func (s *Subscriber) queryDomains(ctx context.Context, ts uint64) (currentName string, result apiResult, delay time.Duration, newts uint64, fatal error) {
-	name, ver, found := s.provider.Metadata(s.serviceID)
-	version := strconv.Itoa(ver)
-	if !found {
-		name, version = s.serviceID, "unknown"
-	}
-	s.metrics.ServiceInfo.WithLabelValues(s.serviceID, name, version).Set(1)
+// trim drops the seconds of the response which were already processed, and
+// counts them as duplicates, unless they were requested as part of a backfill.
+func (s *Subscriber) trim(p product.Product, response product.Response, name string, backfill bool) {
+	s.processedMtx.Lock()
+	defer s.processedMtx.Unlock()
 
-	// rt.fastly.com blocks until it has data to return.
-	// It's safe to call in a (single-threaded!) hot loop.
-	u := fmt.Sprintf("https://rt.fastly.com/v1/domains/%s/ts/%d", url.QueryEscape(s.serviceID), ts)
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		return name, apiResultError, 0, ts, fmt.Errorf("error constructing domains API request: %w", err)
+	processed, ok := s.processed[p.Name()]
+	if !ok {
+		processed = newProcessedSeconds(0)
+		s.processed[p.Name()] = processed
 	}
+	dropped := response.Trim(processed.check)
+	processed.prune()
 
-	req.Header.Set("Fastly-Key", s.token)
-	req.Header.Set("Accept", "application/json")
-	resp, err := s.client.Do(req.WithContext(ctx))
-	if err != nil {
-		levelForError(s.logger, err).Log("during", "execute request", "err", err)
-		return name, apiResultError, time.Second, ts, nil
-	}
-
-	var response domain.Response
-	if err := jsoniterAPI.NewDecoder(resp.Body).Decode(&response); err != nil {
-		resp.Body.Close()
-		level.Error(s.logger).Log("during", "decode response", "err", err)
-		return name, apiResultError, time.Second, ts, nil
+	if backfill { // overlap with the processed seconds is expected
+		level.Debug(s.logger).Log("during", "backfill", "type", p.Name(), "skipped", dropped)
+		return
 	}
-	resp.Body.Close()
-
-	apiErr := response.Error
-	if apiErr == "" {
-		apiErr = "<none>"
+	if dropped > 0 {
+		level.Debug(s.logger).Log("during", "deduplication", "type", p.Name(), "skipped", dropped, "msg", "response repeated processed seconds")
+		s.metrics.DuplicateSamplesTotal.WithLabelValues(s.serviceID, name, p.Name()).Add(float64(dropped))
 	}
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		level.Debug(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr)
-		if strings.Contains(apiErr, "No data available") {
-			delay = s.diDelay()
-			result = apiResultNoData
-		} else {
-			s.diDelayCount = 0
-			result = apiResultSuccess
-		}
-		domain.Process(&response, s.serviceID, name, version, s.metrics.Domain, s.aggregateOnly)
-		s.postprocess()
-
-	case http.StatusUnauthorized, http.StatusForbidden:
-		result = apiResultError
-		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr, "msg", "token may be invalid")
-		delay = 120 * time.Second
-
-	default:
-		result = apiResultUnknown
-		level.Error(s.logger).Log("status_code", resp.StatusCode, "response_ts", response.Timestamp, "err", apiErr)
-		delay = 5 * time.Second
-	}
-
-	return name, result, delay, response.Timestamp, nil
 }
 
 //
@@ -391,6 +431,10 @@ const (
 	apiResultSuccess apiResult = "success"
 )
 
+func (r apiResult) failed() bool {
+	return r == apiResultError || r == apiResultUnknown
+}
+
 type nopMetadataProvider struct{}
 
 func (nopMetadataProvider) Metadata(string) (string, int, bool) { return "", 0, false }
@@ -421,31 +465,13 @@ func levelForError(base log.Logger, err error) log.Logger {
 
 const maxDelayCount = 5
 
-func (s *Subscriber) rtDelay() time.Duration {
-	s.rtDelayCount++
-	if s.rtDelayCount > maxDelayCount {
-		s.rtDelayCount = maxDelayCount
-	}
-
-	return time.Duration(cube(s.rtDelayCount)+((rand.Intn(10)+1)*(s.rtDelayCount))) * time.Second
-}
-
-func (s *Subscriber) oiDelay() time.Duration {
-	s.oiDelayCount++
-	if s.oiDelayCount > maxDelayCount {
-		s.oiDelayCount = maxDelayCount
-	}
-
-	return time.Duration(cube(s.oiDelayCount)+((rand.Intn(10)+1)*(s.oiDelayCount))) * time.Second
-}
-
-func (s *Subscriber) diDelay() time.Duration {
-	s.diDelayCount++
-	if s.diDelayCount > maxDelayCount {
-		s.diDelayCount = maxDelayCount
+func noDataDelay(delayCount *int) time.Duration {
+	*delayCount++
+	if *delayCount > maxDelayCount {
+		*delayCount = maxDelayCount
 	}
 
-	return time.Duration(cube(s.diDelayCount)+((rand.Intn(10)+1)*(s.diDelayCount))) * time.Second
+	return time.Duration(cube(*delayCount)+((rand.Intn(10)+1)*(*delayCount))) * time.Second
 }
 
 func cube(i int) int {
@@ -0,0 +1,80 @@
+package rt
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SubscriberMetrics instruments the subscribers themselves, rather than the
+// services they poll, by product: how long their requests take, how large the
+// responses are, how long decoding and processing them takes, and how far
+// behind real time the data is. Share them between subscribers.
+type SubscriberMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+	decodeDuration  *prometheus.HistogramVec
+	processDuration *prometheus.HistogramVec
+	ingestionLag    *prometheus.HistogramVec
+	aggregateDelay  *prometheus.GaugeVec
+}
+
+// NewSubscriberMetrics returns subscriber metrics registered with r.
+func NewSubscriberMetrics(namespace, subsystem string, r prometheus.Registerer) (*SubscriberMetrics, error) {
+	m := &SubscriberMetrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "subscriber_request_duration_seconds", Help: "Time real-time API long-polls took until the response headers arrived.", Buckets: prometheus.ExponentialBuckets(0.05, 2, 10)}, []string{"product"}),
+		responseSize:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "subscriber_response_size_bytes", Help: "Size of the bodies of real-time API responses.", Buckets: prometheus.ExponentialBuckets(256, 4, 9)}, []string{"product"}),
+		decodeDuration:  prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "subscriber_decode_duration_seconds", Help: "Time it took to read and decode the bodies of real-time API responses.", Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12)}, []string{"product"}),
+		processDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "subscriber_process_duration_seconds", Help: "Time it took to update the metrics with real-time API responses.", Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12)}, []string{"product"}),
+		ingestionLag:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Subsystem: subsystem, Name: "subscriber_ingestion_lag_seconds", Help: "Time between the timestamp of real-time API responses and their processing.", Buckets: prometheus.LinearBuckets(1, 2, 15)}, []string{"product"}),
+		aggregateDelay:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Subsystem: subsystem, Name: "subscriber_aggregate_delay_seconds", Help: "Aggregate delay reported by the most recent real-time API response."}, []string{"product"}),
+	}
+	for _, c := range []prometheus.Collector{m.requestDuration, m.responseSize, m.decodeDuration, m.processDuration, m.ingestionLag, m.aggregateDelay} {
+		if err := r.Register(c); err != nil {
+			return nil, fmt.Errorf("registering subscriber metric: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *SubscriberMetrics) observeRequest(productName string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.WithLabelValues(productName).Observe(took.Seconds())
+}
+
+func (m *SubscriberMetrics) observeDecode(productName string, took time.Duration, size int64) {
+	if m == nil {
+		return
+	}
+	m.decodeDuration.WithLabelValues(productName).Observe(took.Seconds())
+	m.responseSize.WithLabelValues(productName).Observe(float64(size))
+}
+
+// observeProcess records the time processing took, and the lag and the
+// aggregate delay of the processed response, whose timestamp is ts.
+func (m *SubscriberMetrics) observeProcess(productName string, took time.Duration, ts uint64, delay time.Duration) {
+	if m == nil {
+		return
+	}
+	m.processDuration.WithLabelValues(productName).Observe(took.Seconds())
+	m.aggregateDelay.WithLabelValues(productName).Set(delay.Seconds())
+	if ts > 0 {
+		m.ingestionLag.WithLabelValues(productName).Observe(time.Since(time.Unix(int64(ts), 0)).Seconds())
+	}
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package rt_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
+	"github.com/fastly/fastly-exporter/pkg/filter"
+	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/rt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSubscriberMetrics(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ts       = time.Now().Add(-3 * time.Second).Unix()
+		response = fmt.Sprintf(`{"Timestamp":%d,"AggregateDelay":5,"Data":[{"recorded":%d,"aggregated":{"requests":1}}]}`, ts, ts)
+		client   = &sequenceClient{responses: []fixedResponseClient{{200, response}}}
+		self     = prometheus.NewRegistry()
+	)
+	subscriberMetrics, err := rt.NewSubscriberMetrics("ns", "ss", self)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		metrics     = prom.NewMetrics("ns", "ss", filter.Filter{}, prometheus.NewRegistry())
+		processed   = make(chan struct{}, 1)
+		postprocess = func() { processed <- struct{}{} }
+		options     = []rt.SubscriberOption{rt.WithSubscriberMetrics(subscriberMetrics), rt.WithPostprocess(postprocess)}
+		subscriber  = rt.NewSubscriber(client, "token", "service_id", metrics, options...)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.RunRealtime(ctx)
+
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the response")
+	}
+
+	fastlytest.AssertMetric(t, self, `ns_ss_subscriber_request_duration_seconds_count{product="default"}`, 1)
+	fastlytest.AssertMetric(t, self, `ns_ss_subscriber_decode_duration_seconds_count{product="default"}`, 1)
+	fastlytest.AssertMetric(t, self, `ns_ss_subscriber_process_duration_seconds_count{product="default"}`, 1)
+	fastlytest.AssertMetric(t, self, `ns_ss_subscriber_response_size_bytes_sum{product="default"}`, float64(len(response)))
+	fastlytest.AssertMetric(t, self, `ns_ss_subscriber_aggregate_delay_seconds{product="default"}`, 5)
+
+	lag := fastlytest.Metrics(t, self, "ns_ss_subscriber_ingestion_lag_seconds_sum")[`ns_ss_subscriber_ingestion_lag_seconds_sum{product="default"}`]
+	if lag < 2 || lag > 10 {
+		t.Errorf("ingestion lag: want about 3s, have %.3fs", lag)
+	}
+}
@@ -3,13 +3,17 @@ package rt_test
 import (
 	"context"
 	"errors"
+	"net/http"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fastly/fastly-exporter/pkg/api"
+	"github.com/fastly/fastly-exporter/pkg/fastlytest"
 	"github.com/fastly/fastly-exporter/pkg/filter"
 	"github.com/fastly/fastly-exporter/pkg/prom"
+	"github.com/fastly/fastly-exporter/pkg/retry"
 	"github.com/fastly/fastly-exporter/pkg/rt"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -342,3 +346,163 @@ func TestBadTokenNoSpam(t *testing.T) {
 		t.Fatalf("Unauthorized rt.fastly.com request count: want %d, have %d", want, have)
 	}
 }
+
+func TestSubscriberRetryAfter(t *testing.T) {
+	var (
+		client     = &rateLimitedClient{retryAfter: "2"}
+		metrics    = prom.NewMetrics("namespace", "subsystem", filter.Filter{}, prometheus.NewRegistry())
+		options    = []rt.SubscriberOption{rt.WithRetryPolicy(retry.Policy{Base: time.Millisecond, Max: time.Millisecond})}
+		subscriber = rt.NewSubscriber(client, "token", "service ID", metrics, options...)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.RunRealtime(ctx)
+
+	time.Sleep(500 * time.Millisecond)
+
+	if want, have := uint64(1), atomic.LoadUint64(&client.served); want != have {
+		t.Fatalf("rate limited rt.fastly.com request count: want %d, have %d", want, have)
+	}
+}
+
+func TestSubscriberCircuitBreaker(t *testing.T) {
+	var (
+		client     = &rateLimitedClient{}
+		registry   = prometheus.NewRegistry()
+		metrics    = prom.NewMetrics("ns", "ss", filter.Filter{}, registry)
+		policy     = retry.Policy{Base: time.Millisecond, Max: time.Millisecond, Threshold: 3, Cooldown: 300 * time.Millisecond}
+		options    = []rt.SubscriberOption{rt.WithRetryPolicy(policy)}
+		subscriber = rt.NewSubscriber(client, "token", "service_id", metrics, options...)
+		series     = `ns_ss_circuit_state{product="origin_inspector",service_id="service_id",service_name="service_id"}`
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.RunOrigins(ctx)
+
+	time.Sleep(150 * time.Millisecond)
+
+	if want, have := uint64(3), atomic.LoadUint64(&client.served); want != have {
+		t.Errorf("requests before the circuit opens: want %d, have %d", want, have)
+	}
+	fastlytest.AssertMetric(t, registry, series, float64(retry.Open))
+
+	time.Sleep(300 * time.Millisecond)
+
+	if want, have := uint64(4), atomic.LoadUint64(&client.served); want != have {
+		t.Errorf("requests after the cooldown: want %d (one probe), have %d", want, have)
+	}
+	fastlytest.AssertMetric(t, registry, series, float64(retry.Open))
+}
+
+func TestSubscriberBackfill(t *testing.T) {
+	var (
+		requests = make(chan string, 100)
+		client   = urlRecordingClient{requests: requests, next: &sequenceClient{responses: []fixedResponseClient{
+			{200, `{"Timestamp":101,"Data":[{"recorded":100,"aggregated":{"requests":1}},{"recorded":101,"aggregated":{"requests":2}}]}`},
+			{429, `{"msg":"Too Many Requests"}`},
+			{200, `{"Timestamp":102,"Data":[{"recorded":101,"aggregated":{"requests":2}},{"recorded":102,"aggregated":{"requests":4}}]}`},
+		}}}
+		registry   = prometheus.NewRegistry()
+		metrics    = prom.NewMetrics("ns", "ss", filter.Filter{}, registry)
+		options    = []rt.SubscriberOption{rt.WithAggregateOnly(true), rt.WithRetryPolicy(retry.Policy{Base: time.Millisecond, Max: time.Millisecond})}
+		subscriber = rt.NewSubscriber(client, "token", "service_id", metrics, options...)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.RunRealtime(ctx)
+
+	var have []string
+	for _, want := range []string{"/ts/h", "/ts/101", "/ts/h", "/ts/102"} { // backfill on startup, and after the failure
+		select {
+		case u := <-requests:
+			have = append(have, u)
+			if !strings.HasSuffix(u, want) {
+				t.Fatalf("requests: want suffix %s, have %v", want, have)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s, have %v", want, have)
+		}
+	}
+
+	// Seconds 100, 101, and 102, once each.
+	fastlytest.AssertMetric(t, registry, `ns_ss_requests_total{datacenter="aggregate",service_id="service_id",service_name="service_id"}`, 7)
+}
+
+func TestSubscriberDuplicates(t *testing.T) {
+	var (
+		second = `{"Timestamp":101,"Data":[{"recorded":100,"aggregated":{"requests":1}},{"recorded":101,"aggregated":{"requests":2}}]}`
+		client = &sequenceClient{responses: []fixedResponseClient{
+			{200, second},
+			{200, second}, // replayed, e.g. by a proxy
+			{200, `{"Timestamp":102,"Data":[{"recorded":102,"aggregated":{"requests":4}}]}`},
+		}}
+		registry    = prometheus.NewRegistry()
+		metrics     = prom.NewMetrics("ns", "ss", filter.Filter{}, registry)
+		processed   = make(chan struct{}, 3)
+		postprocess = func() { processed <- struct{}{} }
+		options     = []rt.SubscriberOption{rt.WithAggregateOnly(true), rt.WithPostprocess(postprocess)}
+		subscriber  = rt.NewSubscriber(client, "token", "service_id", metrics, options...)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.RunRealtime(ctx)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-processed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for response %d", i+1)
+		}
+	}
+
+	fastlytest.AssertMetric(t, registry, `ns_ss_requests_total{datacenter="aggregate",service_id="service_id",service_name="service_id"}`, 7)
+	fastlytest.AssertMetric(t, registry, `ns_ss_duplicate_samples_total{product="default",service_id="service_id",service_name="service_id"}`, 2)
+}
+
+func TestSubscriberBaseURL(t *testing.T) {
+	var (
+		requests   = make(chan string, 100)
+		client     = urlRecordingClient{requests: requests, next: fixedResponseClient{code: 200, response: `{}`}}
+		metrics    = prom.NewMetrics("ns", "ss", filter.Filter{}, prometheus.NewRegistry())
+		options    = []rt.SubscriberOption{rt.WithBaseURL("http://localhost:8081/rt/")}
+		subscriber = rt.NewSubscriber(client, "token", "service_id", metrics, options...)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.RunRealtime(ctx)
+	go subscriber.RunOrigins(ctx)
+	go subscriber.RunDomains(ctx)
+
+	want := map[string]bool{
+		"http://localhost:8081/rt/v1/channel/service_id/ts/0": true,
+		"http://localhost:8081/rt/v1/origins/service_id/ts/0": true,
+		"http://localhost:8081/rt/v1/domains/service_id/ts/0": true,
+	}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case u := <-requests:
+			delete(want, u)
+		case <-timeout:
+			t.Fatalf("no requests to %v", want)
+		}
+	}
+}
+
+type urlRecordingClient struct {
+	requests chan<- string
+	next     rt.HTTPClient
+}
+
+func (c urlRecordingClient) Do(req *http.Request) (*http.Response, error) {
+	select {
+	case c.requests <- req.URL.String():
+	default:
+	}
+	return c.next.Do(req)
+}
@@ -0,0 +1,4 @@
+// Package state persists the cumulative metrics of the exporter, i.e. its
+// counters and histograms, and the progress of its real-time subscribers, so
+// that a restarted exporter can continue its series monotonically.
+package state
@@ -0,0 +1,81 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// State is a snapshot of the cumulative metrics of the exporter, and of the
+// progress of its real-time subscribers.
+type State struct {
+	Written  time.Time  `json:"written"`
+	Series   []Series   `json:"series"`
+	Progress []Progress `json:"progress"`
+}
+
+// Series is the value of a counter or a histogram with a specific set of
+// labels. The labels don't include constant labels, e.g. the account label,
+// or service overrides.
+type Series struct {
+	Account   string            `json:"account,omitempty"`
+	Product   string            `json:"product"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value,omitempty"`
+	Histogram *Histogram        `json:"histogram,omitempty"`
+}
+
+// Histogram is the value of a histogram.
+type Histogram struct {
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+	Bounds  []float64 `json:"bounds"`  // upper bounds, excluding +Inf
+	Buckets []uint64  `json:"buckets"` // cumulative counts, by bound
+}
+
+// Progress is the latest second of real-time data which was processed for a
+// product of a service.
+type Progress struct {
+	Account   string `json:"account,omitempty"`
+	ServiceID string `json:"service_id"`
+	Product   string `json:"product"`
+	Recorded  uint64 `json:"recorded"`
+}
+
+// Load reads the state file at path. A missing file yields an empty state.
+func Load(path string) (State, error) {
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+
+	var s State
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return State{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save writes the state to the file at path, via a temporary file, so that
+// the previous state is kept if the exporter stops while writing.
+func Save(path string, s State) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
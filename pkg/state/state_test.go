@@ -0,0 +1,51 @@
+package state_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/state"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := state.Load(path)
+	if err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	if want, have := (state.State{}), s; !cmp.Equal(want, have) {
+		t.Errorf("missing file: %s", cmp.Diff(want, have))
+	}
+
+	want := state.State{
+		Written: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Series: []state.Series{
+			{Account: "a", Product: "default", Name: "fastly_rt_requests_total", Labels: map[string]string{"service_id": "AAA"}, Value: 3},
+			{Product: "default", Name: "fastly_rt_miss_duration_seconds", Labels: map[string]string{"service_id": "AAA"}, Histogram: &state.Histogram{Count: 2, Sum: 0.5, Bounds: []float64{0.1, 1}, Buckets: []uint64{1, 2}}},
+		},
+		Progress: []state.Progress{{Account: "a", ServiceID: "AAA", Product: "default", Recorded: 1000}},
+	}
+	if err := state.Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	have, err := state.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, have) {
+		t.Error(cmp.Diff(want, have))
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.Load(path); err == nil {
+		t.Error("corrupt file: want error")
+	}
+}
@@ -0,0 +1,160 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credential identifies the user or bearer token which authenticated a
+// request.
+type Credential struct {
+	Type string // "basic" or "bearer"
+	Name string // user name, or bearer token name
+}
+
+type credentialKey struct{}
+
+// CredentialFrom returns the credential which authenticated the request with
+// the given context, if any.
+func CredentialFrom(ctx context.Context) (Credential, bool) {
+	c, ok := ctx.Value(credentialKey{}).(Credential)
+	return c, ok
+}
+
+// Authenticator is an http.Handler which requires requests to authenticate
+// with one of the basic auth users or bearer tokens from the config, before
+// passing them to the next handler. If the config has neither, all requests
+// are passed through.
+type Authenticator struct {
+	next   http.Handler
+	users  map[string][]byte
+	tokens map[string][]byte
+	exempt map[string]bool
+
+	mtx      sync.Mutex
+	verified map[string][sha256.Size]byte // user to hash of last verified password
+}
+
+// AuthenticatorOption provides some additional behavior to an authenticator.
+type AuthenticatorOption func(*Authenticator)
+
+// WithUnauthenticatedPaths exempts requests for the given paths from
+// authentication, e.g. so that health checks don't need credentials.
+func WithUnauthenticatedPaths(paths ...string) AuthenticatorOption {
+	return func(a *Authenticator) {
+		for _, path := range paths {
+			a.exempt[path] = true
+		}
+	}
+}
+
+// NewAuthenticator returns an authenticator for the next handler, with the
+// users and tokens from the config.
+func NewAuthenticator(next http.Handler, config Config, options ...AuthenticatorOption) *Authenticator {
+	a := &Authenticator{
+		next:     next,
+		users:    make(map[string][]byte, len(config.BasicAuthUsers)),
+		tokens:   make(map[string][]byte, len(config.BearerTokens)),
+		exempt:   map[string]bool{},
+		verified: map[string][sha256.Size]byte{},
+	}
+	for user, hash := range config.BasicAuthUsers {
+		a.users[user] = []byte(hash)
+	}
+	for name, token := range config.BearerTokens {
+		a.tokens[name] = []byte(token)
+	}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (len(a.users) == 0 && len(a.tokens) == 0) || a.exempt[r.URL.Path] {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	credential, ok := a.authenticate(r)
+	if !ok {
+		if len(a.users) > 0 {
+			w.Header().Add("WWW-Authenticate", `Basic realm="fastly-exporter"`)
+		}
+		if len(a.tokens) > 0 {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="fastly-exporter"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	a.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), credentialKey{}, credential)))
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Credential, bool) {
+	if user, password, ok := r.BasicAuth(); ok && len(a.users) > 0 {
+		if a.verifyPassword(user, password) {
+			return Credential{Type: "basic", Name: user}, true
+		}
+		return Credential{}, false
+	}
+
+	if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "bearer ") && len(a.tokens) > 0 {
+		token := []byte(strings.TrimSpace(header[7:]))
+		var (
+			name  string
+			found bool
+		)
+		for candidate, expected := range a.tokens {
+			if subtle.ConstantTimeCompare(token, expected) == 1 {
+				name, found = candidate, true
+			}
+		}
+		return Credential{Type: "bearer", Name: name}, found
+	}
+
+	return Credential{}, false
+}
+
+// dummyHash is compared against the passwords of unknown users, so that they
+// take as long to reject as wrong passwords of known users.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("fastly-exporter"), bcrypt.DefaultCost)
+	return hash
+})
+
+// verifyPassword checks the password against the bcrypt hash of the user.
+// Since bcrypt is deliberately slow, and Prometheus sends the same credentials
+// with every scrape, the last verified password of each user is remembered.
+func (a *Authenticator) verifyPassword(user, password string) bool {
+	hash, known := a.users[user]
+	if !known {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(password))
+
+	a.mtx.Lock()
+	verified, ok := a.verified[user]
+	a.mtx.Unlock()
+	if ok && subtle.ConstantTimeCompare(sum[:], verified[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	a.mtx.Lock()
+	a.verified[user] = sum
+	a.mtx.Unlock()
+	return true
+}
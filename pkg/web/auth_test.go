@@ -0,0 +1,106 @@
+package web_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if credential, ok := web.CredentialFrom(r.Context()); ok {
+				fmt.Fprintf(w, "%s:%s", credential.Type, credential.Name)
+			}
+		})
+		config = web.Config{
+			BasicAuthUsers: map[string]string{"alice": string(hash)},
+			BearerTokens:   map[string]string{"grafana": "s3cret"},
+		}
+		auth = web.NewAuthenticator(next, config, web.WithUnauthenticatedPaths("/healthz"))
+	)
+
+	for _, testcase := range []struct {
+		name     string
+		path     string
+		setup    func(*http.Request)
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "no credentials",
+			path:     "/metrics",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "exempt path",
+			path:     "/healthz",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "basic auth",
+			path:     "/metrics",
+			setup:    func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") },
+			wantCode: http.StatusOK,
+			wantBody: "basic:alice",
+		},
+		{
+			name:     "wrong password",
+			path:     "/metrics",
+			setup:    func(r *http.Request) { r.SetBasicAuth("alice", "hunter3") },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown user",
+			path:     "/metrics",
+			setup:    func(r *http.Request) { r.SetBasicAuth("bob", "hunter2") },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "bearer token",
+			path:     "/metrics",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") },
+			wantCode: http.StatusOK,
+			wantBody: "bearer:grafana",
+		},
+		{
+			name:     "wrong bearer token",
+			path:     "/metrics",
+			setup:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cre") },
+			wantCode: http.StatusUnauthorized,
+		},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", testcase.path, nil)
+			if testcase.setup != nil {
+				testcase.setup(req)
+			}
+			rec := httptest.NewRecorder()
+			auth.ServeHTTP(rec, req)
+
+			if want, have := testcase.wantCode, rec.Code; want != have {
+				t.Fatalf("code: want %d, have %d", want, have)
+			}
+			if want, have := testcase.wantBody, rec.Body.String(); rec.Code == http.StatusOK && want != have {
+				t.Errorf("body: want %q, have %q", want, have)
+			}
+			if rec.Code == http.StatusUnauthorized && len(rec.Header().Values("WWW-Authenticate")) != 2 {
+				t.Errorf("WWW-Authenticate: want 2 challenges, have %v", rec.Header().Values("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the format of the web config file. The zero value serves plain
+// HTTP without authentication.
+type Config struct {
+	TLS            TLSConfig         `yaml:"tls_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"` // user name to bcrypt hash
+	BearerTokens   map[string]string `yaml:"bearer_tokens"`    // name to token
+	Scopes         map[string]*Scope `yaml:"scopes"`           // user or token name to scope
+}
+
+// TLSConfig configures TLS for the server. If the client CA file is set,
+// clients must present a certificate signed by one of its CAs, unless the
+// client auth type says otherwise.
+type TLSConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+}
+
+// Enabled returns true if the server should use TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+func (c TLSConfig) clientAuth() tls.ClientAuthType {
+	if c.ClientAuthType == "" {
+		if c.ClientCAFile != "" {
+			return tls.RequireAndVerifyClientCert
+		}
+		return tls.NoClientCert
+	}
+	return clientAuthTypes[c.ClientAuthType]
+}
+
+// LoadConfig reads and validates the web config file at path. Relative file
+// names in the config are resolved relative to the directory of the file.
+func LoadConfig(path string) (Config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, f := range []*string{&c.TLS.CertFile, &c.TLS.KeyFile, &c.TLS.ClientCAFile} {
+		if *f != "" && !filepath.IsAbs(*f) {
+			*f = filepath.Join(dir, *f)
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.TLS.CertFile == "" && c.TLS.KeyFile != "":
+		return errors.New("tls_server_config: key_file requires cert_file")
+	case c.TLS.CertFile != "" && c.TLS.KeyFile == "":
+		return errors.New("tls_server_config: cert_file requires key_file")
+	case !c.TLS.Enabled() && (c.TLS.ClientCAFile != "" || c.TLS.ClientAuthType != ""):
+		return errors.New("tls_server_config: client_ca_file and client_auth_type require cert_file and key_file")
+	}
+
+	if c.TLS.ClientAuthType != "" {
+		auth, ok := clientAuthTypes[c.TLS.ClientAuthType]
+		switch {
+		case !ok:
+			return fmt.Errorf("tls_server_config: invalid client_auth_type %q", c.TLS.ClientAuthType)
+		case (auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert) && c.TLS.ClientCAFile == "":
+			return fmt.Errorf("tls_server_config: client_auth_type %s requires client_ca_file", c.TLS.ClientAuthType)
+		}
+	}
+
+	for user, hash := range c.BasicAuthUsers {
+		if user == "" || hash == "" {
+			return errors.New("basic_auth_users: user names and password hashes must not be empty")
+		}
+	}
+
+	for name, token := range c.BearerTokens {
+		if name == "" || token == "" {
+			return errors.New("bearer_tokens: names and tokens must not be empty")
+		}
+	}
+
+	for name, scope := range c.Scopes {
+		_, isUser := c.BasicAuthUsers[name]
+		_, isToken := c.BearerTokens[name]
+		switch {
+		case !isUser && !isToken:
+			return fmt.Errorf("scopes: %s: no basic auth user or bearer token with this name", name)
+		case isUser && isToken:
+			return fmt.Errorf("scopes: %s: ambiguous, since both a basic auth user and a bearer token have this name", name)
+		case scope == nil:
+			return fmt.Errorf("scopes: %s: empty scope", name)
+		}
+		if err := scope.compile(); err != nil {
+			return fmt.Errorf("scopes: %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
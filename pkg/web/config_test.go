@@ -0,0 +1,96 @@
+package web_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/web"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "empty",
+			config: "",
+		},
+		{
+			name:   "full",
+			config: "tls_server_config:\n  cert_file: a.crt\n  key_file: a.key\n  client_ca_file: ca.crt\nbasic_auth_users:\n  alice: $2y$10$abc\nbearer_tokens:\n  grafana: s3cret\n",
+		},
+		{
+			name:    "unknown key",
+			config:  "tls_config:\n  cert_file: a.crt\n",
+			wantErr: "field tls_config not found",
+		},
+		{
+			name:    "cert without key",
+			config:  "tls_server_config:\n  cert_file: a.crt\n",
+			wantErr: "cert_file requires key_file",
+		},
+		{
+			name:    "client CA without TLS",
+			config:  "tls_server_config:\n  client_ca_file: ca.crt\n",
+			wantErr: "require cert_file and key_file",
+		},
+		{
+			name:    "invalid client auth type",
+			config:  "tls_server_config:\n  cert_file: a.crt\n  key_file: a.key\n  client_auth_type: Always\n",
+			wantErr: `invalid client_auth_type "Always"`,
+		},
+		{
+			name:    "verification without CA",
+			config:  "tls_server_config:\n  cert_file: a.crt\n  key_file: a.key\n  client_auth_type: RequireAndVerifyClientCert\n",
+			wantErr: "requires client_ca_file",
+		},
+		{
+			name:    "scope without credential",
+			config:  "bearer_tokens:\n  grafana: s3cret\nscopes:\n  team-a:\n    services: [AAA]\n",
+			wantErr: "no basic auth user or bearer token",
+		},
+		{
+			name:    "ambiguous scope",
+			config:  "basic_auth_users:\n  team-a: $2y$10$abc\nbearer_tokens:\n  team-a: s3cret\nscopes:\n  team-a:\n    services: [AAA]\n",
+			wantErr: "ambiguous",
+		},
+		{
+			name:    "invalid scope regex",
+			config:  "bearer_tokens:\n  team-a: s3cret\nscopes:\n  team-a:\n    service_allowlist: [\"(\"]\n",
+			wantErr: "service_allowlist",
+		},
+		{
+			name:    "empty token",
+			config:  "bearer_tokens:\n  grafana: \"\"\n",
+			wantErr: "must not be empty",
+		},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "web.yaml")
+			if err := os.WriteFile(path, []byte(testcase.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := web.LoadConfig(path)
+			switch {
+			case testcase.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case testcase.wantErr != "" && (err == nil || !strings.Contains(err.Error(), testcase.wantErr)):
+				t.Fatalf("want error containing %q, have %v", testcase.wantErr, err)
+			}
+			if config.TLS.CertFile != "" && config.TLS.CertFile != filepath.Join(dir, "a.crt") {
+				t.Errorf("cert_file: want relative to config file, have %s", config.TLS.CertFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+// Package web secures the HTTP server of the exporter with TLS, and with basic
+// or bearer token authentication.
+package web
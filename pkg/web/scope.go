@@ -0,0 +1,86 @@
+package web
+
+import (
+	"fmt"
+
+	"github.com/fastly/fastly-exporter/pkg/filter"
+)
+
+// Scope restricts the services visible to a credential. A service is visible
+// if it's in one of the accounts, if any are given, and it's either one of the
+// explicit services, or its name passes the allowlist and blocklist. Fields
+// which aren't set don't restrict anything.
+type Scope struct {
+	Accounts         []string `yaml:"accounts"`
+	Services         []string `yaml:"services"`
+	ServiceAllowlist []string `yaml:"service_allowlist"`
+	ServiceBlocklist []string `yaml:"service_blocklist"`
+
+	nameFilter filter.Filter
+}
+
+func (s *Scope) compile() error {
+	s.nameFilter = filter.Filter{}
+	for _, expr := range s.ServiceAllowlist {
+		if err := s.nameFilter.Allow(expr); err != nil {
+			return fmt.Errorf("service_allowlist: %w", err)
+		}
+	}
+	for _, expr := range s.ServiceBlocklist {
+		if err := s.nameFilter.Block(expr); err != nil {
+			return fmt.Errorf("service_blocklist: %w", err)
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the service is visible in the scope. The name is
+// only used if the service isn't one of the explicit services, and found
+// should be false if the name isn't known, in which case only explicit
+// services are visible, unless the scope doesn't filter by name.
+func (s *Scope) Allows(account, serviceID, serviceName string, found bool) bool {
+	if len(s.Accounts) > 0 && !contains(s.Accounts, account) {
+		return false
+	}
+
+	if len(s.Services) > 0 && contains(s.Services, serviceID) {
+		return true
+	}
+
+	byName := len(s.ServiceAllowlist) > 0 || len(s.ServiceBlocklist) > 0
+	switch {
+	case byName:
+		return found && s.nameFilter.Permit(serviceName)
+	case len(s.Services) > 0:
+		return false // not one of the explicit services
+	default:
+		return true // only restricted by account, if at all
+	}
+}
+
+// ScopeFor returns the scope of the credential, if it has one. Credentials
+// without a scope can see every service.
+func (c Config) ScopeFor(credential Credential) (*Scope, bool) {
+	scope, ok := c.Scopes[credential.Name]
+	if !ok {
+		return nil, false
+	}
+	switch credential.Type {
+	case "basic":
+		_, ok = c.BasicAuthUsers[credential.Name]
+	case "bearer":
+		_, ok = c.BearerTokens[credential.Name]
+	default:
+		ok = false
+	}
+	return scope, ok
+}
+
+func contains(list []string, s string) bool {
+	for _, candidate := range list {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
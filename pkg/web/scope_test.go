@@ -0,0 +1,73 @@
+package web_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fastly/fastly-exporter/pkg/web"
+)
+
+func TestScope(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "web.yaml")
+	if err := os.WriteFile(path, []byte(`
+basic_auth_users:
+  admin: $2y$10$abc
+bearer_tokens:
+  team-a: aaa
+  team-b: bbb
+  team-c: ccc
+scopes:
+  team-a:
+    services: [AAA]
+    service_allowlist: ["^team-a-"]
+  team-b:
+    accounts: [prod]
+    service_blocklist: ["-internal$"]
+  team-c:
+    accounts: [staging]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := web.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := config.ScopeFor(web.Credential{Type: "basic", Name: "admin"}); ok {
+		t.Errorf("admin: want no scope")
+	}
+	if _, ok := config.ScopeFor(web.Credential{Type: "basic", Name: "team-a"}); ok {
+		t.Errorf("basic team-a: want no scope, since team-a is a bearer token")
+	}
+
+	for _, testcase := range []struct {
+		name        string
+		account     string
+		serviceID   string
+		serviceName string
+		found       bool
+		want        bool
+	}{
+		{"team-a", "", "AAA", "", false, true},
+		{"team-a", "", "BBB", "team-a-web", true, true},
+		{"team-a", "", "BBB", "team-b-web", true, false},
+		{"team-a", "", "BBB", "", false, false},
+		{"team-b", "prod", "BBB", "team-b-web", true, true},
+		{"team-b", "prod", "BBB", "team-b-internal", true, false},
+		{"team-b", "staging", "BBB", "team-b-web", true, false},
+		{"team-c", "staging", "CCC", "", false, true},
+		{"team-c", "prod", "CCC", "", false, false},
+	} {
+		scope, ok := config.ScopeFor(web.Credential{Type: "bearer", Name: testcase.name})
+		if !ok {
+			t.Fatalf("%s: no scope", testcase.name)
+		}
+		if want, have := testcase.want, scope.Allows(testcase.account, testcase.serviceID, testcase.serviceName, testcase.found); want != have {
+			t.Errorf("%s: %s/%s (%q): want %v, have %v", testcase.name, testcase.account, testcase.serviceID, testcase.serviceName, want, have)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// TLSOption provides some additional behavior to NewTLSConfig.
+type TLSOption func(*tlsReloader)
+
+// WithReloadInterval sets how often the certificate, key, and client CA files
+// are checked for changes. By default, they're checked at most every 10s.
+func WithReloadInterval(interval time.Duration) TLSOption {
+	return func(r *tlsReloader) { r.interval = interval }
+}
+
+// NewTLSConfig returns a server TLS config for the given settings. The files
+// are checked for changes as new connections are accepted, and reloaded if
+// they've changed, so certificates can be rotated without a restart. If a
+// reload fails, the error is logged, and the previous config stays in use.
+func NewTLSConfig(c TLSConfig, logger log.Logger, options ...TLSOption) (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, errors.New("TLS isn't configured")
+	}
+
+	r := &tlsReloader{
+		config:   c,
+		interval: 10 * time.Second,
+		logger:   logger,
+	}
+	for _, option := range options {
+		option(r)
+	}
+
+	if err := r.load(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfigForClient,
+	}, nil
+}
+
+type tlsReloader struct {
+	config   TLSConfig
+	interval time.Duration
+	logger   log.Logger
+
+	mtx       sync.Mutex
+	current   *tls.Config
+	stamps    []fileStamp
+	lastCheck time.Time
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *tlsReloader) stat() []fileStamp {
+	files := r.files()
+	stamps := make([]fileStamp, len(files))
+	for i, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			stamps[i] = fileStamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	return stamps
+}
+
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if now := time.Now(); now.Sub(r.lastCheck) >= r.interval {
+		r.lastCheck = now
+		if stamps := r.stat(); !equalStamps(stamps, r.stamps) {
+			if err := r.loadLocked(stamps); err != nil {
+				level.Error(r.logger).Log("during", "TLS config reload", "err", err, "msg", "continuing with previous config")
+				r.stamps = stamps // don't retry until the files change again
+			} else {
+				level.Info(r.logger).Log("msg", "reloaded TLS config")
+			}
+		}
+	}
+
+	return r.current, nil
+}
+
+func (r *tlsReloader) load(now time.Time) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.lastCheck = now
+	return r.loadLocked(r.stat())
+}
+
+func (r *tlsReloader) loadLocked(stamps []fileStamp) error {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	next := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.config.clientAuth(),
+	}
+
+	if r.config.ClientCAFile != "" {
+		buf, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return fmt.Errorf("loading client CAs: no certificates in %s", r.config.ClientCAFile)
+		}
+		next.ClientCAs = pool
+	}
+
+	r.current, r.stamps = next, stamps
+	return nil
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package web_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fastly/fastly-exporter/pkg/web"
+	"github.com/go-kit/log"
+)
+
+func TestTLSReload(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dir      = t.TempDir()
+		certFile = filepath.Join(dir, "server.crt")
+		keyFile  = filepath.Join(dir, "server.key")
+	)
+	writeCert(t, certFile, keyFile, "first")
+
+	tlsConfig, err := web.NewTLSConfig(web.TLSConfig{CertFile: certFile, KeyFile: keyFile}, log.NewNopLogger(), web.WithReloadInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	commonName := func() string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	if want, have := "first", commonName(); want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+
+	writeCert(t, certFile, keyFile, "second")
+	if want, have := "second", commonName(); want != have {
+		t.Fatalf("after rotation: want %q, have %q", want, have)
+	}
+
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "second", commonName(); want != have {
+		t.Fatalf("after bad rotation: want %q, have %q", want, have)
+	}
+}
+
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure the modification time changes, even on coarse filesystems.
+	modTime := time.Now().Add(time.Duration(len(commonName)) * time.Second)
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}